@@ -0,0 +1,123 @@
+package jwtsecrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	// profileAccessToken restricts signed tokens to OAuth access tokens following RFC 9068.
+	profileAccessToken = "at+jwt"
+
+	// accessTokenType is the 'typ' header of access tokens, from RFC 9068.
+	accessTokenType = "at+jwt"
+)
+
+// accessTokenClaims are the claims callers can set on access tokens under the "at+jwt" profile,
+// in addition to the allowed claims.
+var accessTokenClaims = map[string]bool{
+	"client_id": true,
+	"scope":     true,
+	"auth_time": true,
+	"acr":       true,
+	"amr":       true,
+}
+
+// validateAccessTokenConfig checks that the config can be used to sign access tokens, which must have
+// the 'iss', 'iat' and 'jti' claims set by the backend.
+func validateAccessTokenConfig(config *Config) error {
+	if config.Issuer == "" && !config.DeriveIssuer {
+		return errors.New("access tokens require an issuer")
+	}
+
+	if !config.SetIAT || !config.SetJTI {
+		return errors.New("access tokens require the 'iat' and 'jti' claims to be set")
+	}
+
+	return nil
+}
+
+// validateAccessTokenClaims checks that a set of claims supplied by a caller forms a valid access token:
+// 'sub', 'aud' and 'client_id' must be set, every scope must be in the allowed scopes, and the authentication
+// claims must be well formed.
+func validateAccessTokenClaims(config *Config, claims map[string]interface{}, now time.Time) error {
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return errors.New("'sub' claim is required")
+	}
+
+	if !hasAudience(claims) {
+		return errors.New("'aud' claim is required")
+	}
+
+	if clientID, _ := claims["client_id"].(string); clientID == "" {
+		return errors.New("'client_id' claim is required")
+	}
+
+	if rawScope, ok := claims["scope"]; ok {
+		scope, ok := rawScope.(string)
+		if !ok {
+			return fmt.Errorf("'scope' claim was %T, not string", rawScope)
+		}
+
+		for _, s := range strings.Fields(scope) {
+			if !strutil.StrListContains(config.AccessTokenScopes, s) {
+				return fmt.Errorf("scope %s not permitted", s)
+			}
+		}
+	}
+
+	if rawAuthTime, ok := claims["auth_time"]; ok {
+		authTime, err := numericDate(rawAuthTime)
+		if err != nil {
+			return fmt.Errorf("'auth_time' claim: %v", err)
+		}
+		if authTime.Time().After(now) {
+			return errors.New("'auth_time' claim is in the future")
+		}
+		claims["auth_time"] = authTime
+	}
+
+	if rawACR, ok := claims["acr"]; ok {
+		if _, ok := rawACR.(string); !ok {
+			return fmt.Errorf("'acr' claim was %T, not string", rawACR)
+		}
+	}
+
+	if rawAMR, ok := claims["amr"]; ok {
+		// A single string would be accepted by schemaStrings, so check for a list first.
+		if _, ok := schemaArray(rawAMR); !ok {
+			return fmt.Errorf("'amr' claim was %T, not a list of strings", rawAMR)
+		}
+		amr, ok := schemaStrings(rawAMR)
+		if !ok {
+			return errors.New("'amr' claim must only contain strings")
+		}
+		claims["amr"] = amr
+	}
+
+	return nil
+}
+
+// numericDate converts a date set by a caller, decoded from JSON or set directly, to a NumericDate.
+func numericDate(value interface{}) (jwt.NumericDate, error) {
+	switch v := value.(type) {
+	case json.Number:
+		i, err := v.Int64()
+		if err != nil {
+			return 0, fmt.Errorf("%s is not an integer", v)
+		}
+		return jwt.NumericDate(i), nil
+	default:
+		n, ok := schemaNumber(value)
+		if !ok || n != float64(int64(n)) {
+			return 0, fmt.Errorf("%v is not an integer", value)
+		}
+		return jwt.NumericDate(int64(n)), nil
+	}
+}
@@ -0,0 +1,149 @@
+package jwtsecrets
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func configureAccessTokens(t *testing.T, b *backend, storage *logical.Storage) {
+	resp, err := writeConfig(b, storage, map[string]interface{}{
+		keyProfile:           profileAccessToken,
+		keyAccessTokenScopes: "deliver,pilot",
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestAccessTokenConfig(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	rejected := map[string]map[string]interface{}{
+		"no issuer": {keyProfile: profileAccessToken, keyIssuer: ""},
+		"no iat":    {keyProfile: profileAccessToken, keySetIAT: false},
+		"no jti":    {keyProfile: profileAccessToken, keySetJTI: false},
+	}
+
+	for name, data := range rejected {
+		resp, err := writeConfig(b, storage, data)
+		if err == nil || resp != nil && !resp.IsError() {
+			t.Errorf("%s: expected error, got resp:%#v\n", name, resp)
+		}
+	}
+
+	configureAccessTokens(t, b, storage)
+
+	// The profile's requirements are checked on every update.
+	resp, err := writeConfig(b, storage, map[string]interface{}{keyIssuer: ""})
+	if err == nil || resp != nil && !resp.IsError() {
+		t.Errorf("expected error clearing the issuer, got resp:%#v\n", resp)
+	}
+}
+
+func TestSignAccessToken(t *testing.T) {
+	b, storage := getTestBackend(t)
+	configureAccessTokens(t, b, storage)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "sign",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			"claims": map[string]interface{}{
+				"sub":       "Turanga Leela",
+				"aud":       "https://api.planetexpress.example.com",
+				"client_id": "ship-computer",
+				"scope":     "pilot deliver",
+				"auth_time": 0,
+				"acr":       "urn:planetexpress:loa:2",
+				"amr":       []interface{}{"pwd", "otp"},
+			},
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	parsed, err := jose.ParseSigned(resp.Data["token"].(string))
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal("at+jwt", parsed.Signatures[0].Header.ExtraHeaders["typ"]); diff != nil {
+		t.Error(diff)
+	}
+
+	var claims map[string]interface{}
+	if err := getSignedToken(b, storage, map[string]interface{}{
+		"sub":       "Turanga Leela",
+		"aud":       "https://api.planetexpress.example.com",
+		"client_id": "ship-computer",
+		"auth_time": 0,
+		"amr":       []interface{}{"pwd"},
+	}, &claims); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	expected := map[string]interface{}{
+		"sub":       "Turanga Leela",
+		"aud":       "https://api.planetexpress.example.com",
+		"client_id": "ship-computer",
+		"auth_time": float64(0),
+		"amr":       []interface{}{"pwd"},
+		"iss":       testIssuer,
+		"exp":       float64(5 * 60),
+		"iat":       float64(0),
+		"nbf":       float64(0),
+		"jti":       "2",
+	}
+
+	if diff := deep.Equal(expected, claims); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestRejectAccessTokens(t *testing.T) {
+	b, storage := getTestBackend(t)
+	configureAccessTokens(t, b, storage)
+
+	valid := func(claims map[string]interface{}) map[string]interface{} {
+		token := map[string]interface{}{
+			"sub":       "Turanga Leela",
+			"aud":       "https://api.planetexpress.example.com",
+			"client_id": "ship-computer",
+		}
+		for claim, value := range claims {
+			if value == nil {
+				delete(token, claim)
+			} else {
+				token[claim] = value
+			}
+		}
+		return token
+	}
+
+	rejected := map[string]map[string]interface{}{
+		"no subject":         valid(map[string]interface{}{"sub": nil}),
+		"no audience":        valid(map[string]interface{}{"aud": nil}),
+		"no client":          valid(map[string]interface{}{"client_id": nil}),
+		"scope not allowed":  valid(map[string]interface{}{"scope": "deliver self-destruct"}),
+		"scope not a string": valid(map[string]interface{}{"scope": []interface{}{"deliver"}}),
+		"future auth time":   valid(map[string]interface{}{"auth_time": 1000}),
+		"invalid auth time":  valid(map[string]interface{}{"auth_time": "yesterday"}),
+		"invalid acr":        valid(map[string]interface{}{"acr": 2}),
+		"invalid amr":        valid(map[string]interface{}{"amr": "pwd"}),
+		"claim not allowed":  valid(map[string]interface{}{"rank": "Captain"}),
+	}
+
+	for name, claims := range rejected {
+		if _, err := getRawToken(b, storage, claims); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
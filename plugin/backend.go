@@ -18,6 +18,24 @@ type backend struct {
 	keys       []*signingKey
 	keysLock   *sync.RWMutex
 	uuidGen    uuidGenerator
+
+	// keysSequence is advanced by advanceKeysSequence whenever keys is changed, so it is 0 until the first key is
+	// created. It is guarded by keysLock.
+	keysSequence int64
+
+	jwksCache     map[string]*cachedJWKS
+	jwksCacheLock *sync.Mutex
+
+	// jwksFetchLocks serializes fetches of each trusted issuer's JWKS URL. It is guarded by jwksCacheLock.
+	jwksFetchLocks map[string]*sync.Mutex
+
+	// issuerNames maps the 'iss' claim of each trusted issuer to its name. It is nil until it is loaded from storage,
+	// and is cleared whenever a trusted issuer changes. It is guarded by issuersLock.
+	issuerNames map[string]string
+	issuersLock *sync.Mutex
+
+	refreshLock *sync.Mutex
+	dpopLock    *sync.Mutex
 }
 
 // Factory returns a new backend as logical.Backend.
@@ -41,6 +59,15 @@ func makeBackend(backendUUID string) (*backend, error) {
 	b.configLock = new(sync.RWMutex)
 	b.config = DefaultConfig(backendUUID)
 
+	b.jwksCacheLock = new(sync.Mutex)
+	b.jwksCache = make(map[string]*cachedJWKS)
+	b.jwksFetchLocks = make(map[string]*sync.Mutex)
+
+	b.issuersLock = new(sync.Mutex)
+
+	b.refreshLock = new(sync.Mutex)
+	b.dpopLock = new(sync.Mutex)
+
 	b.clock = realClock{}
 	b.uuidGen = realUUIDGenerator{}
 
@@ -48,18 +75,62 @@ func makeBackend(backendUUID string) (*backend, error) {
 		BackendType: logical.TypeLogical,
 		Help:        strings.TrimSpace(backendHelp),
 		PathsSpecial: &logical.Paths{
-			Unauthenticated: []string{"jwks"},
+			Unauthenticated: []string{"jwks", "paserk", "cose_keys", "credentials/did", "revoked/tokens"},
 		},
-		Paths: []*framework.Path{
-			pathConfig(b),
-			pathJwks(b),
-			pathSign(b),
+		Paths: framework.PathAppend(
+			[]*framework.Path{
+				pathConfig(b),
+				pathJwks(b),
+				pathPaserk(b),
+				pathCOSEKeys(b),
+				pathSign(b),
+				pathVerify(b),
+				pathIntrospect(b),
+				pathRevokedTokens(b),
+				pathExchange(b),
+				pathRefresh(b),
+			},
+			pathKeys(b),
+			pathBackup(b),
+			pathIssuers(b),
+			pathCredentials(b),
+			pathClients(b),
+		),
+		Secrets: []*framework.Secret{
+			secretToken(b),
 		},
+		PeriodicFunc: b.tidy,
+		Invalidate:   b.invalidate,
 	}
 
 	return b, nil
 }
 
+// tidy removes expired token revocations, refresh tokens and DPoP proofs from storage.
+// invalidate clears what is cached from a storage entry which has been changed by another node.
+func (b *backend) invalidate(_ context.Context, key string) {
+	if strings.HasPrefix(key, issuersPrefix) {
+		b.clearIssuerNames()
+		b.clearCachedJWKS(strings.TrimPrefix(key, issuersPrefix))
+	}
+}
+
+func (b *backend) tidy(c context.Context, r *logical.Request) error {
+	if err := b.tidyRevokedTokens(c, r); err != nil {
+		return err
+	}
+
+	if err := b.tidyRefreshTokens(c, r); err != nil {
+		return err
+	}
+
+	if err := b.tidyKeyRevocations(c, r); err != nil {
+		return err
+	}
+
+	return b.tidyDPoPProofs(c, r)
+}
+
 const backendHelp = `
 The JWT secrets engine signs JWTs.
 `
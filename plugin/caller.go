@@ -0,0 +1,181 @@
+package jwtsecrets
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// audienceSourceMetadataPrefix takes the default audience from a key in the calling token's metadata.
+const audienceSourceMetadataPrefix = "metadata:"
+
+// validateAPIAddr checks that an API address is an absolute HTTP(S) URL, returning it without a trailing slash.
+func validateAPIAddr(addr string) (string, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", err
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("%q is not an HTTP or HTTPS URL", addr)
+	}
+
+	return strings.TrimSuffix(addr, "/"), nil
+}
+
+// validateAudienceSource checks that a default audience source is "metadata:<key>".
+func validateAudienceSource(source string) error {
+	if !strings.HasPrefix(source, audienceSourceMetadataPrefix) {
+		return fmt.Errorf("unknown audience source %s", source)
+	}
+
+	if strings.TrimPrefix(source, audienceSourceMetadataPrefix) == "" {
+		return errors.New("the metadata audience source must name a key")
+	}
+
+	return nil
+}
+
+// requestIssuer returns the 'iss' claim of tokens signed for a request. If DeriveIssuer is set it is the
+// URL of the mount, <api_addr>/v1/<namespace>/<mount>, so that it matches the URL OIDC discovery documents for
+// the mount are found under, and otherwise it is the configured Issuer. The mount path is relative to the
+// mount's namespace, which is taken from the config as it cannot be trusted from the request.
+func requestIssuer(config *Config, r *logical.Request) string {
+	if !config.DeriveIssuer {
+		return config.Issuer
+	}
+
+	issuer := config.APIAddr + "/v1/"
+	if config.Namespace != "" {
+		issuer += config.Namespace + "/"
+	}
+
+	return issuer + strings.TrimSuffix(r.MountPoint, "/")
+}
+
+// callerAudience returns the default 'aud' claim for the caller, taken from the source set in DefaultAudienceSource.
+func callerAudience(config *Config, caller *callerInfo) (string, error) {
+	meta, err := caller.getTokenMetadata()
+	if err != nil {
+		return "", err
+	}
+
+	key := strings.TrimPrefix(config.DefaultAudienceSource, audienceSourceMetadataPrefix)
+	if value := meta[key]; value != "" {
+		return value, nil
+	}
+
+	return "", fmt.Errorf("the calling token has no metadata %q", key)
+}
+
+// callerInfo looks up the identity of the caller of a request for claim mappings, default audiences and sign
+// policies. Each part is looked up at most once, however many of them use it.
+type callerInfo struct {
+	b      *backend
+	config *Config
+	r      *logical.Request
+
+	client *api.Client
+
+	entity       *logical.Entity
+	entityLoaded bool
+
+	groups       []string
+	groupsLoaded bool
+
+	tokenMeta       map[string]string
+	tokenMetaLoaded bool
+}
+
+func (b *backend) newCallerInfo(config *Config, r *logical.Request) *callerInfo {
+	return &callerInfo{b: b, config: config, r: r}
+}
+
+// getEntity returns the entity of the caller, or nil if the caller has no entity.
+func (c *callerInfo) getEntity() (*logical.Entity, error) {
+	if c.entityLoaded || c.r.EntityID == "" {
+		return c.entity, nil
+	}
+
+	entity, err := c.b.System().EntityInfo(c.r.EntityID)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up the calling entity: %v", err)
+	}
+
+	c.entity, c.entityLoaded = entity, true
+	return c.entity, nil
+}
+
+// getGroups returns the names of the groups the caller's entity is a member of, or nil if the caller has no entity.
+func (c *callerInfo) getGroups() ([]string, error) {
+	if c.groupsLoaded {
+		return c.groups, nil
+	}
+
+	entity, err := c.getEntity()
+	if err != nil || entity == nil {
+		return nil, err
+	}
+
+	client, err := c.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := entityGroupNames(client, entity.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up the groups of the calling entity: %v", err)
+	}
+
+	c.groups, c.groupsLoaded = groups, true
+	return c.groups, nil
+}
+
+// getTokenMetadata returns the calling token's metadata. The token is not available to plugins running outside
+// of Vault, so its metadata is looked up by its accessor instead. Entity alias metadata is never used in its place,
+// as the alias the token was issued for cannot be told apart from the caller's aliases on other auth mounts.
+func (c *callerInfo) getTokenMetadata() (map[string]string, error) {
+	if c.tokenMetaLoaded {
+		return c.tokenMeta, nil
+	}
+
+	if te := c.r.TokenEntry(); te != nil {
+		return te.Meta, nil
+	}
+
+	if c.r.ClientTokenAccessor == "" {
+		return nil, nil
+	}
+
+	client, err := c.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := lookupTokenMetadata(client, c.r.ClientTokenAccessor)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up the metadata of the calling token: %v", err)
+	}
+
+	c.tokenMeta, c.tokenMetaLoaded = meta, true
+	return c.tokenMeta, nil
+}
+
+// getClient returns the client groups and token metadata are looked up with.
+func (c *callerInfo) getClient() (*api.Client, error) {
+	if c.client != nil {
+		return c.client, nil
+	}
+
+	client, err := identityClient(c.config)
+	if err != nil {
+		return nil, err
+	}
+
+	c.client = client
+	return c.client, nil
+}
@@ -0,0 +1,181 @@
+package jwtsecrets
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const testAPIAddr = "https://vault.example.com:8200"
+
+// signAs signs a set of claims with the sign request modified by prepare, returning the decoded token.
+func signAs(b *backend, storage *logical.Storage, claims map[string]interface{}, prepare func(*logical.Request)) (*jwt.Claims, error) {
+	req := &logical.Request{
+		Operation:  logical.UpdateOperation,
+		Path:       "sign",
+		Storage:    *storage,
+		MountPoint: "jwt/",
+		Data: map[string]interface{}{
+			"claims": claims,
+		},
+	}
+	prepare(req)
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		return nil, fmt.Errorf("err:%s resp:%#v", err, resp)
+	}
+
+	token, err := jwt.ParseSigned(resp.Data["token"].(string))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing jwt: %s", err)
+	}
+
+	var decoded jwt.Claims
+	if err = token.Claims(b.keys[0].Key.Public(), &decoded); err != nil {
+		return nil, fmt.Errorf("error decoding claims: %s", err)
+	}
+
+	return &decoded, nil
+}
+
+func TestDeriveIssuer(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if resp, err := writeConfig(b, storage, map[string]interface{}{
+		keyDeriveIssuer: true,
+		keyAPIAddr:      testAPIAddr + "/",
+	}); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+
+	decoded, err := signAs(b, storage, map[string]interface{}{}, func(r *logical.Request) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := deep.Equal(testAPIAddr+"/v1/jwt", decoded.Issuer); diff != nil {
+		t.Error(diff)
+	}
+
+	// The namespace header is set by the caller, so it is ignored.
+	decoded, err = signAs(b, storage, map[string]interface{}{}, func(r *logical.Request) {
+		r.Headers = map[string][]string{"X-Vault-Namespace": {"mom-corp"}}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := deep.Equal(testAPIAddr+"/v1/jwt", decoded.Issuer); diff != nil {
+		t.Error(diff)
+	}
+
+	if resp, err := writeConfig(b, storage, map[string]interface{}{
+		keyNamespace: "planet-express/delivery/",
+	}); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+
+	decoded, err = signAs(b, storage, map[string]interface{}{}, func(r *logical.Request) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := deep.Equal(testAPIAddr+"/v1/planet-express/delivery/jwt", decoded.Issuer); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestDeriveIssuerRequiresAPIAddr(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	for _, data := range []map[string]interface{}{
+		{keyDeriveIssuer: true},
+		{keyDeriveIssuer: true, keyAPIAddr: "vault.example.com"},
+	} {
+		if resp, err := writeConfig(b, storage, data); err == nil || resp != nil && !resp.IsError() {
+			t.Errorf("%v: expected error", data)
+		}
+	}
+}
+
+func TestDefaultAudienceFromMetadata(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if resp, err := writeConfig(b, storage, map[string]interface{}{
+		keyDefaultAudienceSource: "metadata:ship",
+		keyAudiencePattern:       "^planet-express",
+	}); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+
+	decoded, err := signAs(b, storage, map[string]interface{}{}, func(r *logical.Request) {
+		r.SetTokenEntry(&logical.TokenEntry{Meta: map[string]string{"ship": "planet-express-ship"}})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := deep.Equal(jwt.Audience{"planet-express-ship"}, decoded.Audience); diff != nil {
+		t.Error(diff)
+	}
+
+	server := fakeIdentity(t)
+	defer server.Close()
+
+	if resp, err := writeConfig(b, storage, map[string]interface{}{
+		keyAPIAddr:       server.URL,
+		keyIdentityToken: testIdentityToken,
+	}); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+
+	// Without a token entry, the token's metadata is looked up by its accessor.
+	decoded, err = signAs(b, storage, map[string]interface{}{}, func(r *logical.Request) {
+		r.ClientTokenAccessor = testTokenAccessor
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := deep.Equal(jwt.Audience{"planet-express-ship-2"}, decoded.Audience); diff != nil {
+		t.Error(diff)
+	}
+
+	// The metadata of the caller's entity aliases is never used in place of the token's.
+	b.System().(*logical.StaticSystemView).EntityVal = &logical.Entity{
+		ID: "bender",
+		Aliases: []*logical.Alias{
+			{MountAccessor: testAliasAccessor, Name: "bender", Metadata: map[string]string{"ship": "planet-express-ship-3"}},
+		},
+	}
+
+	if _, err := signAs(b, storage, map[string]interface{}{}, func(r *logical.Request) {
+		r.EntityID = "bender"
+	}); err == nil {
+		t.Error("expected error signing without token metadata")
+	}
+
+	// Default audiences must still match the audience pattern.
+	if _, err := signAs(b, storage, map[string]interface{}{}, func(r *logical.Request) {
+		r.SetTokenEntry(&logical.TokenEntry{Meta: map[string]string{"ship": "nimbus"}})
+	}); err == nil {
+		t.Error("expected error signing an audience not matching the pattern")
+	}
+}
+
+func TestRejectAudienceSource(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	for _, source := range []string{"role", "policy", "metadata:"} {
+		if resp, err := writeConfig(b, storage, map[string]interface{}{
+			keyDefaultAudienceSource: source,
+		}); err == nil || resp != nil && !resp.IsError() {
+			t.Errorf("%s: expected error", source)
+		}
+	}
+}
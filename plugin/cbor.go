@@ -0,0 +1,172 @@
+package jwtsecrets
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// CBOR major types, from RFC 8949.
+const (
+	cborUnsignedInt = 0
+	cborNegativeInt = 1
+	cborByteString  = 2
+	cborTextString  = 3
+	cborArray       = 4
+	cborMap         = 5
+	cborTag         = 6
+	cborSimple      = 7
+)
+
+// cborTagged is a CBOR data item with a tag.
+type cborTagged struct {
+	number  uint64
+	content interface{}
+}
+
+// encodeCBOR encodes a value as deterministic CBOR, as described in RFC 8949 section 4.2.
+// Integers use the shortest encoding and map keys are sorted by their encoded bytes.
+// Floating point numbers with an integer value are encoded as integers, as JSON does not distinguish them.
+func encodeCBOR(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeCBOR(&buf, value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeCBOR(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteByte(cborSimple<<5 | 22)
+	case bool:
+		if v {
+			buf.WriteByte(cborSimple<<5 | 21)
+		} else {
+			buf.WriteByte(cborSimple<<5 | 20)
+		}
+	case int:
+		writeCBORInt(buf, int64(v))
+	case int64:
+		writeCBORInt(buf, v)
+	case uint64:
+		writeCBORHead(buf, cborUnsignedInt, v)
+	case float64:
+		writeCBORFloat(buf, v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			writeCBORInt(buf, i)
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		writeCBORFloat(buf, f)
+	case string:
+		writeCBORHead(buf, cborTextString, uint64(len(v)))
+		buf.WriteString(v)
+	case []byte:
+		writeCBORHead(buf, cborByteString, uint64(len(v)))
+		buf.Write(v)
+	case []string:
+		writeCBORHead(buf, cborArray, uint64(len(v)))
+		for _, item := range v {
+			if err := writeCBOR(buf, item); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		writeCBORHead(buf, cborArray, uint64(len(v)))
+		for _, item := range v {
+			if err := writeCBOR(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		entries := make(map[interface{}]interface{}, len(v))
+		for key, item := range v {
+			entries[key] = item
+		}
+		return writeCBORMap(buf, entries)
+	case map[interface{}]interface{}:
+		return writeCBORMap(buf, v)
+	case cborTagged:
+		writeCBORHead(buf, cborTag, v.number)
+		return writeCBOR(buf, v.content)
+	default:
+		return fmt.Errorf("cannot encode %T as CBOR", value)
+	}
+
+	return nil
+}
+
+func writeCBORHead(buf *bytes.Buffer, majorType byte, argument uint64) {
+	switch {
+	case argument < 24:
+		buf.WriteByte(majorType<<5 | byte(argument))
+	case argument <= math.MaxUint8:
+		buf.WriteByte(majorType<<5 | 24)
+		buf.WriteByte(byte(argument))
+	case argument <= math.MaxUint16:
+		buf.WriteByte(majorType<<5 | 25)
+		binary.Write(buf, binary.BigEndian, uint16(argument))
+	case argument <= math.MaxUint32:
+		buf.WriteByte(majorType<<5 | 26)
+		binary.Write(buf, binary.BigEndian, uint32(argument))
+	default:
+		buf.WriteByte(majorType<<5 | 27)
+		binary.Write(buf, binary.BigEndian, argument)
+	}
+}
+
+func writeCBORInt(buf *bytes.Buffer, i int64) {
+	if i < 0 {
+		writeCBORHead(buf, cborNegativeInt, uint64(-1-i))
+		return
+	}
+	writeCBORHead(buf, cborUnsignedInt, uint64(i))
+}
+
+func writeCBORFloat(buf *bytes.Buffer, f float64) {
+	if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+		writeCBORInt(buf, int64(f))
+		return
+	}
+
+	buf.WriteByte(cborSimple<<5 | 27)
+	binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+}
+
+func writeCBORMap(buf *bytes.Buffer, entries map[interface{}]interface{}) error {
+	type encodedEntry struct {
+		key   []byte
+		value interface{}
+	}
+
+	encoded := make([]encodedEntry, 0, len(entries))
+	for key, value := range entries {
+		encodedKey, err := encodeCBOR(key)
+		if err != nil {
+			return err
+		}
+		encoded = append(encoded, encodedEntry{key: encodedKey, value: value})
+	}
+
+	sort.Slice(encoded, func(i, j int) bool {
+		return bytes.Compare(encoded[i].key, encoded[j].key) < 0
+	})
+
+	writeCBORHead(buf, cborMap, uint64(len(encoded)))
+	for _, entry := range encoded {
+		buf.Write(entry.key)
+		if err := writeCBOR(buf, entry.value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package jwtsecrets
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+// Test vectors from RFC 8949 appendix A.
+func TestEncodeCBOR(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected string
+	}{
+		{0, "00"},
+		{23, "17"},
+		{24, "1818"},
+		{100, "1864"},
+		{1000, "1903e8"},
+		{1000000000000, "1b000000e8d4a51000"},
+		{-1, "20"},
+		{-1000, "3903e7"},
+		{1.1, "fb3ff199999999999a"},
+		{true, "f5"},
+		{nil, "f6"},
+		{[]byte{1, 2, 3, 4}, "4401020304"},
+		{"a", "6161"},
+		{"IETF", "6449455446"},
+		{[]interface{}{1, 2, 3}, "83010203"},
+		{map[string]interface{}{"a": 1, "b": []interface{}{2, 3}}, "a26161016162820203"},
+		{cborTagged{number: 1, content: 1363896240}, "c11a514b67b0"},
+	}
+
+	for _, test := range tests {
+		encoded, err := encodeCBOR(test.value)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		if diff := deep.Equal(test.expected, hex.EncodeToString(encoded)); diff != nil {
+			t.Errorf("%#v: %v", test.value, diff)
+		}
+	}
+}
+
+func TestEncodeCBORMapKeyOrder(t *testing.T) {
+	// Keys are sorted by their encoded bytes, so integers come before strings and shorter strings first.
+	encoded, err := encodeCBOR(map[interface{}]interface{}{
+		"aa": 4,
+		"b":  3,
+		-1:   2,
+		10:   1,
+	})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal("a40a01200261620362616104", hex.EncodeToString(encoded)); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestEncodeCBORUnsupportedType(t *testing.T) {
+	if _, err := encodeCBOR(struct{}{}); err == nil {
+		t.Error("expected an error encoding a struct")
+	}
+}
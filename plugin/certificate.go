@@ -0,0 +1,109 @@
+package jwtsecrets
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/certutil"
+)
+
+// selfSignedCAValidity is how long a CA generated by the backend is valid for.
+const selfSignedCAValidity = 10 * 365 * 24 * time.Hour
+
+// generateSelfSignedCA creates a root certificate and key for issuing signing key certificates,
+// for use when the operator has not configured a CA.
+func generateSelfSignedCA(commonName string, now time.Time) (*certutil.ParsedCertBundle, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serialNumber, err := certutil.GenerateSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	subjectKeyID, err := certutil.GetSubjKeyID(key)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now,
+		NotAfter:              now.Add(selfSignedCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SubjectKeyId:          subjectKeyID,
+	}
+
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	pemBundle := pem.EncodeToMemory(&pem.Block{Type: string(certutil.ECBlock), Bytes: keyBytes})
+	pemBundle = append(pemBundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate})...)
+
+	return certutil.ParsePEMBundle(string(pemBundle))
+}
+
+// issueCertificate creates a certificate for a signing key, returning the chain from the new certificate to the CA.
+func issueCertificate(ca *certutil.ParsedCertBundle, kid string, publicKey crypto.PublicKey, notBefore, notAfter time.Time) ([]*x509.Certificate, error) {
+	if ca == nil || ca.PrivateKey == nil {
+		return nil, errors.New("no CA configured")
+	}
+
+	serialNumber, err := certutil.GenerateSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{CommonName: kid},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	rawCertificate, err := x509.CreateCertificate(rand.Reader, template, ca.Certificate, publicKey, ca.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	certificate, err := x509.ParseCertificate(rawCertificate)
+	if err != nil {
+		return nil, err
+	}
+
+	chain := []*x509.Certificate{certificate, ca.Certificate}
+	for _, block := range ca.CAChain {
+		chain = append(chain, block.Certificate)
+	}
+
+	return chain, nil
+}
+
+// certificateThumbprints returns the SHA-1 and SHA-256 thumbprints of a certificate, as used in the 'x5t' and 'x5t#S256' parameters.
+func certificateThumbprints(certificate *x509.Certificate) ([]byte, []byte) {
+	sha1Thumbprint := sha1.Sum(certificate.Raw)
+	sha256Thumbprint := sha256.Sum256(certificate.Raw)
+	return sha1Thumbprint[:], sha256Thumbprint[:]
+}
@@ -0,0 +1,92 @@
+package jwtsecrets
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	keyCertificateThumbprint = "certificate_thumbprint"
+	keyClientCertificate     = "client_certificate"
+
+	// confirmationX5TS256 is the confirmation method for tokens bound to a client certificate, from RFC 8705.
+	confirmationX5TS256 = "x5t#S256"
+)
+
+// connectionCertificateThumbprint returns the base64url encoded SHA-256 thumbprint of the client certificate
+// the request was made with, or an empty string if the request was not made over mutual TLS. Vault only passes
+// the connection's remote address to plugins served out of process, so this is always empty unless the backend
+// runs inside Vault.
+func connectionCertificateThumbprint(r *logical.Request) string {
+	if r.Connection == nil || r.Connection.ConnState == nil || len(r.Connection.ConnState.PeerCertificates) == 0 {
+		return ""
+	}
+
+	_, thumbprint := certificateThumbprints(r.Connection.ConnState.PeerCertificates[0])
+	return base64.RawURLEncoding.EncodeToString(thumbprint)
+}
+
+// parseCertificateThumbprint checks that a thumbprint supplied by a caller is a base64url encoded SHA-256 digest.
+func parseCertificateThumbprint(thumbprint string) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(thumbprint)
+	if err != nil {
+		return err
+	}
+
+	if len(decoded) != 32 {
+		return fmt.Errorf("thumbprint is %d bytes, not 32", len(decoded))
+	}
+
+	return nil
+}
+
+// pemCertificateThumbprint returns the base64url encoded SHA-256 thumbprint of a PEM encoded certificate.
+func pemCertificateThumbprint(rawCertificate string) (string, error) {
+	block, _ := pem.Decode([]byte(rawCertificate))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", errors.New("no PEM encoded certificate found")
+	}
+
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+
+	_, thumbprint := certificateThumbprints(certificate)
+	return base64.RawURLEncoding.EncodeToString(thumbprint), nil
+}
+
+// checkCertificateBinding checks that a token bound to a client certificate by its 'cnf' claim is presented with
+// that certificate. The certificate is taken from rawCertificate if set, otherwise from the connection the request
+// was made over. Tokens which are not bound to a certificate are accepted. If the certificate does not match the
+// error is an *invalidTokenError.
+func checkCertificateBinding(r *logical.Request, token *verifiedToken, rawCertificate string) error {
+	cnf, _ := token.Claims["cnf"].(map[string]interface{})
+	bound, _ := cnf[confirmationX5TS256].(string)
+	if bound == "" {
+		return nil
+	}
+
+	presented := connectionCertificateThumbprint(r)
+	if rawCertificate != "" {
+		var err error
+		if presented, err = pemCertificateThumbprint(rawCertificate); err != nil {
+			return invalidToken(logical.ErrInvalidRequest, "invalid '%s': %v", keyClientCertificate, err)
+		}
+	}
+
+	if presented == "" {
+		return invalidToken(logical.ErrPermissionDenied, "token is bound to a certificate but no certificate was presented")
+	}
+
+	if presented != bound {
+		return invalidToken(logical.ErrPermissionDenied, "token is bound to a different certificate")
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package jwtsecrets
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func getTestClientCertificate(t *testing.T, commonName string) *x509.Certificate {
+	bundle, err := generateSelfSignedCA(commonName, time.Unix(0, 0))
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	return bundle.Certificate
+}
+
+func mtlsConnection(certificate *x509.Certificate) *logical.Connection {
+	return &logical.Connection{
+		ConnState: &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{certificate},
+		},
+	}
+}
+
+func signOverMTLS(b *backend, storage *logical.Storage, certificate *x509.Certificate) (*logical.Response, error) {
+	req := &logical.Request{
+		Operation:  logical.UpdateOperation,
+		Path:       "sign",
+		Storage:    *storage,
+		Connection: mtlsConnection(certificate),
+		Data: map[string]interface{}{
+			"claims": map[string]interface{}{"sub": "Zapp Brannigan"},
+		},
+	}
+
+	return b.HandleRequest(context.Background(), req)
+}
+
+func TestCertificateBinding(t *testing.T) {
+	b, storage := getTestBackend(t)
+	certificate := getTestClientCertificate(t, "client")
+	other := getTestClientCertificate(t, "other")
+
+	// Without the config option tokens are not bound.
+	resp, err := signOverMTLS(b, storage, certificate)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	if claims := introspectToken(t, b, storage, resp.Data["token"].(string)); claims["cnf"] != nil {
+		t.Errorf("expected token not to be bound, got %v", claims["cnf"])
+	}
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			keyBindClientCertificates: true,
+		},
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	resp, err = signOverMTLS(b, storage, certificate)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	token := resp.Data["token"].(string)
+
+	_, thumbprint := certificateThumbprints(certificate)
+	expected := map[string]interface{}{"x5t#S256": base64.RawURLEncoding.EncodeToString(thumbprint)}
+
+	if diff := deep.Equal(expected, introspectToken(t, b, storage, token)["cnf"]); diff != nil {
+		t.Error(diff)
+	}
+
+	verify := func(connection *logical.Connection, data map[string]interface{}) (*logical.Response, error) {
+		data["token"] = token
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "verify",
+			Storage:    *storage,
+			Connection: connection,
+			Data:       data,
+		})
+	}
+
+	certificatePEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw}))
+	otherPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: other.Raw}))
+
+	accepted := map[string]*logical.Connection{
+		"field":      nil,
+		"connection": mtlsConnection(certificate),
+	}
+
+	for name, connection := range accepted {
+		data := map[string]interface{}{}
+		if connection == nil {
+			data["client_certificate"] = certificatePEM
+		}
+
+		resp, err = verify(connection, data)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Errorf("%s: err:%s resp:%#v\n", name, err, resp)
+		}
+	}
+
+	rejected := map[string]struct {
+		connection *logical.Connection
+		data       map[string]interface{}
+	}{
+		"no certificate":       {nil, map[string]interface{}{}},
+		"other field":          {nil, map[string]interface{}{"client_certificate": otherPEM}},
+		"other connection":     {mtlsConnection(other), map[string]interface{}{}},
+		"field overrides conn": {mtlsConnection(certificate), map[string]interface{}{"client_certificate": otherPEM}},
+		"not a certificate":    {nil, map[string]interface{}{"client_certificate": "not a certificate"}},
+	}
+
+	for name, test := range rejected {
+		resp, err = verify(test.connection, test.data)
+		if err == nil || resp != nil && !resp.IsError() {
+			t.Errorf("%s: expected error, got resp:%#v\n", name, resp)
+		}
+	}
+}
+
+func TestSignWithCertificateThumbprint(t *testing.T) {
+	b, storage := getTestBackend(t)
+	certificate := getTestClientCertificate(t, "client")
+
+	_, digest := certificateThumbprints(certificate)
+	thumbprint := base64.RawURLEncoding.EncodeToString(digest)
+
+	// Thumbprints cannot be supplied unless the config option is set.
+	resp, err := signBound(b, storage, map[string]interface{}{"certificate_thumbprint": thumbprint})
+	if err == nil || resp != nil && !resp.IsError() {
+		t.Errorf("expected error, got resp:%#v\n", resp)
+	}
+
+	resp, err = writeConfig(b, storage, map[string]interface{}{keyAllowCertificateThumbprints: true})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	resp, err = signBound(b, storage, map[string]interface{}{"certificate_thumbprint": thumbprint})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	expected := map[string]interface{}{"x5t#S256": thumbprint}
+	if diff := deep.Equal(expected, introspectToken(t, b, storage, resp.Data["token"].(string))["cnf"]); diff != nil {
+		t.Error(diff)
+	}
+
+	resp, err = signBound(b, storage, map[string]interface{}{"certificate_thumbprint": "dG9vIHNob3J0"})
+	if err == nil || resp != nil && !resp.IsError() {
+		t.Errorf("expected error, got resp:%#v\n", resp)
+	}
+
+	// Caller supplied thumbprints cannot be allowed when the connection's certificate is bound.
+	resp, err = writeConfig(b, storage, map[string]interface{}{keyBindClientCertificates: true})
+	if err == nil || resp != nil && !resp.IsError() {
+		t.Errorf("expected error, got resp:%#v\n", resp)
+	}
+}
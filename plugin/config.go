@@ -1,9 +1,14 @@
 package jwtsecrets
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
 	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/certutil"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"gopkg.in/square/go-jose.v2"
 )
 
 // Default values for configuration options.
@@ -14,16 +19,52 @@ const (
 	DefaultSetJTI            = true
 	DefaultSetNBF            = true
 	DefaultIssuer            = "vault-plugin-secrets-jwt:UUID"
+	DefaultDeriveIssuer      = false
 	DefaultAudiencePattern   = ".*"
 	DefaultSubjectPattern    = ".*"
 	DefaultMaxAudiences      = -1
+	DefaultExportable        = false
+	DefaultKeyProvider       = "local"
+	DefaultTransitMount      = "transit"
+	DefaultPKCS11Slot        = 0
+	DefaultIssueCertificates = false
+	DefaultSetX5TS256        = false
+	DefaultSetX5T            = false
+	DefaultSigningAlgorithm  = jose.RS256
+	DefaultLeaseTokens       = false
+	DefaultRefreshTokens     = false
+	DefaultRefreshTokenTTL   = "24h0m0s"
+	DefaultRefreshMaxTTL     = "720h0m0s"
+
+	DefaultBindClientCertificates      = false
+	DefaultAllowCertificateThumbprints = false
+	DefaultProfile                     = ""
+	DefaultCWTSigningAlgorithm         = jose.ES256
+
+	DefaultKubernetesNamespacePattern      = ".*"
+	DefaultKubernetesServiceAccountPattern = ".*"
 )
 
 // DefaultAllowedClaims is the default value for the AllowedClaims config option.
 // By default only the 'aud' and 'sub' claims can be set by the caller.
 var DefaultAllowedClaims = []string{"aud", "sub"}
 
-var ReservedClaims = []string{"iss", "exp", "nbf", "iat", "jti"}
+var ReservedClaims = []string{"iss", "exp", "nbf", "iat", "jti", "cnf", "_sd", "_sd_alg"}
+
+// DefaultAllowedHeaders is the default value for the AllowedHeaders config option.
+// By default callers cannot set any headers.
+var DefaultAllowedHeaders = []string{}
+
+// DefaultAccessTokenScopes is the default value for the AccessTokenScopes config option.
+// By default no scopes can be granted.
+var DefaultAccessTokenScopes = []string{}
+
+// ReservedHeaders can never be set by callers, as they are set by the backend, identify the keys which verify
+// a token, or change how a token is processed.
+var ReservedHeaders = []string{"alg", "kid", "crit", "b64", "zip", "enc", "jwk", "jku", "x5c", "x5u", "x5t", "x5t#S256"}
+
+// generatedHeaders can never be configured, as they are set by the backend or change how a token is processed.
+var generatedHeaders = []string{"alg", "kid", "crit", "b64", "zip", "enc", "x5t", "x5t#S256"}
 
 // Config holds all configuration for the backend.
 type Config struct {
@@ -45,6 +86,22 @@ type Config struct {
 	// Issuer defines the 'iss' claim for the jwt. If blank, it is omitted.
 	Issuer string
 
+	// DeriveIssuer defines if the 'iss' claim is derived per request from APIAddr, Namespace and the mount path,
+	// in place of Issuer.
+	DeriveIssuer bool
+
+	// APIAddr is the address Vault's API is served at, as in Vault's own 'api_addr' setting, which is not
+	// available to plugins.
+	APIAddr string
+
+	// Namespace is the Vault namespace the backend is mounted in, or blank for the root namespace. Plugins are not
+	// told which namespace they are mounted in, and the namespace header of a request is set by the caller.
+	Namespace string
+
+	// DefaultAudienceSource, if set, defines where the 'aud' claim is taken from when the caller does not set it,
+	// as "metadata:<key>" for a key in the calling token's metadata.
+	DefaultAudienceSource string
+
 	// AudiencePattern defines a regular expression (https://golang.org/pkg/regexp/) which must be matched by any incoming 'aud' claims.
 	// If the audience claim is an array, each element in the array must match the pattern.
 	AudiencePattern *regexp.Regexp
@@ -55,11 +112,154 @@ type Config struct {
 	// MaxAudiences defines the maximum number of strings in the 'aud' claim.
 	MaxAudiences int
 
-	// AllowedClaims defines which claims can be set on the JWT.
+	// AllowedClaims defines which claims can be set on the JWT. Claims containing '*' are globs, matching any
+	// claim with the same parts outside of the wildcards, such as "https://example.com/claims/*".
 	AllowedClaims []string
 
+	// AllowedClaimPatterns are regular expressions matching further claims which can be set on the JWT.
+	AllowedClaimPatterns []*regexp.Regexp
+
+	// DeniedClaimValues maps claims to values they can never have. Values are compared as strings, and a claim
+	// which is a list is denied if any of its elements are. They are checked once the claims set by the caller are
+	// allowed, against every claim including those mapped and generated by the backend, before the subject,
+	// audience, profile and sign policy checks.
+	DeniedClaimValues map[string][]string
+
+	// ClaimMappings maps claims to values from the caller's identity: the entity's name or metadata, the metadata
+	// of one of its aliases, the names of its groups, or the calling token's metadata. Mapped claims can never be
+	// set by the caller, even if they are in AllowedClaims.
+	ClaimMappings map[string]string
+
+	// SignPolicies are CEL expressions which must all evaluate to true for a set of claims to be signed.
+	// They are evaluated after every other check on the claims.
+	SignPolicies []*signPolicy
+
+	// IdentityToken is the token used to read the caller's groups from the identity secrets engine, and the calling
+	// token's metadata when the token is not passed to the plugin.
+	// If blank, VAULT_TOKEN is used.
+	IdentityToken string
+
+	// Exportable defines if the keys and config can be exported with the backup endpoint.
+	// Once set it cannot be unset.
+	Exportable bool
+
+	// KeyProvider defines where signing keys are created and held, either "local", "transit" or "pkcs11".
+	KeyProvider string
+
+	// TransitAddress is the address of the Vault server hosting the Transit key. If blank, VAULT_ADDR is used.
+	TransitAddress string
+
+	// TransitToken is the token used to authenticate to the Transit secrets engine.
+	TransitToken string
+
+	// TransitMount is the path the Transit secrets engine is mounted at.
+	TransitMount string
+
+	// TransitKey is the name of the Transit key used to sign tokens.
+	TransitKey string
+
+	// PKCS11Module is the path to the PKCS#11 module used to access the token.
+	PKCS11Module string
+
+	// PKCS11Slot is the ID of the slot containing the token signing keys are created on.
+	PKCS11Slot int
+
+	// PKCS11PIN is the user PIN used to log in to the token.
+	PKCS11PIN string
+
+	// IssueCertificates defines if each new signing key is issued an X.509 certificate,
+	// which is published along with its thumbprints in the JSON Web Key Set.
+	IssueCertificates bool
+
+	// SetX5TS256 defines if the backend sets the 'x5t#S256' header on tokens signed by a key with a certificate.
+	SetX5TS256 bool
+
+	// SetX5T defines if the backend sets the 'x5t' header, the SHA-1 thumbprint of the certificate,
+	// on tokens signed by a key with a certificate.
+	SetX5T bool
+
+	// Headers are set on every JWT signed at the sign endpoint, and can override the default 'typ' header of "JWT".
+	Headers map[string]string
+
+	// AllowedHeaders are the headers which callers can set on the tokens they sign, in addition to Headers.
+	AllowedHeaders []string
+
+	// SigningAlgorithm is the algorithm used to sign tokens. HMAC algorithms use a generated shared secret,
+	// which is never published in the JSON Web Key Set.
+	SigningAlgorithm jose.SignatureAlgorithm
+
+	// LeaseTokens defines if tokens are returned with a Vault lease lasting as long as the token.
+	// Revoking the lease adds the token's 'jti' claim to the revocation list. The 'jti' claim is always set when this is enabled.
+	LeaseTokens bool
+
+	// RefreshTokens defines if a one-time use refresh token is returned alongside each token, which can be
+	// exchanged at the refresh endpoint for a new token with the same claims.
+	RefreshTokens bool
+
+	// RefreshTokenTTL defines how long a refresh token can be used for after being issued.
+	RefreshTokenTTL time.Duration
+
+	// RefreshMaxTTL defines how long refresh tokens descended from the same signed token can be used for after
+	// that token is signed, however many times they are refreshed.
+	RefreshMaxTTL time.Duration
+
+	// BindClientCertificates defines if tokens signed for callers authenticated with a client certificate
+	// are bound to that certificate by its thumbprint in the 'cnf' claim. The certificate is read from the
+	// request's TLS connection state, which Vault does not pass to plugins served out of process, so this has
+	// no effect unless the backend runs inside Vault.
+	BindClientCertificates bool
+
+	// AllowCertificateThumbprints defines if callers can bind the tokens they sign to a client certificate by
+	// passing its thumbprint. Any caller permitted to sign can then bind tokens to any certificate, so it should
+	// only be set if signing is limited to trusted callers, such as a proxy terminating mutual TLS.
+	// It cannot be set along with BindClientCertificates.
+	AllowCertificateThumbprints bool
+
+	// Profile restricts the tokens which can be signed to those valid for a particular use. The "spiffe" profile
+	// requires tokens to be JWT-SVIDs for workloads in SPIFFETrustDomain, the "kubernetes" profile requires
+	// tokens to be shaped like Kubernetes service account tokens, and the "at+jwt" profile requires tokens to be
+	// OAuth access tokens. If blank, no profile is applied.
+	Profile string
+
+	// SPIFFETrustDomain is the trust domain of the SPIFFE IDs in JWT-SVIDs signed under the "spiffe" profile.
+	SPIFFETrustDomain string
+
+	// AccessTokenScopes are the scopes which can be granted in the 'scope' claim of access tokens signed under
+	// the "at+jwt" profile.
+	AccessTokenScopes []string
+
+	// KubernetesNamespacePattern and KubernetesServiceAccountPattern must match the namespace and name of the
+	// service account in tokens signed under the "kubernetes" profile, in place of SubjectPattern.
+	KubernetesNamespacePattern      *regexp.Regexp
+	KubernetesServiceAccountPattern *regexp.Regexp
+
+	// CWTSigningAlgorithm is the algorithm used to sign CBOR Web Tokens, either ES256 or EdDSA.
+	// CWT signing keys are always generated locally and rotate on the same schedule as other keys.
+	CWTSigningAlgorithm jose.SignatureAlgorithm
+
+	// CredentialIssuer is the did:web DID which issues Verifiable Credentials, set as their 'iss' claim.
+	// Credentials cannot be signed unless it is set.
+	CredentialIssuer string
+
+	// CredentialSchema is a JSON Schema which the 'vc' claim of every Verifiable Credential must match.
+	// If empty, credentials are not validated.
+	CredentialSchema map[string]interface{}
+
+	// caBundle holds the CA certificate and key used to issue signing key certificates.
+	// If no CA is configured one is generated when IssueCertificates is first set.
+	caBundle *certutil.ParsedCertBundle
+
+	// keyProvider creates the keys used to sign tokens, as selected by KeyProvider.
+	keyProvider keyProvider
+
 	// allowedClaimsMap is used to easily check if a claim is in the allowed claim set.
 	allowedClaimsMap map[string]bool
+
+	// allowedClaimGlobs are the claims in AllowedClaims which contain wildcards.
+	allowedClaimGlobs []string
+
+	// allowedHeadersMap is used to easily check if a header is in the allowed header set.
+	allowedHeadersMap map[string]bool
 }
 
 // DefaultConfig creates a new default configuration.
@@ -71,11 +271,38 @@ func DefaultConfig(backendUUID string) *Config {
 	c.SetJTI = DefaultSetJTI
 	c.SetNBF = DefaultSetNBF
 	c.Issuer = strings.Replace(DefaultIssuer, "UUID", backendUUID, 1)
+	c.DeriveIssuer = DefaultDeriveIssuer
 	c.AudiencePattern = regexp.MustCompile(DefaultAudiencePattern)
 	c.SubjectPattern = regexp.MustCompile(DefaultSubjectPattern)
 	c.MaxAudiences = DefaultMaxAudiences
 	c.AllowedClaims = DefaultAllowedClaims
 	c.allowedClaimsMap = makeAllowedClaimsMap(DefaultAllowedClaims)
+	c.allowedClaimGlobs = makeAllowedClaimGlobs(DefaultAllowedClaims)
+	c.AllowedClaimPatterns = []*regexp.Regexp{}
+	c.DeniedClaimValues = map[string][]string{}
+	c.Exportable = DefaultExportable
+	c.KeyProvider = DefaultKeyProvider
+	c.TransitMount = DefaultTransitMount
+	c.PKCS11Slot = DefaultPKCS11Slot
+	c.IssueCertificates = DefaultIssueCertificates
+	c.SetX5TS256 = DefaultSetX5TS256
+	c.SetX5T = DefaultSetX5T
+	c.SignPolicies = []*signPolicy{}
+	c.AllowedHeaders = DefaultAllowedHeaders
+	c.allowedHeadersMap = makeAllowedHeadersMap(DefaultAllowedHeaders)
+	c.SigningAlgorithm = DefaultSigningAlgorithm
+	c.LeaseTokens = DefaultLeaseTokens
+	c.RefreshTokens = DefaultRefreshTokens
+	c.RefreshTokenTTL, _ = time.ParseDuration(DefaultRefreshTokenTTL)
+	c.RefreshMaxTTL, _ = time.ParseDuration(DefaultRefreshMaxTTL)
+	c.BindClientCertificates = DefaultBindClientCertificates
+	c.AllowCertificateThumbprints = DefaultAllowCertificateThumbprints
+	c.Profile = DefaultProfile
+	c.CWTSigningAlgorithm = DefaultCWTSigningAlgorithm
+	c.AccessTokenScopes = DefaultAccessTokenScopes
+	c.KubernetesNamespacePattern = regexp.MustCompile(DefaultKubernetesNamespacePattern)
+	c.KubernetesServiceAccountPattern = regexp.MustCompile(DefaultKubernetesServiceAccountPattern)
+	c.keyProvider = localKeyProvider{}
 	return c
 }
 
@@ -90,3 +317,74 @@ func makeAllowedClaimsMap(allowedClaims []string) map[string]bool {
 	}
 	return newClaims
 }
+
+// makeAllowedClaimGlobs returns the allowed claims which contain wildcards.
+func makeAllowedClaimGlobs(allowedClaims []string) []string {
+	var globs []string
+	for _, claim := range allowedClaims {
+		if strings.Contains(claim, "*") {
+			globs = append(globs, claim)
+		}
+	}
+	return globs
+}
+
+// claimAllowed returns whether a claim can be set by callers. Reserved claims are never allowed, even if they
+// match a glob or pattern.
+func (c *Config) claimAllowed(claim string) bool {
+	if allowed, ok := c.allowedClaimsMap[claim]; ok {
+		return allowed
+	}
+
+	if strutil.StrListContainsGlob(c.allowedClaimGlobs, claim) {
+		return true
+	}
+
+	for _, pattern := range c.AllowedClaimPatterns {
+		if pattern.MatchString(claim) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// deniedClaimValue returns the first value of a claim which is denied by DeniedClaimValues, and whether there is one.
+func (c *Config) deniedClaimValue(claim string, value interface{}) (string, bool) {
+	denied, ok := c.DeniedClaimValues[claim]
+	if !ok {
+		return "", false
+	}
+
+	var values []string
+	switch v := value.(type) {
+	case []string:
+		values = v
+	case []interface{}:
+		for _, element := range v {
+			values = append(values, fmt.Sprint(element))
+		}
+	default:
+		values = []string{fmt.Sprint(v)}
+	}
+
+	for _, value := range values {
+		if strutil.StrListContains(denied, value) {
+			return value, true
+		}
+	}
+
+	return "", false
+}
+
+// makeAllowedHeadersMap turns the slice of allowed headers into a map, like makeAllowedClaimsMap.
+func makeAllowedHeadersMap(allowedHeaders []string) map[string]bool {
+	newHeaders := make(map[string]bool)
+	for _, header := range allowedHeaders {
+		newHeaders[header] = true
+	}
+	for _, header := range ReservedHeaders {
+		newHeaders[header] = false
+	}
+	return newHeaders
+}
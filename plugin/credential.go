@@ -0,0 +1,93 @@
+package jwtsecrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+const (
+	// credentialContext and credentialType are the base context and type of every Verifiable Credential.
+	credentialContext = "https://www.w3.org/2018/credentials/v1"
+	credentialType    = "VerifiableCredential"
+
+	// didContext and jwsContext are the contexts of a DID document with JsonWebKey2020 verification methods.
+	didContext = "https://www.w3.org/ns/did/v1"
+	jwsContext = "https://w3id.org/security/suites/jws-2020/v1"
+
+	didWebPrefix = "did:web:"
+)
+
+// validateDIDWeb checks that a DID uses the did:web method, which resolves to a document hosted on a web domain.
+func validateDIDWeb(did string) error {
+	if !strings.HasPrefix(did, didWebPrefix) {
+		return fmt.Errorf("%s is not a did:web DID", did)
+	}
+
+	for _, segment := range strings.Split(strings.TrimPrefix(did, didWebPrefix), ":") {
+		if segment == "" {
+			return fmt.Errorf("%s has an empty segment", did)
+		}
+		if strings.ContainsAny(segment, "/?#") {
+			return fmt.Errorf("%s must not contain a path, query or fragment", did)
+		}
+	}
+
+	return nil
+}
+
+// didDocument returns a DID document publishing the keys of a JSON Web Key Set as JsonWebKey2020 verification
+// methods, whose fragments are the key IDs.
+func didDocument(did string, jwks *jose.JSONWebKeySet) (map[string]interface{}, error) {
+	methods := make([]interface{}, 0, len(jwks.Keys))
+	assertionMethods := make([]interface{}, 0, len(jwks.Keys))
+
+	for _, key := range jwks.Keys {
+		encoded, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+
+		var publicKeyJWK map[string]interface{}
+		if err := json.Unmarshal(encoded, &publicKeyJWK); err != nil {
+			return nil, err
+		}
+
+		id := did + "#" + key.KeyID
+		methods = append(methods, map[string]interface{}{
+			"id":           id,
+			"type":         "JsonWebKey2020",
+			"controller":   did,
+			"publicKeyJwk": publicKeyJWK,
+		})
+		assertionMethods = append(assertionMethods, id)
+	}
+
+	return map[string]interface{}{
+		"@context":           []interface{}{didContext, jwsContext},
+		"id":                 did,
+		"verificationMethod": methods,
+		"assertionMethod":    assertionMethods,
+	}, nil
+}
+
+// credentialClaim returns the 'vc' claim of a Verifiable Credential of the given type about a subject.
+func credentialClaim(typ string, subject map[string]interface{}) (map[string]interface{}, error) {
+	if typ == "" {
+		return nil, errors.New("no credential type provided")
+	}
+
+	types := []interface{}{credentialType}
+	if typ != credentialType {
+		types = append(types, typ)
+	}
+
+	return map[string]interface{}{
+		"@context":          []interface{}{credentialContext},
+		"type":              types,
+		"credentialSubject": subject,
+	}, nil
+}
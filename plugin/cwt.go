@@ -0,0 +1,289 @@
+package jwtsecrets
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	// coseES256 and coseEdDSA are the algorithms of keys which sign CWTs. They are never published in the
+	// JSON Web Key Set, only in the COSE key set.
+	coseES256 jose.SignatureAlgorithm = "cose.ES256"
+	coseEdDSA jose.SignatureAlgorithm = "cose.EdDSA"
+
+	// cborTagCOSESign1 is the CBOR tag of a COSE_Sign1 structure, from RFC 8152.
+	cborTagCOSESign1 = 18
+
+	// p256CoordinateSize is the size in bytes of a P-256 field element.
+	p256CoordinateSize = 32
+)
+
+// COSE header parameters, algorithms and key parameters, from RFC 8152.
+const (
+	coseHeaderAlgorithm = 1
+	coseHeaderKeyID     = 4
+
+	coseAlgorithmES256 = -7
+	coseAlgorithmEdDSA = -8
+
+	coseKeyType      = 1
+	coseKeyID        = 2
+	coseKeyAlgorithm = 3
+	coseKeyCurve     = -1
+	coseKeyX         = -2
+	coseKeyY         = -3
+
+	coseKeyRSAModulus  = -1
+	coseKeyRSAExponent = -2
+
+	coseKeyTypeOKP = 1
+	coseKeyTypeEC2 = 2
+	coseKeyTypeRSA = 3
+
+	coseCurveP256    = 1
+	coseCurveP384    = 2
+	coseCurveP521    = 3
+	coseCurveEd25519 = 6
+)
+
+// cwtConfirmationCOSEKey is the confirmation method of the 'cnf' claim of a CWT bound to a client's public key,
+// from RFC 8747.
+const cwtConfirmationCOSEKey = 1
+
+// cwtAlgorithms maps the algorithms CWTs can be signed with to the algorithms of the keys which sign them.
+var cwtAlgorithms = map[jose.SignatureAlgorithm]jose.SignatureAlgorithm{
+	jose.ES256: coseES256,
+	jose.EdDSA: coseEdDSA,
+}
+
+// cwtClaimKeys are the integer keys of the registered claims, from RFC 8392 and RFC 8747.
+var cwtClaimKeys = map[string]int{
+	"iss": 1,
+	"sub": 2,
+	"aud": 3,
+	"exp": 4,
+	"nbf": 5,
+	"iat": 6,
+	"jti": 7,
+	"cnf": 8,
+}
+
+// isCOSEAlgorithm returns whether keys with the algorithm sign CWTs.
+func isCOSEAlgorithm(algorithm jose.SignatureAlgorithm) bool {
+	return algorithm == coseES256 || algorithm == coseEdDSA
+}
+
+// signCWT signs a set of claims as a CBOR Web Token in a COSE_Sign1 structure, returned base64url encoded.
+// Registered claims use their integer keys, and the key ID is carried in the protected header.
+func signCWT(key *signingKey, claims map[string]interface{}) (string, error) {
+	cwtClaims := make(map[interface{}]interface{}, len(claims))
+	for claim, value := range claims {
+		switch v := value.(type) {
+		case jwt.NumericDate:
+			value = int64(v)
+		case string:
+			if claim == "jti" {
+				// The CWT ID is a byte string.
+				value = []byte(v)
+			}
+		case map[string]interface{}:
+			if claim == "cnf" {
+				confirmation, err := cwtConfirmation(v)
+				if err != nil {
+					return "", err
+				}
+				value = confirmation
+			}
+		}
+
+		if claimKey, ok := cwtClaimKeys[claim]; ok {
+			cwtClaims[claimKey] = value
+		} else {
+			cwtClaims[claim] = value
+		}
+	}
+
+	payload, err := encodeCBOR(cwtClaims)
+	if err != nil {
+		return "", err
+	}
+
+	var algorithm int
+	switch key.Algorithm {
+	case coseES256:
+		algorithm = coseAlgorithmES256
+	case coseEdDSA:
+		algorithm = coseAlgorithmEdDSA
+	default:
+		return "", fmt.Errorf("key %s does not sign CWTs", key.ID)
+	}
+
+	protected, err := encodeCBOR(map[interface{}]interface{}{
+		coseHeaderAlgorithm: algorithm,
+		coseHeaderKeyID:     []byte(key.ID),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	toBeSigned, err := encodeCBOR([]interface{}{"Signature1", protected, []byte{}, payload})
+	if err != nil {
+		return "", err
+	}
+
+	var signature []byte
+	switch key.Algorithm {
+	case coseES256:
+		digest := sha256.Sum256(toBeSigned)
+		der, err := key.Key.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			return "", err
+		}
+
+		if signature, err = ecdsaSignatureToRaw(der, p256CoordinateSize); err != nil {
+			return "", err
+		}
+	case coseEdDSA:
+		if signature, err = key.Key.Sign(rand.Reader, toBeSigned, crypto.Hash(0)); err != nil {
+			return "", err
+		}
+	}
+
+	token, err := encodeCBOR(cborTagged{
+		number:  cborTagCOSESign1,
+		content: []interface{}{protected, map[interface{}]interface{}{}, payload, signature},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// cwtConfirmation converts the 'cnf' claim of a JWT to that of a CWT, which binds the token to the client's
+// public key in a COSE_Key. RFC 8747 has no thumbprint confirmation methods, so the key must be in the claim,
+// and tokens bound to a client certificate cannot be signed as CWTs.
+func cwtConfirmation(cnf map[string]interface{}) (map[interface{}]interface{}, error) {
+	if _, ok := cnf[confirmationX5TS256]; ok {
+		return nil, errors.New("tokens bound to a client certificate cannot be signed as CWTs")
+	}
+
+	rawJWK, ok := cnf["jwk"]
+	if !ok {
+		return nil, errors.New("tokens bound to a client key can only be signed as CWTs with the key")
+	}
+
+	encoded, err := json.Marshal(rawJWK)
+	if err != nil {
+		return nil, err
+	}
+	var jwk jose.JSONWebKey
+	if err := json.Unmarshal(encoded, &jwk); err != nil {
+		return nil, err
+	}
+
+	key, err := clientCOSEKey(&jwk)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[interface{}]interface{}{cwtConfirmationCOSEKey: key}, nil
+}
+
+// clientCOSEKey returns the COSE_Key structure of a client's public JSON Web Key.
+func clientCOSEKey(jwk *jose.JSONWebKey) (map[interface{}]interface{}, error) {
+	switch publicKey := jwk.Key.(type) {
+	case *ecdsa.PublicKey:
+		var curve int
+		switch publicKey.Curve {
+		case elliptic.P256():
+			curve = coseCurveP256
+		case elliptic.P384():
+			curve = coseCurveP384
+		case elliptic.P521():
+			curve = coseCurveP521
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", publicKey.Curve.Params().Name)
+		}
+
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		x := make([]byte, size)
+		y := make([]byte, size)
+		fillBytes(publicKey.X, x)
+		fillBytes(publicKey.Y, y)
+
+		return map[interface{}]interface{}{
+			coseKeyType:  coseKeyTypeEC2,
+			coseKeyCurve: curve,
+			coseKeyX:     x,
+			coseKeyY:     y,
+		}, nil
+	case ed25519.PublicKey:
+		return map[interface{}]interface{}{
+			coseKeyType:  coseKeyTypeOKP,
+			coseKeyCurve: coseCurveEd25519,
+			coseKeyX:     []byte(publicKey),
+		}, nil
+	case *rsa.PublicKey:
+		return map[interface{}]interface{}{
+			coseKeyType:        coseKeyTypeRSA,
+			coseKeyRSAModulus:  publicKey.N.Bytes(),
+			coseKeyRSAExponent: big.NewInt(int64(publicKey.E)).Bytes(),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported client key type %T", jwk.Key)
+	}
+}
+
+// coseKey returns the COSE_Key structure of the public part of a key which signs CWTs.
+func coseKey(key *signingKey) (map[interface{}]interface{}, error) {
+	switch key.Algorithm {
+	case coseES256:
+		publicKey, ok := key.Key.Public().(*ecdsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("key %s is not an ECDSA key", key.ID)
+		}
+
+		x := make([]byte, p256CoordinateSize)
+		y := make([]byte, p256CoordinateSize)
+		fillBytes(publicKey.X, x)
+		fillBytes(publicKey.Y, y)
+
+		return map[interface{}]interface{}{
+			coseKeyType:      coseKeyTypeEC2,
+			coseKeyID:        []byte(key.ID),
+			coseKeyAlgorithm: coseAlgorithmES256,
+			coseKeyCurve:     coseCurveP256,
+			coseKeyX:         x,
+			coseKeyY:         y,
+		}, nil
+	case coseEdDSA:
+		publicKey, ok := key.Key.Public().(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("key %s is not an Ed25519 key", key.ID)
+		}
+
+		return map[interface{}]interface{}{
+			coseKeyType:      coseKeyTypeOKP,
+			coseKeyID:        []byte(key.ID),
+			coseKeyAlgorithm: coseAlgorithmEdDSA,
+			coseKeyCurve:     coseCurveEd25519,
+			coseKeyX:         []byte(publicKey),
+		}, nil
+	default:
+		return nil, fmt.Errorf("key %s does not sign CWTs", key.ID)
+	}
+}
@@ -0,0 +1,289 @@
+package jwtsecrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/base64"
+	"math/big"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func getCWT(t *testing.T, b *backend, storage *logical.Storage) []byte {
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "sign",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			"format": "cwt",
+			"claims": map[string]interface{}{
+				"sub": "Zapp Brannigan",
+				"aud": []string{"Nimbus"},
+			},
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	token, err := base64.RawURLEncoding.DecodeString(resp.Data["token"].(string))
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	return token
+}
+
+func readCOSEKeys(t *testing.T, b *backend, storage *logical.Storage) []byte {
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "cose_keys",
+		Storage:   *storage,
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	keySet, err := base64.RawURLEncoding.DecodeString(resp.Data["keys"].(string))
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	return keySet
+}
+
+// checkCWT checks the structure of a COSE_Sign1 CWT by re-encoding the expected headers and claims,
+// returning the signature and the Sig_structure it was made over.
+func checkCWT(t *testing.T, b *backend, token []byte, algorithm int) ([]byte, []byte) {
+	protected, err := encodeCBOR(map[interface{}]interface{}{
+		coseHeaderAlgorithm: algorithm,
+		coseHeaderKeyID:     []byte(b.keys[0].ID),
+	})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	payload, err := encodeCBOR(map[interface{}]interface{}{
+		1: testIssuer,
+		2: "Zapp Brannigan",
+		3: []string{"Nimbus"},
+		4: 300,
+		5: 0,
+		6: 0,
+		7: []byte("1"),
+	})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	// Both ES256 and EdDSA signatures are 64 bytes.
+	signature := token[len(token)-64:]
+
+	expected, err := encodeCBOR(cborTagged{
+		number:  cborTagCOSESign1,
+		content: []interface{}{protected, map[interface{}]interface{}{}, payload, signature},
+	})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal(expected, token); diff != nil {
+		t.Error(diff)
+	}
+
+	toBeSigned, err := encodeCBOR([]interface{}{"Signature1", protected, []byte{}, payload})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	return signature, toBeSigned
+}
+
+func TestSignCWTES256(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	token := getCWT(t, b, storage)
+	signature, toBeSigned := checkCWT(t, b, token, coseAlgorithmES256)
+
+	publicKey := b.keys[0].Key.Public().(*ecdsa.PublicKey)
+	digest := sha256.Sum256(toBeSigned)
+	r := new(big.Int).SetBytes(signature[:p256CoordinateSize])
+	s := new(big.Int).SetBytes(signature[p256CoordinateSize:])
+
+	if !ecdsa.Verify(publicKey, digest[:], r, s) {
+		t.Error("signature did not verify")
+	}
+
+	if diff := deep.Equal(elliptic.P256(), publicKey.Curve); diff != nil {
+		t.Error(diff)
+	}
+
+	x := make([]byte, p256CoordinateSize)
+	y := make([]byte, p256CoordinateSize)
+	fillBytes(publicKey.X, x)
+	fillBytes(publicKey.Y, y)
+
+	expectedKeySet, err := encodeCBOR([]interface{}{map[interface{}]interface{}{
+		coseKeyType:      coseKeyTypeEC2,
+		coseKeyID:        []byte(b.keys[0].ID),
+		coseKeyAlgorithm: coseAlgorithmES256,
+		coseKeyCurve:     coseCurveP256,
+		coseKeyX:         x,
+		coseKeyY:         y,
+	}})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal(expectedKeySet, readCOSEKeys(t, b, storage)); diff != nil {
+		t.Error(diff)
+	}
+
+	// COSE keys are never published in the JSON Web Key Set.
+	if jwks := b.getPublicKeys().Keys; len(jwks) != 0 {
+		t.Errorf("expected no JSON Web Keys, got %#v", jwks)
+	}
+}
+
+func TestSignCWTEdDSA(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			keyCWTSigningAlgorithm: "EdDSA",
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	token := getCWT(t, b, storage)
+	signature, toBeSigned := checkCWT(t, b, token, coseAlgorithmEdDSA)
+
+	publicKey := b.keys[0].Key.Public().(ed25519.PublicKey)
+	if !ed25519.Verify(publicKey, toBeSigned, signature) {
+		t.Error("signature did not verify")
+	}
+
+	expectedKeySet, err := encodeCBOR([]interface{}{map[interface{}]interface{}{
+		coseKeyType:      coseKeyTypeOKP,
+		coseKeyID:        []byte(b.keys[0].ID),
+		coseKeyAlgorithm: coseAlgorithmEdDSA,
+		coseKeyCurve:     coseCurveEd25519,
+		coseKeyX:         []byte(publicKey),
+	}})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal(expectedKeySet, readCOSEKeys(t, b, storage)); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestInvalidCWTSigningAlgorithm(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			keyCWTSigningAlgorithm: "RS256",
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err == nil || resp != nil && !resp.IsError() {
+		t.Errorf("expected an error, got %#v", resp)
+	}
+}
+
+func TestEmptyCOSEKeySet(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if diff := deep.Equal([]byte{cborArray << 5}, readCOSEKeys(t, b, storage)); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestSignCWTConfirmation(t *testing.T) {
+	b, storage := getTestBackend(t)
+	client := newDPoPClient(t)
+
+	x := make([]byte, p256CoordinateSize)
+	y := make([]byte, p256CoordinateSize)
+	fillBytes(client.key.X, x)
+	fillBytes(client.key.Y, y)
+
+	// The 'cnf' claim carries the client's key as a COSE_Key, with the integer labels of RFC 8747.
+	expected, err := encodeCBOR(map[interface{}]interface{}{
+		8: map[interface{}]interface{}{
+			1: map[interface{}]interface{}{
+				coseKeyType:  coseKeyTypeEC2,
+				coseKeyCurve: coseCurveP256,
+				coseKeyX:     x,
+				coseKeyY:     y,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	// Strip the head of the claims map, leaving the 'cnf' claim as it is encoded in the token's payload.
+	expected = expected[1:]
+
+	bindings := []map[string]interface{}{
+		{"jwk": client.jwk(t)},
+		{"dpop_proof": client.proof(t, "dpop+jwt", map[string]interface{}{"jti": "proof-1", "iat": 0})},
+	}
+
+	for _, data := range bindings {
+		data["format"] = "cwt"
+		resp, err := signBound(b, storage, data)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+
+		token, err := base64.RawURLEncoding.DecodeString(resp.Data["token"].(string))
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		if !bytes.Contains(token, expected) {
+			t.Errorf("%v: expected the client's COSE_Key in the 'cnf' claim", data)
+		}
+		if bytes.Contains(token, []byte("jkt")) {
+			t.Errorf("%v: expected no 'jkt' confirmation method", data)
+		}
+	}
+
+	resp, err := writeConfig(b, storage, map[string]interface{}{keyAllowCertificateThumbprints: true})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// CWTs have no confirmation method for certificate thumbprints.
+	resp, err = signBound(b, storage, map[string]interface{}{
+		"format":                 "cwt",
+		"certificate_thumbprint": base64.RawURLEncoding.EncodeToString(make([]byte, sha256.Size)),
+	})
+	if err == nil || resp != nil && !resp.IsError() {
+		t.Errorf("expected error, got resp:%#v\n", resp)
+	}
+}
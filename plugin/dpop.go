@@ -0,0 +1,276 @@
+package jwtsecrets
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	keyClientJWK  = "jwk"
+	keyDPoPProof  = "dpop_proof"
+	keyDPoPMethod = "dpop_method"
+	keyDPoPURL    = "dpop_url"
+
+	// dpopProofType is the 'typ' header of a DPoP proof, from RFC 9449.
+	dpopProofType = "dpop+jwt"
+
+	// dpopProofLifetime is how far a DPoP proof's 'iat' claim may be from the current time.
+	dpopProofLifetime = 5 * time.Minute
+
+	dpopProofsPrefix = "dpop/proofs/"
+)
+
+// dpopProof is a DPoP proof whose signature has been verified against the key in its header.
+type dpopProof struct {
+	// Thumbprint is the base64url encoded SHA-256 JWK thumbprint of the key which signed the proof.
+	Thumbprint string
+
+	// Key is the public key which signed the proof, from its 'jwk' header.
+	Key *jose.JSONWebKey
+
+	JTI      string
+	IssuedAt time.Time
+
+	// Method and URL are the 'htm' and 'htu' claims, the request the proof was created for.
+	Method string
+	URL    string
+
+	// AccessTokenHash is the 'ath' claim, the base64url encoded SHA-256 hash of the access token presented with the proof.
+	AccessTokenHash string
+}
+
+// dpopProofClaims are the claims of a DPoP proof.
+type dpopProofClaims struct {
+	JTI             string           `json:"jti"`
+	Method          string           `json:"htm"`
+	URL             string           `json:"htu"`
+	IssuedAt        *jwt.NumericDate `json:"iat"`
+	AccessTokenHash string           `json:"ath"`
+}
+
+// dpopProofUse records a DPoP proof which has been presented, so it cannot be replayed.
+type dpopProofUse struct {
+	JTI       string    `json:"jti"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// parseDPoPProof verifies the signature and freshness of a DPoP proof.
+func parseDPoPProof(rawProof string, now time.Time) (*dpopProof, error) {
+	proof, err := jwt.ParseSigned(rawProof)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(proof.Headers) != 1 {
+		return nil, errors.New("proof must have exactly one signature")
+	}
+	header := proof.Headers[0]
+
+	if typ, _ := header.ExtraHeaders[jose.HeaderType].(string); typ != dpopProofType {
+		return nil, fmt.Errorf("'typ' header must be %s", dpopProofType)
+	}
+
+	if _, ok := hmacSecretSizes[jose.SignatureAlgorithm(header.Algorithm)]; ok {
+		return nil, fmt.Errorf("proof cannot be signed with %s", header.Algorithm)
+	}
+
+	if header.JSONWebKey == nil || !header.JSONWebKey.IsPublic() {
+		return nil, errors.New("'jwk' header must be a public key")
+	}
+
+	var claims dpopProofClaims
+	if err = proof.Claims(header.JSONWebKey.Key, &claims); err != nil {
+		return nil, err
+	}
+
+	if claims.JTI == "" {
+		return nil, errors.New("proof has no 'jti' claim")
+	}
+
+	if claims.IssuedAt == nil {
+		return nil, errors.New("proof has no 'iat' claim")
+	}
+
+	issuedAt := claims.IssuedAt.Time()
+	if issuedAt.Before(now.Add(-dpopProofLifetime)) || issuedAt.After(now.Add(dpopProofLifetime)) {
+		return nil, errors.New("proof is not fresh")
+	}
+
+	thumbprint, err := jwkThumbprint(header.JSONWebKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dpopProof{
+		Thumbprint:      thumbprint,
+		Key:             header.JSONWebKey,
+		JTI:             claims.JTI,
+		IssuedAt:        issuedAt,
+		Method:          claims.Method,
+		URL:             claims.URL,
+		AccessTokenHash: claims.AccessTokenHash,
+	}, nil
+}
+
+// parseClientJWK parses a client's public JSON Web Key and returns its thumbprint.
+func parseClientJWK(rawJWK string) (string, error) {
+	var key jose.JSONWebKey
+	if err := json.Unmarshal([]byte(rawJWK), &key); err != nil {
+		return "", err
+	}
+
+	if !key.IsPublic() || !key.Valid() {
+		return "", errors.New("not a valid public key")
+	}
+
+	return jwkThumbprint(&key)
+}
+
+// jwkThumbprint returns the base64url encoded RFC 7638 SHA-256 thumbprint of a key.
+func jwkThumbprint(key *jose.JSONWebKey) (string, error) {
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(thumbprint), nil
+}
+
+// accessTokenHash returns the value of the 'ath' claim of a DPoP proof presented with a token.
+func accessTokenHash(token string) string {
+	digest := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+// checkDPoPBinding checks that a token bound to a client key by its 'cnf' claim is presented with a fresh DPoP proof
+// signed by that key. If method or url are set, the proof must have been created for that request. Tokens which are
+// not bound to a key are accepted. If the proof is invalid the error is an *invalidTokenError.
+func (b *backend) checkDPoPBinding(c context.Context, s logical.Storage, rawToken string, token *verifiedToken, rawProof, method, url string) error {
+	cnf, _ := token.Claims["cnf"].(map[string]interface{})
+	jkt, _ := cnf["jkt"].(string)
+	if jkt == "" {
+		return nil
+	}
+
+	if rawProof == "" {
+		return invalidToken(logical.ErrPermissionDenied, "token is bound to a key but no DPoP proof was presented")
+	}
+
+	proof, err := parseDPoPProof(rawProof, b.clock.now())
+	if err != nil {
+		return invalidToken(logical.ErrPermissionDenied, "invalid DPoP proof: %v", err)
+	}
+
+	if proof.Thumbprint != jkt {
+		return invalidToken(logical.ErrPermissionDenied, "DPoP proof was not signed by the key the token is bound to")
+	}
+
+	if proof.AccessTokenHash != accessTokenHash(rawToken) {
+		return invalidToken(logical.ErrPermissionDenied, "DPoP proof was not created for this token")
+	}
+
+	if err := checkDPoPRequest(proof, method, url); err != nil {
+		return invalidToken(logical.ErrPermissionDenied, "%v", err)
+	}
+
+	fresh, err := b.useDPoPProof(c, s, proof)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return invalidToken(logical.ErrPermissionDenied, "DPoP proof has already been used")
+	}
+
+	return nil
+}
+
+// checkDPoPRequest checks that a DPoP proof was created for a request with the given method and URL, if they are set.
+func checkDPoPRequest(proof *dpopProof, method, url string) error {
+	if method != "" && proof.Method != method {
+		return fmt.Errorf("DPoP proof was created for method %s, not %s", proof.Method, method)
+	}
+
+	if url != "" && proof.URL != url {
+		return fmt.Errorf("DPoP proof was created for URL %s, not %s", proof.URL, url)
+	}
+
+	return nil
+}
+
+// useDPoPProof records that a DPoP proof has been presented, returning false if it has been presented before.
+func (b *backend) useDPoPProof(c context.Context, s logical.Storage, proof *dpopProof) (bool, error) {
+	b.dpopLock.Lock()
+	defer b.dpopLock.Unlock()
+
+	path := dpopProofsPrefix + proof.Thumbprint + "/" + storageDigest(proof.JTI)
+
+	existing, err := s.Get(c, path)
+	if err != nil {
+		return false, err
+	}
+	if existing != nil {
+		return false, nil
+	}
+
+	entry, err := logical.StorageEntryJSON(path, &dpopProofUse{
+		JTI:       proof.JTI,
+		ExpiresAt: proof.IssuedAt.Add(dpopProofLifetime),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return true, s.Put(c, entry)
+}
+
+// tidyDPoPProofs removes records of DPoP proofs which are no longer fresh, as they would be rejected anyway.
+func (b *backend) tidyDPoPProofs(c context.Context, r *logical.Request) error {
+	now := b.clock.now()
+
+	thumbprints, err := r.Storage.List(c, dpopProofsPrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, thumbprint := range thumbprints {
+		prefix := dpopProofsPrefix + thumbprint
+
+		digests, err := r.Storage.List(c, prefix)
+		if err != nil {
+			return err
+		}
+
+		for _, digest := range digests {
+			entry, err := r.Storage.Get(c, prefix+digest)
+			if err != nil {
+				return err
+			}
+			if entry == nil {
+				continue
+			}
+
+			var use dpopProofUse
+			if err = entry.DecodeJSON(&use); err != nil {
+				return err
+			}
+
+			if use.ExpiresAt.Before(now) {
+				if err = r.Storage.Delete(c, prefix+digest); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
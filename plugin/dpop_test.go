@@ -0,0 +1,304 @@
+package jwtsecrets
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// dpopClient holds the key a client binds its tokens to.
+type dpopClient struct {
+	key *ecdsa.PrivateKey
+}
+
+func newDPoPClient(t *testing.T) *dpopClient {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	return &dpopClient{key: key}
+}
+
+func (d *dpopClient) jwk(t *testing.T) string {
+	encoded, err := json.Marshal(jose.JSONWebKey{Key: d.key.Public()})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	return string(encoded)
+}
+
+func (d *dpopClient) thumbprint(t *testing.T) string {
+	thumbprint, err := jwkThumbprint(&jose.JSONWebKey{Key: d.key.Public()})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	return thumbprint
+}
+
+func (d *dpopClient) proof(t *testing.T, typ string, claims map[string]interface{}) string {
+	options := (&jose.SignerOptions{EmbedJWK: true}).WithType(jose.ContentType(typ))
+	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: d.key}, options)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	proof, err := jwt.Signed(sig).Claims(claims).CompactSerialize()
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	return proof
+}
+
+func signBound(b *backend, storage *logical.Storage, data map[string]interface{}) (*logical.Response, error) {
+	data["claims"] = map[string]interface{}{"sub": "Zapp Brannigan"}
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "sign",
+		Storage:   *storage,
+		Data:      data,
+	}
+
+	return b.HandleRequest(context.Background(), req)
+}
+
+func verifyBound(b *backend, storage *logical.Storage, data map[string]interface{}) (*logical.Response, error) {
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "verify",
+		Storage:   *storage,
+		Data:      data,
+	}
+
+	return b.HandleRequest(context.Background(), req)
+}
+
+func TestDPoPBinding(t *testing.T) {
+	b, storage := getTestBackend(t)
+	client := newDPoPClient(t)
+
+	resp, err := signBound(b, storage, map[string]interface{}{
+		"dpop_proof": client.proof(t, "dpop+jwt", map[string]interface{}{
+			"jti": "proof-1",
+			"htm": "POST",
+			"htu": "https://vault.example.com/v1/jwt/sign",
+			"iat": 0,
+		}),
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	token := resp.Data["token"].(string)
+
+	claims := introspectToken(t, b, storage, token)
+	if diff := deep.Equal(map[string]interface{}{"jkt": client.thumbprint(t)}, claims["cnf"]); diff != nil {
+		t.Error(diff)
+	}
+
+	resp, err = verifyBound(b, storage, map[string]interface{}{"token": token})
+	if err != logical.ErrPermissionDenied {
+		t.Errorf("expected token without proof to be denied, got err:%v resp:%#v\n", err, resp)
+	}
+
+	presentation := map[string]interface{}{
+		"jti": "proof-2",
+		"htm": "GET",
+		"htu": "https://api.example.com/resource",
+		"iat": 0,
+		"ath": accessTokenHash(token),
+	}
+
+	otherKey := newDPoPClient(t).proof(t, "dpop+jwt", presentation)
+
+	wrongHash := make(map[string]interface{})
+	for k, v := range presentation {
+		wrongHash[k] = v
+	}
+	wrongHash["ath"] = accessTokenHash("another token")
+
+	proof := client.proof(t, "dpop+jwt", presentation)
+
+	rejected := map[string]map[string]interface{}{
+		"other key":    {"token": token, "dpop_proof": otherKey},
+		"wrong hash":   {"token": token, "dpop_proof": client.proof(t, "dpop+jwt", wrongHash)},
+		"wrong method": {"token": token, "dpop_proof": proof, "dpop_method": "POST"},
+		"wrong url":    {"token": token, "dpop_proof": proof, "dpop_url": "https://api.example.com/other"},
+	}
+
+	for name, data := range rejected {
+		resp, err = verifyBound(b, storage, data)
+		if err != logical.ErrPermissionDenied {
+			t.Errorf("%s: expected error, got err:%v resp:%#v\n", name, err, resp)
+		}
+	}
+
+	valid := map[string]interface{}{
+		"token":       token,
+		"dpop_proof":  proof,
+		"dpop_method": "GET",
+		"dpop_url":    "https://api.example.com/resource",
+	}
+
+	resp, err = verifyBound(b, storage, valid)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	resp, err = verifyBound(b, storage, valid)
+	if err != logical.ErrPermissionDenied {
+		t.Errorf("expected replayed proof to be denied, got err:%v resp:%#v\n", err, resp)
+	}
+}
+
+func TestSignWithClientJWK(t *testing.T) {
+	b, storage := getTestBackend(t)
+	client := newDPoPClient(t)
+
+	resp, err := signBound(b, storage, map[string]interface{}{"jwk": client.jwk(t)})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	claims := introspectToken(t, b, storage, resp.Data["token"].(string))
+	if diff := deep.Equal(map[string]interface{}{"jkt": client.thumbprint(t)}, claims["cnf"]); diff != nil {
+		t.Error(diff)
+	}
+
+	privateJWK, err := json.Marshal(jose.JSONWebKey{Key: client.key})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	rejected := map[string]map[string]interface{}{
+		"private key": {"jwk": string(privateJWK)},
+		"not a key":   {"jwk": "not a key"},
+		"both":        {"jwk": client.jwk(t), "dpop_proof": client.proof(t, "dpop+jwt", map[string]interface{}{"jti": "1", "iat": 0})},
+	}
+
+	for name, data := range rejected {
+		resp, err = signBound(b, storage, data)
+		if err == nil || resp != nil && !resp.IsError() {
+			t.Errorf("%s: expected error, got resp:%#v\n", name, resp)
+		}
+	}
+}
+
+func TestSignDPoPProofChecked(t *testing.T) {
+	b, storage := getTestBackend(t)
+	client := newDPoPClient(t)
+
+	proof := client.proof(t, "dpop+jwt", map[string]interface{}{
+		"jti": "proof-1",
+		"htm": "POST",
+		"htu": "https://vault.example.com/v1/jwt/sign",
+		"iat": 0,
+	})
+
+	rejected := map[string]map[string]interface{}{
+		"wrong method": {"dpop_proof": proof, "dpop_method": "GET"},
+		"wrong url":    {"dpop_proof": proof, "dpop_url": "https://vault.example.com/v1/jwt/verify"},
+	}
+
+	for name, data := range rejected {
+		resp, err := signBound(b, storage, data)
+		if err == nil || resp != nil && !resp.IsError() {
+			t.Errorf("%s: expected error, got %#v", name, resp)
+		}
+	}
+
+	valid := map[string]interface{}{
+		"dpop_proof":  proof,
+		"dpop_method": "POST",
+		"dpop_url":    "https://vault.example.com/v1/jwt/sign",
+	}
+
+	resp, err := signBound(b, storage, valid)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	resp, err = signBound(b, storage, valid)
+	if err == nil || resp != nil && !resp.IsError() {
+		t.Errorf("expected replayed proof to be rejected, got %#v", resp)
+	}
+}
+
+func TestInvalidDPoPProof(t *testing.T) {
+	client := newDPoPClient(t)
+	now := time.Unix(0, 0)
+
+	proofs := map[string]string{
+		"wrong type": client.proof(t, "JWT", map[string]interface{}{"jti": "1", "iat": 0}),
+		"stale":      client.proof(t, "dpop+jwt", map[string]interface{}{"jti": "1", "iat": -600}),
+		"no jti":     client.proof(t, "dpop+jwt", map[string]interface{}{"iat": 0}),
+		"no iat":     client.proof(t, "dpop+jwt", map[string]interface{}{"jti": "1"}),
+		"not a jwt":  "not a jwt",
+	}
+
+	for name, proof := range proofs {
+		if _, err := parseDPoPProof(proof, now); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestRefreshKeepsBinding(t *testing.T) {
+	b, storage := getTestBackend(t)
+	enableRefreshTokens(t, b, storage)
+	client := newDPoPClient(t)
+
+	resp, err := signBound(b, storage, map[string]interface{}{"jwk": client.jwk(t)})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	resp, err = refresh(b, storage, resp.Data["refresh_token"].(string))
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	claims := introspectToken(t, b, storage, resp.Data["token"].(string))
+	if diff := deep.Equal(map[string]interface{}{"jkt": client.thumbprint(t)}, claims["cnf"]); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestTidyDPoPProofs(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	fresh, err := b.useDPoPProof(context.Background(), *storage, &dpopProof{
+		Thumbprint: "thumbprint",
+		JTI:        "1",
+		IssuedAt:   time.Unix(0, 0),
+	})
+	if err != nil || !fresh {
+		t.Fatalf("fresh:%v err:%v\n", fresh, err)
+	}
+
+	b.clock = &fakeClock{time.Unix(10*60, 0)}
+
+	if err = b.tidy(context.Background(), &logical.Request{Storage: *storage}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	remaining, err := (*storage).List(context.Background(), dpopProofsPrefix+"thumbprint/")
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if len(remaining) != 0 {
+		t.Errorf("expected proofs to be tidied, found %v", remaining)
+	}
+}
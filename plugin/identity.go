@@ -0,0 +1,213 @@
+package jwtsecrets
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+)
+
+// Sources of claims mapped from the caller's identity, named like the parameters of Vault's identity templates.
+const (
+	// mappingEntityName is the name of the caller's entity.
+	mappingEntityName = "entity.name"
+
+	// mappingEntityMetadataPrefix is followed by a key in the metadata of the caller's entity.
+	mappingEntityMetadataPrefix = "entity.metadata."
+
+	// mappingAliasPrefix is followed by <mount accessor>.metadata.<key>, a key in the metadata of the caller's
+	// entity alias on an auth mount.
+	mappingAliasPrefix = "entity.aliases."
+
+	// mappingGroupNames is the names of the groups the caller's entity is a member of.
+	mappingGroupNames = "entity.groups.names"
+
+	// mappingTokenMetadataPrefix is followed by a key in the metadata of the calling token.
+	mappingTokenMetadataPrefix = "token.metadata."
+)
+
+// parseAliasMapping splits an alias metadata source into the mount accessor and metadata key.
+func parseAliasMapping(source string) (accessor, key string, ok bool) {
+	parts := strings.SplitN(strings.TrimPrefix(source, mappingAliasPrefix), ".metadata.", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// validateClaimMappings checks that no reserved claim is mapped, and that every claim is mapped from a known source.
+func validateClaimMappings(mappings map[string]string) error {
+	for claim, source := range mappings {
+		if strutil.StrListContains(ReservedClaims, claim) {
+			return fmt.Errorf("claim %s is reserved and cannot be mapped", claim)
+		}
+
+		switch {
+		case source == mappingEntityName, source == mappingGroupNames:
+			continue
+		case strings.HasPrefix(source, mappingEntityMetadataPrefix) && source != mappingEntityMetadataPrefix:
+			continue
+		case strings.HasPrefix(source, mappingTokenMetadataPrefix) && source != mappingTokenMetadataPrefix:
+			continue
+		case strings.HasPrefix(source, mappingAliasPrefix):
+			if _, _, ok := parseAliasMapping(source); ok {
+				continue
+			}
+		}
+
+		return fmt.Errorf("claim %s has unknown source %q", claim, source)
+	}
+
+	return nil
+}
+
+// mappedClaims returns the claims mapped from the caller's identity by ClaimMappings. Claims whose source is not
+// set for the caller, such as metadata keys the caller's entity does not have, are omitted.
+func mappedClaims(config *Config, caller *callerInfo) (map[string]interface{}, error) {
+	claims := make(map[string]interface{}, len(config.ClaimMappings))
+	if len(config.ClaimMappings) == 0 {
+		return claims, nil
+	}
+
+	entity, err := caller.getEntity()
+	if err != nil {
+		return nil, err
+	}
+
+	for claim, source := range config.ClaimMappings {
+		var value string
+		switch {
+		case source == mappingEntityName:
+			if entity != nil {
+				value = entity.Name
+			}
+		case source == mappingGroupNames:
+			groups, err := caller.getGroups()
+			if err != nil {
+				return nil, err
+			}
+			if len(groups) > 0 {
+				claims[claim] = groups
+			}
+			continue
+		case strings.HasPrefix(source, mappingEntityMetadataPrefix):
+			if entity != nil {
+				value = entity.Metadata[strings.TrimPrefix(source, mappingEntityMetadataPrefix)]
+			}
+		case strings.HasPrefix(source, mappingTokenMetadataPrefix):
+			tokenMeta, err := caller.getTokenMetadata()
+			if err != nil {
+				return nil, err
+			}
+			value = tokenMeta[strings.TrimPrefix(source, mappingTokenMetadataPrefix)]
+		case strings.HasPrefix(source, mappingAliasPrefix):
+			accessor, key, _ := parseAliasMapping(source)
+			if entity != nil {
+				for _, alias := range entity.Aliases {
+					if alias.MountAccessor == accessor {
+						value = alias.Metadata[key]
+						break
+					}
+				}
+			}
+		}
+
+		if value != "" {
+			claims[claim] = value
+		}
+	}
+
+	return claims, nil
+}
+
+// identityClient returns a client for the Vault API at APIAddr, authenticated with IdentityToken.
+func identityClient(config *Config) (*api.Client, error) {
+	clientConfig := api.DefaultConfig()
+	if config.APIAddr != "" {
+		clientConfig.Address = config.APIAddr
+	}
+
+	client, err := api.NewClient(clientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.IdentityToken != "" {
+		client.SetToken(config.IdentityToken)
+	}
+
+	if config.Namespace != "" {
+		client.SetNamespace(config.Namespace)
+	}
+
+	return client, nil
+}
+
+// lookupTokenMetadata returns the metadata of the token with the given accessor. Tokens are not available to plugins
+// running outside of Vault, so they are looked up with the identity client, whose IdentityToken must be permitted to
+// update auth/token/lookup-accessor.
+func lookupTokenMetadata(client *api.Client, accessor string) (map[string]string, error) {
+	secret, err := client.Logical().Write("auth/token/lookup-accessor", map[string]interface{}{
+		"accessor": accessor,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, errors.New("token not found")
+	}
+
+	rawMeta, _ := secret.Data["meta"].(map[string]interface{})
+	meta := make(map[string]string, len(rawMeta))
+	for key, rawValue := range rawMeta {
+		value, ok := rawValue.(string)
+		if !ok {
+			return nil, fmt.Errorf("metadata %s is not a string", key)
+		}
+		meta[key] = value
+	}
+
+	return meta, nil
+}
+
+// entityGroupNames returns the sorted names of the groups an entity is a member of, directly or through subgroups.
+// Groups are not available to plugins through the system view, so they are read from the identity secrets engine
+// with the identity client, whose IdentityToken must be permitted to read the entity and its groups.
+func entityGroupNames(client *api.Client, entityID string) ([]string, error) {
+	secret, err := client.Logical().Read("identity/entity/id/" + entityID)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, fmt.Errorf("entity %s not found", entityID)
+	}
+
+	groupIDs, _ := secret.Data["group_ids"].([]interface{})
+	names := make([]string, 0, len(groupIDs))
+	for _, rawID := range groupIDs {
+		groupID, ok := rawID.(string)
+		if !ok {
+			return nil, errors.New("invalid group ID")
+		}
+
+		group, err := client.Logical().Read("identity/group/id/" + groupID)
+		if err != nil {
+			return nil, err
+		}
+		if group == nil {
+			return nil, fmt.Errorf("group %s not found", groupID)
+		}
+
+		name, ok := group.Data["name"].(string)
+		if !ok {
+			return nil, fmt.Errorf("group %s has no name", groupID)
+		}
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,208 @@
+package jwtsecrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	testIdentityToken = "identity-token"
+	testAliasAccessor = "auth_userpass_6c5ad5a0"
+	testTokenAccessor = "hermes-conrad-accessor"
+)
+
+var testEntity = &logical.Entity{
+	ID:       "bender",
+	Name:     "Bender Bending Rodríguez",
+	Metadata: map[string]string{"team": "delivery", "cost_center": "planet-express", "audiences": "planet-express,mom-corp"},
+	Aliases: []*logical.Alias{
+		{MountAccessor: "auth_approle_0b3f9d6e", Name: "bender", Metadata: map[string]string{"role": "robot"}},
+		{MountAccessor: testAliasAccessor, Name: "bender", Metadata: map[string]string{"role": "cook"}},
+	},
+}
+
+// signMapped signs a set of claims as testEntity, returning every claim in the token.
+func signMapped(b *backend, storage *logical.Storage, claims map[string]interface{}) (map[string]interface{}, error) {
+	b.System().(*logical.StaticSystemView).EntityVal = testEntity
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "sign",
+		Storage:   *storage,
+		EntityID:  testEntity.ID,
+		Data: map[string]interface{}{
+			"claims": claims,
+		},
+	}
+	req.SetTokenEntry(&logical.TokenEntry{Meta: map[string]string{"ship": "planet-express-ship"}})
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		return nil, fmt.Errorf("err:%s resp:%#v", err, resp)
+	}
+
+	token, err := jwt.ParseSigned(resp.Data["token"].(string))
+	if err != nil {
+		return nil, err
+	}
+
+	decoded := make(map[string]interface{})
+	if err = token.Claims(b.keys[0].Key.Public(), &decoded); err != nil {
+		return nil, err
+	}
+
+	return decoded, nil
+}
+
+func TestValidateClaimMappings(t *testing.T) {
+	valid := map[string]string{
+		"name":  "entity.name",
+		"team":  "entity.metadata.team",
+		"role":  "entity.aliases." + testAliasAccessor + ".metadata.role",
+		"teams": "entity.groups.names",
+		"ship":  "token.metadata.ship",
+	}
+
+	if err := validateClaimMappings(valid); err != nil {
+		t.Error(err)
+	}
+
+	invalid := []map[string]string{
+		{"iss": "entity.name"},
+		{"exp": "entity.metadata.expiry"},
+		{"team": "entity.metadata."},
+		{"team": "entity.id"},
+		{"role": "entity.aliases." + testAliasAccessor},
+		{"role": "entity.aliases..metadata.role"},
+		{"ship": "token.metadata."},
+	}
+
+	for _, mappings := range invalid {
+		if err := validateClaimMappings(mappings); err == nil {
+			t.Errorf("%v: expected error", mappings)
+		}
+	}
+}
+
+func TestClaimMappings(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if resp, err := writeConfig(b, storage, map[string]interface{}{
+		keyClaimMappings: map[string]interface{}{
+			"name":    "entity.name",
+			"team":    "entity.metadata.team",
+			"role":    "entity.aliases." + testAliasAccessor + ".metadata.role",
+			"ship":    "token.metadata.ship",
+			"missing": "entity.metadata.missing",
+		},
+	}); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+
+	decoded, err := signMapped(b, storage, map[string]interface{}{"sub": "Bender"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"name": "Bender Bending Rodríguez",
+		"team": "delivery",
+		"role": "cook",
+		"ship": "planet-express-ship",
+		"sub":  "Bender",
+		"exp":  float64(5 * 60),
+		"iat":  float64(0),
+		"nbf":  float64(0),
+		"jti":  "1",
+		"iss":  testIssuer,
+	}
+
+	if diff := deep.Equal(expected, decoded); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestRejectMappedClaims(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if resp, err := writeConfig(b, storage, map[string]interface{}{
+		keyClaimMappings: map[string]interface{}{"team": "entity.metadata.team"},
+		keyAllowedClaims: []string{"aud", "sub", "team"},
+	}); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+
+	if _, err := signMapped(b, storage, map[string]interface{}{"team": "executive"}); err == nil {
+		t.Error("expected error setting a mapped claim")
+	}
+
+	if resp, err := writeConfig(b, storage, map[string]interface{}{
+		keyClaimMappings: map[string]interface{}{"iss": "entity.name"},
+	}); err == nil || resp != nil && !resp.IsError() {
+		t.Error("expected error mapping a reserved claim")
+	}
+}
+
+// fakeIdentity is a stand-in for the parts of the identity secrets engine used to look up group names, and the
+// token lookup used to read the metadata of testTokenAccessor.
+func fakeIdentity(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != testIdentityToken {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"errors":["permission denied"]}`)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/identity/entity/id/" + testEntity.ID:
+			fmt.Fprint(w, `{"data":{"group_ids":["g2","g1"]}}`)
+		case "/v1/identity/group/id/g1":
+			fmt.Fprint(w, `{"data":{"name":"planet-express"}}`)
+		case "/v1/identity/group/id/g2":
+			fmt.Fprint(w, `{"data":{"name":"delivery-crew"}}`)
+		case "/v1/auth/token/lookup-accessor":
+			var body map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body["accessor"] != testTokenAccessor {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"errors":["invalid accessor"]}`)
+				return
+			}
+			fmt.Fprint(w, `{"data":{"meta":{"ship":"planet-express-ship-2"}}}`)
+		default:
+			t.Errorf("unexpected request for %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestClaimMappingGroups(t *testing.T) {
+	server := fakeIdentity(t)
+	defer server.Close()
+
+	b, storage := getTestBackend(t)
+
+	if resp, err := writeConfig(b, storage, map[string]interface{}{
+		keyClaimMappings: map[string]interface{}{"groups": "entity.groups.names"},
+		keyAPIAddr:       server.URL,
+		keyIdentityToken: testIdentityToken,
+	}); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+
+	decoded, err := signMapped(b, storage, map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := deep.Equal([]interface{}{"delivery-crew", "planet-express"}, decoded["groups"]); diff != nil {
+		t.Error(diff)
+	}
+}
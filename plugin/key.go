@@ -1,41 +1,101 @@
 package jwtsecrets
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
-	"crypto/rsa"
+	"crypto/x509"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/cryptosigner"
 )
 
-// signingKey holds a RSA key with a specified TTL.
+// hmacSecretSizes maps each supported HMAC algorithm to the size in bytes of the secrets generated for it.
+var hmacSecretSizes = map[jose.SignatureAlgorithm]int{
+	jose.HS256: 32,
+	jose.HS384: 48,
+	jose.HS512: 64,
+}
+
+// signingKey holds a key with a specified TTL.
+// The private key may be held in memory or by an external key provider.
 type signingKey struct {
 	UseUntil  time.Time
 	KeepUntil time.Time
-	Key       *rsa.PrivateKey
+	Algorithm jose.SignatureAlgorithm
+	Key       crypto.Signer
 	ID        string
+
+	// Secret is the shared secret for HMAC keys, in which case Key is nil.
+	// Secrets are never published in the JSON Web Key Set.
+	Secret []byte
+
+	// Certificates is the chain of certificates for the key, starting with the key's own certificate.
+	// It is empty unless the backend is configured to issue certificates.
+	Certificates []*x509.Certificate
+}
+
+// joseKey returns the key in the form expected by jose.NewSigner.
+func (k *signingKey) joseKey() interface{} {
+	if k.Secret != nil {
+		return k.Secret
+	}
+	return cryptosigner.Opaque(k.Key)
+}
+
+// verificationKey returns the key which verifies signatures made with this key.
+func (k *signingKey) verificationKey() interface{} {
+	if k.Secret != nil {
+		return k.Secret
+	}
+	return k.Key.Public()
 }
 
-// getKey will return a valid key is one is available, or otherwise generate a new one.
-func (b *backend) getKey(validUntil time.Time) (*signingKey, error) {
-	key, err := b.getExistingKey(validUntil)
+// isJWSAlgorithm returns whether keys with the algorithm sign JWTs, and so can be published in the JSON Web Key Set.
+func isJWSAlgorithm(algorithm jose.SignatureAlgorithm) bool {
+	return !isPASETOAlgorithm(algorithm) && !isCOSEAlgorithm(algorithm)
+}
+
+// generateLocalKey creates a key for signing PASETO tokens or CWTs. These keys are always generated locally,
+// as the key providers only hold RSA keys.
+func generateLocalKey(algorithm jose.SignatureAlgorithm) (crypto.Signer, error) {
+	switch algorithm {
+	case pasetoV4Public, coseEdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	case pasetoV3Public:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case coseES256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %s", algorithm)
+	}
+}
+
+// getKey will return a valid key for the algorithm if one is available, or otherwise generate a new one.
+func (b *backend) getKey(algorithm jose.SignatureAlgorithm, validUntil time.Time) (*signingKey, error) {
+	key, err := b.getExistingKey(algorithm, validUntil)
 	if err == nil {
 		return key, nil
 	}
 
-	return b.getNewKey()
+	return b.getNewKey(algorithm)
 }
 
-func (b *backend) getExistingKey(validUntil time.Time) (*signingKey, error) {
+func (b *backend) getExistingKey(algorithm jose.SignatureAlgorithm, validUntil time.Time) (*signingKey, error) {
 	now := b.clock.now()
 
 	b.keysLock.RLock()
 	defer b.keysLock.RUnlock()
 
 	for _, k := range b.keys {
-		if k.UseUntil.After(now) && k.KeepUntil.After(validUntil) {
+		if k.Algorithm == algorithm && k.UseUntil.After(now) && k.KeepUntil.After(validUntil) {
 			return k, nil
 		}
 	}
@@ -43,51 +103,162 @@ func (b *backend) getExistingKey(validUntil time.Time) (*signingKey, error) {
 	return nil, errors.New("no valid key found")
 }
 
-func (b *backend) getNewKey() (*signingKey, error) {
+func (b *backend) getNewKey(algorithm jose.SignatureAlgorithm) (*signingKey, error) {
 	b.keysLock.Lock()
 	defer b.keysLock.Unlock()
 
-	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return nil, err
-	}
-
-	kid, err := uuid.NewRandom()
-	if err != nil {
-		return nil, err
-	}
-
 	b.configLock.RLock()
+	provider := b.config.keyProvider
+	rotationPeriod := b.config.KeyRotationPeriod
+	tokenTTL := b.config.TokenTTL
+	issueCertificates := b.config.IssueCertificates
+	caBundle := b.config.caBundle
+	b.configLock.RUnlock()
 
-	rotationTime := b.clock.now().Add(b.config.KeyRotationPeriod)
+	now := b.clock.now()
+	rotationTime := now.Add(rotationPeriod)
 
 	newKey := &signingKey{
-		ID:        kid.String(),
-		Key:       privateKey,
+		Algorithm: algorithm,
 		UseUntil:  rotationTime,
-		KeepUntil: rotationTime.Add(b.config.TokenTTL),
+		KeepUntil: rotationTime.Add(tokenTTL),
 	}
 
-	b.configLock.RUnlock()
+	if secretSize, ok := hmacSecretSizes[algorithm]; ok {
+		// HMAC secrets are always generated locally, as the key providers only hold asymmetric keys.
+		newKey.Secret = make([]byte, secretSize)
+		if _, err := rand.Read(newKey.Secret); err != nil {
+			return nil, err
+		}
+
+		kid, err := uuid.NewRandom()
+		if err != nil {
+			return nil, err
+		}
+		newKey.ID = kid.String()
+	} else if !isJWSAlgorithm(algorithm) {
+		signer, err := generateLocalKey(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		newKey.Key = signer
+
+		kid, err := uuid.NewRandom()
+		if err != nil {
+			return nil, err
+		}
+		newKey.ID = kid.String()
+	} else {
+		if algorithm != jose.RS256 {
+			return nil, fmt.Errorf("unsupported signing algorithm %s", algorithm)
+		}
+
+		kid, signer, err := provider.newKey()
+		if err != nil {
+			return nil, err
+		}
+		newKey.ID = kid
+		newKey.Key = signer
+
+		if issueCertificates {
+			newKey.Certificates, err = issueCertificate(caBundle, kid, signer.Public(), now, newKey.KeepUntil)
+			if err != nil {
+				return nil, fmt.Errorf("error issuing certificate: %v", err)
+			}
+		}
+	}
 
 	b.keys = append(b.keys, newKey)
+	b.advanceKeysSequence()
 	return newKey, nil
 }
 
+// advanceKeysSequence advances the key set's sequence number to the current time in nanoseconds, or by one if that
+// is not later, so it keeps increasing across restarts, failovers and restores rather than starting again from zero.
+// keysLock must be held.
+func (b *backend) advanceKeysSequence() {
+	b.keysSequence++
+	if now := b.clock.now().UnixNano(); now > b.keysSequence {
+		b.keysSequence = now
+	}
+}
+
+// getKeyByID returns the key with the given ID, or nil if there is no such key.
+func (b *backend) getKeyByID(kid string) *signingKey {
+	b.keysLock.RLock()
+	defer b.keysLock.RUnlock()
+
+	for _, k := range b.keys {
+		if k.ID == kid {
+			return k
+		}
+	}
+
+	return nil
+}
+
+// removeKeys removes every key matching the filter from the key set, returning the removed keys.
+func (b *backend) removeKeys(filter func(*signingKey) bool) []*signingKey {
+	b.keysLock.Lock()
+	defer b.keysLock.Unlock()
+
+	var removed []*signingKey
+	n := 0
+	for _, k := range b.keys {
+		if filter(k) {
+			removed = append(removed, k)
+			continue
+		}
+		b.keys[n] = k
+		n++
+	}
+	b.keys = b.keys[:n]
+
+	if len(removed) > 0 {
+		b.advanceKeysSequence()
+	}
+	b.destroyKeys(removed)
+
+	return removed
+}
+
 func (b *backend) pruneOldKeys() {
 	now := b.clock.now()
 
 	b.keysLock.Lock()
 	defer b.keysLock.Unlock()
 
+	var pruned []*signingKey
 	n := 0
 	for _, k := range b.keys {
 		if k.KeepUntil.After(now) {
 			b.keys[n] = k
 			n++
+		} else {
+			pruned = append(pruned, k)
 		}
 	}
+
+	if n < len(b.keys) {
+		b.advanceKeysSequence()
+	}
 	b.keys = b.keys[:n]
+	b.destroyKeys(pruned)
+}
+
+// destroyKeys destroys removed keys held by a key provider, so they cannot be used to sign again. Failures are only
+// logged, as the keys are already gone from the key set.
+func (b *backend) destroyKeys(keys []*signingKey) {
+	for _, k := range keys {
+		signer, ok := k.Key.(destroyableSigner)
+		if !ok {
+			continue
+		}
+
+		if err := signer.destroy(); err != nil {
+			b.Logger().Warn("error destroying removed signing key", "kid", k.ID, "error", err)
+		}
+	}
 }
 
 // GetPublicKeys returns a set of JSON Web Keys.
@@ -98,14 +269,27 @@ func (b *backend) getPublicKeys() *jose.JSONWebKeySet {
 	defer b.keysLock.RUnlock()
 
 	jwks := jose.JSONWebKeySet{
-		Keys: make([]jose.JSONWebKey, len(b.keys)),
+		Keys: make([]jose.JSONWebKey, 0, len(b.keys)),
 	}
 
-	for i, k := range b.keys {
-		jwks.Keys[i].Key = &k.Key.PublicKey
-		jwks.Keys[i].KeyID = k.ID
-		jwks.Keys[i].Algorithm = "RS256"
-		jwks.Keys[i].Use = "sig"
+	for _, k := range b.keys {
+		if k.Secret != nil || !isJWSAlgorithm(k.Algorithm) {
+			continue
+		}
+
+		jwk := jose.JSONWebKey{
+			Key:       k.Key.Public(),
+			KeyID:     k.ID,
+			Algorithm: string(k.Algorithm),
+			Use:       "sig",
+		}
+
+		if len(k.Certificates) > 0 {
+			jwk.Certificates = k.Certificates
+			jwk.CertificateThumbprintSHA1, jwk.CertificateThumbprintSHA256 = certificateThumbprints(k.Certificates[0])
+		}
+
+		jwks.Keys = append(jwks.Keys, jwk)
 	}
 
 	return &jwks
@@ -0,0 +1,104 @@
+package jwtsecrets
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// profileKubernetes restricts signed tokens to ones shaped like Kubernetes bound service account tokens.
+	profileKubernetes = "kubernetes"
+
+	// kubernetesClaim is the claim holding the namespace and service account a Kubernetes token was issued to.
+	kubernetesClaim = "kubernetes.io"
+
+	// kubernetesSubjectPrefix prefixes the namespace and name of a service account in the 'sub' claim.
+	kubernetesSubjectPrefix = "system:serviceaccount:"
+
+	// kubernetesLabelMaxLength and kubernetesSubdomainMaxLength are the longest DNS labels and subdomains
+	// Kubernetes accepts as names, from RFC 1123.
+	kubernetesLabelMaxLength     = 63
+	kubernetesSubdomainMaxLength = 253
+)
+
+var kubernetesLabel = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// validateKubernetesConfig checks that the config can be used to sign service account tokens.
+func validateKubernetesConfig(config *Config) error {
+	if _, ok := hmacSecretSizes[config.SigningAlgorithm]; ok {
+		return fmt.Errorf("service account tokens cannot be signed with %s, as clusters verify them with the published keys", config.SigningAlgorithm)
+	}
+
+	return nil
+}
+
+// validateKubernetesClaims checks that a set of claims supplied by a caller forms a valid service account token:
+// the subject must name a service account whose namespace and name match the configured patterns, and at least
+// one audience must be set. The 'kubernetes.io' claim is set from the subject.
+func validateKubernetesClaims(config *Config, claims map[string]interface{}) error {
+	rawSub, ok := claims["sub"]
+	if !ok {
+		return errors.New("'sub' claim is required")
+	}
+
+	sub, ok := rawSub.(string)
+	if !ok {
+		return fmt.Errorf("'sub' claim was %T, not string", rawSub)
+	}
+
+	namespace, name, err := parseKubernetesSubject(sub)
+	if err != nil {
+		return err
+	}
+
+	if !config.KubernetesNamespacePattern.MatchString(namespace) {
+		return errors.New("validation of namespace failed")
+	}
+
+	if !config.KubernetesServiceAccountPattern.MatchString(name) {
+		return errors.New("validation of service account name failed")
+	}
+
+	if !hasAudience(claims) {
+		return errors.New("'aud' claim is required")
+	}
+
+	claims[kubernetesClaim] = map[string]interface{}{
+		"namespace": namespace,
+		"serviceaccount": map[string]interface{}{
+			"name": name,
+		},
+	}
+
+	return nil
+}
+
+// parseKubernetesSubject returns the namespace and name of the service account in the subject of a service account token.
+func parseKubernetesSubject(sub string) (string, string, error) {
+	if !strings.HasPrefix(sub, kubernetesSubjectPrefix) {
+		return "", "", fmt.Errorf("'sub' claim must start with %s", kubernetesSubjectPrefix)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(sub, kubernetesSubjectPrefix), ":")
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("'sub' claim must be %s<namespace>:<name>", kubernetesSubjectPrefix)
+	}
+	namespace, name := parts[0], parts[1]
+
+	if len(namespace) > kubernetesLabelMaxLength || !kubernetesLabel.MatchString(namespace) {
+		return "", "", fmt.Errorf("namespace %q is not a valid DNS label", namespace)
+	}
+
+	if len(name) > kubernetesSubdomainMaxLength {
+		return "", "", fmt.Errorf("service account name %q is not a valid DNS subdomain", name)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if !kubernetesLabel.MatchString(label) {
+			return "", "", fmt.Errorf("service account name %q is not a valid DNS subdomain", name)
+		}
+	}
+
+	return namespace, name, nil
+}
@@ -0,0 +1,108 @@
+package jwtsecrets
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestParseKubernetesSubject(t *testing.T) {
+	namespace, name, err := parseKubernetesSubject("system:serviceaccount:planet-express:delivery.crew")
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal([]string{"planet-express", "delivery.crew"}, []string{namespace, name}); diff != nil {
+		t.Error(diff)
+	}
+
+	invalid := []string{
+		"",
+		"Zapp Brannigan",
+		"system:serviceaccount:planet-express",
+		"system:serviceaccount:planet-express:crew:fry",
+		"system:serviceaccount::crew",
+		"system:serviceaccount:planet-express:",
+		"system:serviceaccount:Planet-Express:crew",
+		"system:serviceaccount:planet-express-:crew",
+		"system:serviceaccount:planet.express:crew",
+		"system:serviceaccount:planet-express:crew..fry",
+		"system:serviceaccount:planet-express:Crew",
+		"system:user:planet-express:crew",
+	}
+
+	for _, sub := range invalid {
+		if _, _, err := parseKubernetesSubject(sub); err == nil {
+			t.Errorf("%s: expected error", sub)
+		}
+	}
+}
+
+func TestKubernetesConfig(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	rejected := map[string]map[string]interface{}{
+		"hmac":                    {keyProfile: profileKubernetes, keySigningAlgorithm: "HS256"},
+		"invalid namespace regex": {keyKubernetesNamespacePattern: "("},
+		"invalid name regex":      {keyKubernetesServiceAccountPattern: "("},
+	}
+
+	for name, data := range rejected {
+		resp, err := writeConfig(b, storage, data)
+		if err == nil || resp != nil && !resp.IsError() {
+			t.Errorf("%s: expected error, got resp:%#v\n", name, resp)
+		}
+	}
+
+	if b.config.Profile != "" {
+		t.Errorf("expected rejected updates to leave the profile unset, got %s", b.config.Profile)
+	}
+}
+
+func TestSignKubernetesToken(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	resp, err := writeConfig(b, storage, map[string]interface{}{
+		keyProfile:                         profileKubernetes,
+		keyKubernetesNamespacePattern:      "^planet-express$",
+		keyKubernetesServiceAccountPattern: "^crew-",
+		// The subject pattern is not applied under the profile.
+		keySubjectPattern: "^spiffe://",
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	var claims map[string]interface{}
+	if err := getSignedToken(b, storage, map[string]interface{}{
+		"sub": "system:serviceaccount:planet-express:crew-fry",
+		"aud": []string{"https://kubernetes.default.svc"},
+	}, &claims); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	expected := map[string]interface{}{
+		"namespace": "planet-express",
+		"serviceaccount": map[string]interface{}{
+			"name": "crew-fry",
+		},
+	}
+
+	if diff := deep.Equal(expected, claims["kubernetes.io"]); diff != nil {
+		t.Error(diff)
+	}
+
+	rejected := map[string]map[string]interface{}{
+		"no subject":      {"aud": "https://kubernetes.default.svc"},
+		"other namespace": {"sub": "system:serviceaccount:mom-corp:crew-fry", "aud": "https://kubernetes.default.svc"},
+		"other name":      {"sub": "system:serviceaccount:planet-express:zoidberg", "aud": "https://kubernetes.default.svc"},
+		"not an account":  {"sub": "Zapp Brannigan", "aud": "https://kubernetes.default.svc"},
+		"no audience":     {"sub": "system:serviceaccount:planet-express:crew-fry"},
+	}
+
+	for name, claims := range rejected {
+		if _, err = getRawToken(b, storage, claims); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
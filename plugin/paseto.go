@@ -0,0 +1,173 @@
+package jwtsecrets
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	// pasetoV4Public and pasetoV3Public are the algorithms of keys which sign PASETO tokens, named after the
+	// version and purpose of the tokens they sign. They are never published in the JSON Web Key Set, as PASETO
+	// keys must not be used with other protocols.
+	pasetoV4Public jose.SignatureAlgorithm = "v4.public"
+	pasetoV3Public jose.SignatureAlgorithm = "v3.public"
+
+	// p384CoordinateSize is the size in bytes of a P-384 field element.
+	p384CoordinateSize = 48
+)
+
+// pasetoTimeClaims are the registered claims which PASETO encodes as RFC 3339 strings rather than numeric dates.
+var pasetoTimeClaims = []string{"exp", "iat", "nbf"}
+
+// isPASETOAlgorithm returns whether keys with the algorithm sign PASETO tokens.
+func isPASETOAlgorithm(algorithm jose.SignatureAlgorithm) bool {
+	return algorithm == pasetoV4Public || algorithm == pasetoV3Public
+}
+
+// signPASETO signs a set of claims as a public PASETO token, with the key ID in the footer.
+func signPASETO(key *signingKey, claims map[string]interface{}) (string, error) {
+	pasetoClaims := make(map[string]interface{}, len(claims))
+	for claim, value := range claims {
+		pasetoClaims[claim] = value
+	}
+
+	for _, claim := range pasetoTimeClaims {
+		if date, ok := pasetoClaims[claim].(jwt.NumericDate); ok {
+			pasetoClaims[claim] = date.Time().UTC().Format(time.RFC3339)
+		}
+	}
+
+	message, err := json.Marshal(pasetoClaims)
+	if err != nil {
+		return "", err
+	}
+
+	footer, err := json.Marshal(map[string]string{"kid": key.ID})
+	if err != nil {
+		return "", err
+	}
+
+	return signPASETOMessage(key, message, footer)
+}
+
+// signPASETOMessage signs a message as a public PASETO token. The footer is omitted if empty.
+func signPASETOMessage(key *signingKey, message, footer []byte) (string, error) {
+	header := string(key.Algorithm) + "."
+
+	var signature []byte
+	switch key.Algorithm {
+	case pasetoV4Public:
+		var err error
+		signature, err = key.Key.Sign(rand.Reader, preAuthEncode([]byte(header), message, footer, nil), crypto.Hash(0))
+		if err != nil {
+			return "", err
+		}
+	case pasetoV3Public:
+		publicKey, ok := key.Key.Public().(*ecdsa.PublicKey)
+		if !ok {
+			return "", fmt.Errorf("key %s is not an ECDSA key", key.ID)
+		}
+
+		digest := sha512.Sum384(preAuthEncode(compressP384(publicKey), []byte(header), message, footer, nil))
+		der, err := key.Key.Sign(rand.Reader, digest[:], crypto.SHA384)
+		if err != nil {
+			return "", err
+		}
+
+		if signature, err = ecdsaSignatureToRaw(der, p384CoordinateSize); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("key %s does not sign PASETO tokens", key.ID)
+	}
+
+	token := header + base64.RawURLEncoding.EncodeToString(append(append([]byte{}, message...), signature...))
+	if len(footer) > 0 {
+		token += "." + base64.RawURLEncoding.EncodeToString(footer)
+	}
+
+	return token, nil
+}
+
+// paserkPublicKey returns the PASERK public key serialization of a key which signs PASETO tokens.
+func paserkPublicKey(key *signingKey) (string, error) {
+	switch key.Algorithm {
+	case pasetoV4Public:
+		publicKey, ok := key.Key.Public().(ed25519.PublicKey)
+		if !ok {
+			return "", fmt.Errorf("key %s is not an Ed25519 key", key.ID)
+		}
+		return "k4.public." + base64.RawURLEncoding.EncodeToString(publicKey), nil
+	case pasetoV3Public:
+		publicKey, ok := key.Key.Public().(*ecdsa.PublicKey)
+		if !ok {
+			return "", fmt.Errorf("key %s is not an ECDSA key", key.ID)
+		}
+		return "k3.public." + base64.RawURLEncoding.EncodeToString(compressP384(publicKey)), nil
+	default:
+		return "", fmt.Errorf("key %s does not sign PASETO tokens", key.ID)
+	}
+}
+
+// preAuthEncode is the PASETO pre-authentication encoding of a list of byte strings.
+func preAuthEncode(pieces ...[]byte) []byte {
+	var buf bytes.Buffer
+
+	writeLength := func(n int) {
+		var length [8]byte
+		// The most significant bit is cleared for compatibility with languages without unsigned integers.
+		binary.LittleEndian.PutUint64(length[:], uint64(n)&(1<<63-1))
+		buf.Write(length[:])
+	}
+
+	writeLength(len(pieces))
+	for _, piece := range pieces {
+		writeLength(len(piece))
+		buf.Write(piece)
+	}
+
+	return buf.Bytes()
+}
+
+// compressP384 returns the SEC 1 compressed encoding of a P-384 public key.
+func compressP384(publicKey *ecdsa.PublicKey) []byte {
+	compressed := make([]byte, 1+p384CoordinateSize)
+	compressed[0] = byte(2 + publicKey.Y.Bit(0))
+	fillBytes(publicKey.X, compressed[1:])
+	return compressed
+}
+
+// ecdsaSignatureToRaw converts an ASN.1 DER encoded ECDSA signature to the fixed size concatenation of r and s.
+func ecdsaSignatureToRaw(der []byte, size int) ([]byte, error) {
+	var signature struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(der, &signature); err != nil {
+		return nil, err
+	}
+
+	raw := make([]byte, 2*size)
+	fillBytes(signature.R, raw[:size])
+	fillBytes(signature.S, raw[size:])
+	return raw, nil
+}
+
+// fillBytes writes the absolute value of x to buf as a zero-extended big-endian byte slice.
+func fillBytes(x *big.Int, buf []byte) {
+	b := x.Bytes()
+	copy(buf[len(buf)-len(b):], b)
+}
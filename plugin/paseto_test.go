@@ -0,0 +1,240 @@
+package jwtsecrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestPreAuthEncode(t *testing.T) {
+	tests := []struct {
+		pieces   [][]byte
+		expected string
+	}{
+		{nil, "0000000000000000"},
+		{[][]byte{{}}, "01000000000000000000000000000000"},
+		{[][]byte{{}, {}}, "020000000000000000000000000000000000000000000000"},
+		{[][]byte{[]byte("test")}, "0100000000000000040000000000000074657374"},
+	}
+
+	for _, test := range tests {
+		if diff := deep.Equal(test.expected, hex.EncodeToString(preAuthEncode(test.pieces...))); diff != nil {
+			t.Error(diff)
+		}
+	}
+}
+
+// Test vector 4-S-1 from the PASETO specification.
+func TestPASETOV4TestVector(t *testing.T) {
+	secretKey, err := hex.DecodeString("b4cbfb43df4ce210727d953e4a713307fa19bb7d9f85041438d9e11b942a3774" +
+		"1eb9dbbbbc047c03fd70604e0071f0987e16b28b757225c11f00415d0e20b1a2")
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	key := &signingKey{
+		Algorithm: pasetoV4Public,
+		Key:       ed25519.PrivateKey(secretKey),
+	}
+
+	token, err := signPASETOMessage(key, []byte(`{"data":"this is a signed message","exp":"2022-01-01T00:00:00+00:00"}`), nil)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	expected := "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9" +
+		"bg_XBBzds8lTZShVlwwKSgeKpLT3yukTw6JUz3W4h_ExsQV-P0V54zemZDcAxFaSeef1QlXEFtkqxT1ciiQEDA"
+
+	if diff := deep.Equal(expected, token); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func getPASETOToken(t *testing.T, b *backend, storage *logical.Storage, format string) string {
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "sign",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			"format": format,
+			"claims": map[string]interface{}{
+				"sub": "Zapp Brannigan",
+			},
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	return resp.Data["token"].(string)
+}
+
+// splitPASETO decodes a public PASETO token into its message, signature and footer.
+func splitPASETO(t *testing.T, token, header string, signatureSize int) ([]byte, []byte, []byte) {
+	if !strings.HasPrefix(token, header) {
+		t.Fatalf("token %s does not start with %s", token, header)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(token, header), ".")
+	if len(parts) != 2 {
+		t.Fatalf("expected a payload and footer, got %d parts", len(parts))
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	footer, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	split := len(payload) - signatureSize
+	return payload[:split], payload[split:], footer
+}
+
+func checkPASETOClaims(t *testing.T, b *backend, message, footer []byte) {
+	var claims map[string]interface{}
+	if err := json.Unmarshal(message, &claims); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	expectedClaims := map[string]interface{}{
+		"sub": "Zapp Brannigan",
+		"exp": "1970-01-01T00:05:00Z",
+		"iat": "1970-01-01T00:00:00Z",
+		"nbf": "1970-01-01T00:00:00Z",
+		"iss": testIssuer,
+		"jti": "1",
+	}
+
+	if diff := deep.Equal(expectedClaims, claims); diff != nil {
+		t.Error(diff)
+	}
+
+	expectedFooter := `{"kid":"` + b.keys[0].ID + `"}`
+	if diff := deep.Equal(expectedFooter, string(footer)); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func readPaserk(t *testing.T, b *backend, storage *logical.Storage) []map[string]interface{} {
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "paserk",
+		Storage:   *storage,
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	return resp.Data["keys"].([]map[string]interface{})
+}
+
+func TestSignPASETOV4(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	token := getPASETOToken(t, b, storage, "v4.public")
+	message, signature, footer := splitPASETO(t, token, "v4.public.", ed25519.SignatureSize)
+
+	checkPASETOClaims(t, b, message, footer)
+
+	keys := readPaserk(t, b, storage)
+	if len(keys) != 1 {
+		t.Fatalf("expected 1 key, got %#v", keys)
+	}
+
+	publicKey, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(keys[0]["paserk"].(string), "k4.public."))
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if !ed25519.Verify(publicKey, preAuthEncode([]byte("v4.public."), message, footer, nil), signature) {
+		t.Error("signature did not verify against the published key")
+	}
+
+	// PASETO keys are never published in the JSON Web Key Set.
+	if jwks := b.getPublicKeys().Keys; len(jwks) != 0 {
+		t.Errorf("expected no JSON Web Keys, got %#v", jwks)
+	}
+}
+
+func TestSignPASETOV3(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	token := getPASETOToken(t, b, storage, "v3.public")
+	message, signature, footer := splitPASETO(t, token, "v3.public.", 2*p384CoordinateSize)
+
+	checkPASETOClaims(t, b, message, footer)
+
+	publicKey := b.keys[0].Key.Public().(*ecdsa.PublicKey)
+	compressed := compressP384(publicKey)
+
+	keys := readPaserk(t, b, storage)
+	if diff := deep.Equal("k3.public."+base64.RawURLEncoding.EncodeToString(compressed), keys[0]["paserk"]); diff != nil {
+		t.Error(diff)
+	}
+
+	digest := sha512.Sum384(preAuthEncode(compressed, []byte("v3.public."), message, footer, nil))
+	r := new(big.Int).SetBytes(signature[:p384CoordinateSize])
+	s := new(big.Int).SetBytes(signature[p384CoordinateSize:])
+
+	if !ecdsa.Verify(publicKey, digest[:], r, s) {
+		t.Error("signature did not verify")
+	}
+}
+
+func TestCompressP384(t *testing.T) {
+	b, storage := getTestBackend(t)
+	getPASETOToken(t, b, storage, "v3.public")
+
+	publicKey := b.keys[0].Key.Public().(*ecdsa.PublicKey)
+	compressed := compressP384(publicKey)
+
+	if len(compressed) != 49 || compressed[0] != byte(2+publicKey.Y.Bit(0)) {
+		t.Errorf("invalid compressed point %x", compressed)
+	}
+
+	if !bytes.Equal(compressed[1:], leftPad(publicKey.X.Bytes(), p384CoordinateSize)) {
+		t.Errorf("compressed point %x does not contain X coordinate", compressed)
+	}
+}
+
+func leftPad(b []byte, size int) []byte {
+	return append(make([]byte, size-len(b)), b...)
+}
+
+func TestSignPASETORejected(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "sign",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			"format": "v2.local",
+			"claims": map[string]interface{}{"sub": "Zapp Brannigan"},
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err == nil || resp != nil && !resp.IsError() {
+		t.Errorf("expected error, got resp:%#v\n", resp)
+	}
+}
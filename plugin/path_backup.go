@@ -0,0 +1,439 @@
+package jwtsecrets
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2"
+)
+
+const (
+	keyBackup    = "backup"
+	keyBackupKey = "backup_key"
+
+	// backupVersion is the version of the backup format produced by this backend.
+	backupVersion = 2
+
+	// backupKeySize is the size in bytes of the AES-256 key backups are encrypted with.
+	backupKeySize = 32
+)
+
+// backupBlob holds a backup encrypted with AES-GCM under a key supplied by the operator, so that it can be
+// restored into any mount given the same key, and corrupted or modified backups are rejected.
+type backupBlob struct {
+	Version int    `json:"version"`
+	Nonce   []byte `json:"nonce"`
+	Backup  []byte `json:"backup"`
+}
+
+// backupContents is everything needed to rehydrate a mount.
+type backupContents struct {
+	Config      map[string]interface{} `json:"config"`
+	Keys        []backupKey            `json:"keys"`
+	Revocations []keyRevocation        `json:"revocations"`
+}
+
+// backupKey is the serialized form of a signingKey.
+type backupKey struct {
+	ID        string    `json:"id"`
+	UseUntil  time.Time `json:"use_until"`
+	KeepUntil time.Time `json:"keep_until"`
+	Algorithm string    `json:"algorithm"`
+	Key       []byte    `json:"key,omitempty"`
+	Secret    []byte    `json:"secret,omitempty"`
+
+	Certificates [][]byte `json:"certificates,omitempty"`
+}
+
+func pathBackup(b *backend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "backup",
+			Fields: map[string]*framework.FieldSchema{
+				keyBackupKey: {
+					Type:        framework.TypeString,
+					Description: `Base64 encoded 32 byte key to encrypt the backup with.`,
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathBackupWrite,
+				},
+			},
+
+			HelpSynopsis:    pathBackupHelpSyn,
+			HelpDescription: pathBackupHelpDesc,
+		},
+		{
+			Pattern: "restore",
+			Fields: map[string]*framework.FieldSchema{
+				keyBackup: {
+					Type:        framework.TypeString,
+					Description: `Backup blob produced by the backup endpoint.`,
+				},
+				keyBackupKey: {
+					Type:        framework.TypeString,
+					Description: `Base64 encoded 32 byte key the backup was encrypted with.`,
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathRestoreWrite,
+				},
+			},
+
+			HelpSynopsis:    pathRestoreHelpSyn,
+			HelpDescription: pathRestoreHelpDesc,
+		},
+	}
+}
+
+func (b *backend) pathBackupWrite(c context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	encryptionKey, err := parseBackupKey(d)
+	if err != nil {
+		return logical.ErrorResponse("invalid '%s': %v", keyBackupKey, err), logical.ErrInvalidRequest
+	}
+
+	b.configLock.RLock()
+	exportable := b.config.Exportable
+	caBundle := b.config.caBundle
+	configResp, err := nonLockingRead(b)
+	b.configLock.RUnlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !exportable {
+		return logical.ErrorResponse("backups are not permitted unless '%s' is set", keyExportable), logical.ErrPermissionDenied
+	}
+
+	contents := backupContents{
+		Config: configResp.Data,
+	}
+
+	// The CA certificate is replaced by the full bundle so the restored mount can keep issuing certificates.
+	delete(contents.Config, keyCACertificate)
+	if caBundle != nil {
+		certBundle, err := caBundle.ToCertBundle()
+		if err != nil {
+			return nil, err
+		}
+		contents.Config[keyCAPEMBundle] = certBundle.ToPEMBundle()
+	}
+
+	b.pruneOldKeys()
+
+	b.keysLock.RLock()
+	for _, k := range b.keys {
+		var key []byte
+		if k.Key != nil {
+			if key, err = x509.MarshalPKCS8PrivateKey(k.Key); err != nil {
+				b.keysLock.RUnlock()
+				return logical.ErrorResponse("key %s cannot be exported: %v", k.ID, err), logical.ErrInvalidRequest
+			}
+		}
+
+		certificates := make([][]byte, len(k.Certificates))
+		for i, certificate := range k.Certificates {
+			certificates[i] = certificate.Raw
+		}
+
+		contents.Keys = append(contents.Keys, backupKey{
+			ID:           k.ID,
+			UseUntil:     k.UseUntil,
+			KeepUntil:    k.KeepUntil,
+			Algorithm:    string(k.Algorithm),
+			Key:          key,
+			Secret:       k.Secret,
+			Certificates: certificates,
+		})
+	}
+	b.keysLock.RUnlock()
+
+	revoked, err := r.Storage.List(c, revokedKeysPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, kid := range revoked {
+		revocation, err := b.getKeyRevocation(c, r.Storage, kid)
+		if err != nil {
+			return nil, err
+		}
+		if revocation != nil {
+			contents.Revocations = append(contents.Revocations, *revocation)
+		}
+	}
+
+	backup, err := encodeBackup(&contents, encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			keyBackup: backup,
+		},
+	}, nil
+}
+
+func (b *backend) pathRestoreWrite(c context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	rawBackup, ok := d.GetOk(keyBackup)
+	if !ok {
+		return logical.ErrorResponse("no backup provided"), logical.ErrInvalidRequest
+	}
+
+	encryptionKey, err := parseBackupKey(d)
+	if err != nil {
+		return logical.ErrorResponse("invalid '%s': %v", keyBackupKey, err), logical.ErrInvalidRequest
+	}
+
+	contents, err := decodeBackup(rawBackup.(string), encryptionKey)
+	if err != nil {
+		return logical.ErrorResponse("invalid backup: %v", err), logical.ErrInvalidRequest
+	}
+
+	keys := make([]*signingKey, len(contents.Keys))
+	for i, k := range contents.Keys {
+		var signer crypto.Signer
+		if k.Secret == nil {
+			privateKey, err := x509.ParsePKCS8PrivateKey(k.Key)
+			if err != nil {
+				return logical.ErrorResponse("invalid key %s: %v", k.ID, err), logical.ErrInvalidRequest
+			}
+
+			var ok bool
+			if signer, ok = privateKey.(crypto.Signer); !ok {
+				return logical.ErrorResponse("invalid key %s: %T is not a signing key", k.ID, privateKey), logical.ErrInvalidRequest
+			}
+		}
+
+		if err := checkBackupKey(jose.SignatureAlgorithm(k.Algorithm), signer, k.Secret); err != nil {
+			return logical.ErrorResponse("invalid key %s: %v", k.ID, err), logical.ErrInvalidRequest
+		}
+
+		certificates := make([]*x509.Certificate, len(k.Certificates))
+		for j, rawCertificate := range k.Certificates {
+			if certificates[j], err = x509.ParseCertificate(rawCertificate); err != nil {
+				return logical.ErrorResponse("invalid certificate for key %s: %v", k.ID, err), logical.ErrInvalidRequest
+			}
+		}
+
+		keys[i] = &signingKey{
+			ID:           k.ID,
+			UseUntil:     k.UseUntil,
+			KeepUntil:    k.KeepUntil,
+			Algorithm:    jose.SignatureAlgorithm(k.Algorithm),
+			Key:          signer,
+			Secret:       k.Secret,
+			Certificates: certificates,
+		}
+	}
+
+	b.keysLock.Lock()
+	defer b.keysLock.Unlock()
+
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+
+	if len(b.keys) != 0 {
+		return logical.ErrorResponse("backups can only be restored into an empty mount"), logical.ErrInvalidRequest
+	}
+
+	config := *b.config
+	configData := &framework.FieldData{
+		Raw:    contents.Config,
+		Schema: pathConfig(b).Fields,
+	}
+	if err = updateConfig(&config, configData); err != nil {
+		return logical.ErrorResponse("invalid config: %v", err), logical.ErrInvalidRequest
+	}
+
+	for _, revocation := range contents.Revocations {
+		entry, err := logical.StorageEntryJSON(revokedKeysPrefix+revocation.KeyID, revocation)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = r.Storage.Put(c, entry); err != nil {
+			return nil, err
+		}
+	}
+
+	b.setConfig(&config)
+	b.keys = keys
+	b.advanceKeysSequence()
+
+	return nil, nil
+}
+
+// parseBackupKey returns the key supplied by the operator to encrypt or decrypt a backup.
+func parseBackupKey(d *framework.FieldData) ([]byte, error) {
+	rawKey, ok := d.GetOk(keyBackupKey)
+	if !ok {
+		return nil, errors.New("no key provided")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(rawKey.(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(key) != backupKeySize {
+		return nil, fmt.Errorf("key must be %d bytes", backupKeySize)
+	}
+
+	return key, nil
+}
+
+// checkBackupKey checks that a restored key is of a type the backend signs with, and is for its algorithm.
+func checkBackupKey(algorithm jose.SignatureAlgorithm, signer crypto.Signer, secret []byte) error {
+	if secretSize, ok := hmacSecretSizes[algorithm]; ok {
+		if len(secret) != secretSize {
+			return fmt.Errorf("%s secrets must be %d bytes", algorithm, secretSize)
+		}
+		return nil
+	}
+
+	var valid bool
+	switch key := signer.(type) {
+	case *rsa.PrivateKey:
+		valid = algorithm == jose.RS256
+	case ed25519.PrivateKey:
+		valid = algorithm == pasetoV4Public || algorithm == coseEdDSA
+	case *ecdsa.PrivateKey:
+		valid = algorithm == pasetoV3Public && key.Curve == elliptic.P384() ||
+			algorithm == coseES256 && key.Curve == elliptic.P256()
+	case nil:
+		return fmt.Errorf("unsupported signing algorithm %s", algorithm)
+	}
+
+	if !valid {
+		return fmt.Errorf("%T is not a %s key", signer, algorithm)
+	}
+
+	return nil
+}
+
+// encodeBackup serializes the contents of a backup as a blob encrypted with the operator's backup key.
+func encodeBackup(contents *backupContents, encryptionKey []byte) (string, error) {
+	encodedContents, err := json.Marshal(contents)
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := backupCipher(encryptionKey)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	encodedBlob, err := json.Marshal(backupBlob{
+		Version: backupVersion,
+		Nonce:   nonce,
+		Backup:  aead.Seal(nil, nonce, encodedContents, nil),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(encodedBlob), nil
+}
+
+// decodeBackup checks the version of a backup blob, and decrypts and authenticates its contents.
+func decodeBackup(rawBackup string, encryptionKey []byte) (*backupContents, error) {
+	decoded, err := base64.StdEncoding.DecodeString(rawBackup)
+	if err != nil {
+		return nil, err
+	}
+
+	var blob backupBlob
+	if err = json.Unmarshal(decoded, &blob); err != nil {
+		return nil, err
+	}
+
+	if blob.Version != backupVersion {
+		return nil, fmt.Errorf("unsupported backup version %d", blob.Version)
+	}
+
+	aead, err := backupCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(blob.Nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce")
+	}
+
+	encodedContents, err := aead.Open(nil, blob.Nonce, blob.Backup, nil)
+	if err != nil {
+		return nil, errors.New("backup was not encrypted with this key, or has been modified")
+	}
+
+	var contents backupContents
+	if err = json.Unmarshal(encodedContents, &contents); err != nil {
+		return nil, err
+	}
+
+	return &contents, nil
+}
+
+// backupCipher returns the AES-GCM cipher backups are encrypted with.
+func backupCipher(encryptionKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+const pathBackupHelpSyn = `
+Back up the signing keys and config.
+`
+
+const pathBackupHelpDesc = `
+Export the signing keys, config and key revocations as a versioned blob which
+can be passed to the restore endpoint of a new mount, or of the same mount once
+its signing keys are lost, such as after the plugin is reloaded. Only permitted
+if the 'exportable' config option is set.
+
+backup_key: Base64 encoded 32 byte key to encrypt the backup with, using AES-GCM.
+            The same key must be supplied to restore the backup, so it should be
+            kept separately from the backup.
+`
+
+const pathRestoreHelpSyn = `
+Restore the signing keys and config from a backup.
+`
+
+const pathRestoreHelpDesc = `
+Restore the signing keys, config and key revocations from a blob produced by the
+backup endpoint. The mount must not have any signing keys. Keys with an
+unsupported algorithm, or which do not match their algorithm, are rejected.
+
+backup:     Backup blob produced by the backup endpoint.
+backup_key: Base64 encoded 32 byte key the backup was encrypted with.
+`
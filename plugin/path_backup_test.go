@@ -0,0 +1,218 @@
+package jwtsecrets
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// testBackupKey is the key backups are encrypted with in tests.
+var testBackupKey = base64.StdEncoding.EncodeToString([]byte("planet-express-delivery-crew-key"))
+
+func getBackup(b *backend, storage *logical.Storage) (*logical.Response, error) {
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "backup",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			keyBackupKey: testBackupKey,
+		},
+	}
+
+	return b.HandleRequest(context.Background(), req)
+}
+
+func restoreBackup(b *backend, storage *logical.Storage, backup, key string) (*logical.Response, error) {
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "restore",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			keyBackup:    backup,
+			keyBackupKey: key,
+		},
+	}
+
+	return b.HandleRequest(context.Background(), req)
+}
+
+func TestBackupNotExportable(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	resp, err := getBackup(b, storage)
+	if err == nil || resp != nil && !resp.IsError() {
+		t.Fatalf("expected to get an error from backup. got:%v\n", resp)
+	}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			keyExportable:          true,
+			keyIssuer:              newIssuer,
+			keyMaxAllowedAudiences: 2,
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	token, err := getRawToken(b, storage, map[string]interface{}{"sub": "Zapp Brannigan"})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err = getBackup(b, storage)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	backup := resp.Data[keyBackup].(string)
+
+	// The backup is restored into a new mount, as it would be after the original mount is lost.
+	restored, restoredStorage := getTestBackend(t)
+
+	resp, err = restoreBackup(restored, restoredStorage, backup, testBackupKey)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	if diff := deep.Equal(b.getPublicKeys(), restored.getPublicKeys()); diff != nil {
+		t.Error(diff)
+	}
+
+	if diff := deep.Equal(b.config, restored.config); diff != nil {
+		t.Error(diff)
+	}
+
+	resp, err = verifyToken(restored, restoredStorage, token)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// Restoring into a mount which already has keys should fail.
+	resp, err = restoreBackup(restored, restoredStorage, backup, testBackupKey)
+	if err == nil || resp != nil && !resp.IsError() {
+		t.Fatalf("expected to get an error from restore. got:%v\n", resp)
+	}
+}
+
+func TestRestoreCorruptedBackup(t *testing.T) {
+	b, storage := getTestBackend(t)
+	b.config.Exportable = true
+
+	if _, err := b.getNewKey(jose.RS256); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := getBackup(b, storage)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	backup := []byte(resp.Data[keyBackup].(string))
+	backup[len(backup)/2]++
+
+	restored, restoredStorage := getTestBackend(t)
+
+	resp, err = restoreBackup(restored, restoredStorage, string(backup), testBackupKey)
+	if err == nil || resp != nil && !resp.IsError() {
+		t.Fatalf("expected to get an error from restore. got:%v\n", resp)
+	}
+}
+
+func TestBackupKeyRequired(t *testing.T) {
+	b, storage := getTestBackend(t)
+	b.config.Exportable = true
+
+	invalid := []map[string]interface{}{
+		{},
+		{keyBackupKey: "not base64!"},
+		{keyBackupKey: base64.StdEncoding.EncodeToString([]byte("too short"))},
+	}
+
+	for _, data := range invalid {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "backup",
+			Storage:   *storage,
+			Data:      data,
+		}
+
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err == nil || resp != nil && !resp.IsError() {
+			t.Errorf("%v: expected to get an error from backup. got:%v\n", data, resp)
+		}
+	}
+}
+
+func TestRestoreBackupWithOtherKey(t *testing.T) {
+	b, storage := getTestBackend(t)
+	b.config.Exportable = true
+
+	if _, err := b.getNewKey(jose.RS256); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := getBackup(b, storage)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	backup := resp.Data[keyBackup].(string)
+
+	other, otherStorage := getTestBackend(t)
+
+	otherKey := base64.StdEncoding.EncodeToString([]byte("mom-corp-friendly-robot-company!"))
+	resp, err = restoreBackup(other, otherStorage, backup, otherKey)
+	if err == nil || resp != nil && !resp.IsError() {
+		t.Fatalf("expected to get an error from restore. got:%v\n", resp)
+	}
+
+	if diff := deep.Equal(0, len(other.keys)); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestRestoreUnsupportedKey(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	key, err := base64.StdEncoding.DecodeString(testBackupKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := make([]byte, 32)
+	invalid := []backupKey{
+		{ID: "1", Algorithm: "none", Secret: secret},
+		{ID: "2", Algorithm: string(jose.HS512), Secret: secret},
+		{ID: "3", Algorithm: string(jose.RS256), Secret: secret},
+	}
+
+	for _, k := range invalid {
+		backup, err := encodeBackup(&backupContents{
+			Config: map[string]interface{}{},
+			Keys:   []backupKey{k},
+		}, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := restoreBackup(b, storage, backup, testBackupKey)
+		if err == nil || resp != nil && !resp.IsError() {
+			t.Errorf("%s: expected to get an error from restore. got:%v\n", k.Algorithm, resp)
+		}
+	}
+
+	if diff := deep.Equal(0, len(b.keys)); diff != nil {
+		t.Error(diff)
+	}
+}
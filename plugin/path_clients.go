@@ -0,0 +1,529 @@
+package jwtsecrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	keyClientID      = "client_id"
+	keyTokenEndpoint = "token_endpoint"
+	keyAssertionTTL  = "assertion_ttl"
+	keyScope         = "scope"
+
+	keyClientAssertion     = "client_assertion"
+	keyClientAssertionType = "client_assertion_type"
+
+	clientsPrefix = "clients/"
+
+	// clientAssertionTypeJWT is the client assertion type of a JWT used for client authentication, from RFC 7523.
+	clientAssertionTypeJWT = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+	// DefaultAssertionTTL is how long client assertions are valid for if a client does not set its own TTL.
+	// Assertions are used immediately, so they are kept short lived.
+	DefaultAssertionTTL = time.Minute
+
+	// MaxAssertionTTL is the longest a client's assertions can be valid for, so a leaked assertion cannot be
+	// replayed for long.
+	MaxAssertionTTL = 5 * time.Minute
+
+	// tokenEndpointTimeout limits how long a request to a token endpoint can take.
+	tokenEndpointTimeout = 10 * time.Second
+
+	// tokenEndpointMaxSize is the largest response, in bytes, which is read from a token endpoint.
+	tokenEndpointMaxSize = 1 << 20
+)
+
+// oauthClient is an OAuth client registered with a third-party authorization server, which authenticates with
+// assertions signed by this backend.
+type oauthClient struct {
+	// ClientID is the client's ID at the authorization server, used as the 'iss' and 'sub' claims of its assertions.
+	ClientID string `json:"client_id"`
+
+	// TokenEndpoint is the authorization server's token endpoint, which assertions are posted to.
+	TokenEndpoint string `json:"token_endpoint"`
+
+	// Audience is the 'aud' claim of the client's assertions. If empty, the token endpoint is used.
+	Audience string `json:"audience"`
+
+	// AssertionTTL is how long the client's assertions are valid for.
+	AssertionTTL time.Duration `json:"assertion_ttl"`
+
+	// Scope is the scope requested from the token endpoint if no scope is given when requesting a token.
+	Scope string `json:"scope"`
+}
+
+// audience returns the 'aud' claim of the client's assertions.
+func (o *oauthClient) audience() string {
+	if o.Audience != "" {
+		return o.Audience
+	}
+	return o.TokenEndpoint
+}
+
+// tokenEndpointResponse is a successful response from a token endpoint, from RFC 6749.
+type tokenEndpointResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+// tokenEndpointError is an error response from a token endpoint, from RFC 6749.
+type tokenEndpointError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func pathClients(b *backend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "clients/?$",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathClientsList,
+				},
+			},
+
+			HelpSynopsis:    pathClientsListHelpSyn,
+			HelpDescription: pathClientsListHelpDesc,
+		},
+		{
+			Pattern: "clients/" + framework.GenericNameRegex(keyName),
+			Fields: map[string]*framework.FieldSchema{
+				keyName: {
+					Type:        framework.TypeString,
+					Description: `Name of the OAuth client.`,
+				},
+				keyClientID: {
+					Type:        framework.TypeString,
+					Description: `ID of the client at the authorization server.`,
+				},
+				keyTokenEndpoint: {
+					Type:        framework.TypeString,
+					Description: `URL of the authorization server's token endpoint.`,
+				},
+				keyAudience: {
+					Type:        framework.TypeString,
+					Description: `The 'aud' claim of the client's assertions. Defaults to the token endpoint.`,
+				},
+				keyAssertionTTL: {
+					Type:        framework.TypeString,
+					Description: `Duration the client's assertions are valid for.`,
+				},
+				keyScope: {
+					Type:        framework.TypeString,
+					Description: `Scope requested from the token endpoint if none is given when requesting a token.`,
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathClientWrite,
+				},
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathClientRead,
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.pathClientDelete,
+				},
+			},
+
+			HelpSynopsis:    pathClientHelpSyn,
+			HelpDescription: pathClientHelpDesc,
+		},
+		{
+			Pattern: "clients/" + framework.GenericNameRegex(keyName) + "/assertion",
+			Fields: map[string]*framework.FieldSchema{
+				keyName: {
+					Type:        framework.TypeString,
+					Description: `Name of the OAuth client.`,
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathClientAssertionWrite,
+				},
+			},
+
+			HelpSynopsis:    pathClientAssertionHelpSyn,
+			HelpDescription: pathClientAssertionHelpDesc,
+		},
+		{
+			Pattern: "clients/" + framework.GenericNameRegex(keyName) + "/token",
+			Fields: map[string]*framework.FieldSchema{
+				keyName: {
+					Type:        framework.TypeString,
+					Description: `Name of the OAuth client.`,
+				},
+				keyScope: {
+					Type:        framework.TypeString,
+					Description: `Scope to request. Defaults to the client's scope.`,
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathClientTokenWrite,
+				},
+			},
+
+			HelpSynopsis:    pathClientTokenHelpSyn,
+			HelpDescription: pathClientTokenHelpDesc,
+		},
+	}
+}
+
+func (b *backend) pathClientsList(c context.Context, r *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	names, err := r.Storage.List(c, clientsPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(names), nil
+}
+
+func (b *backend) pathClientWrite(c context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get(keyName).(string)
+
+	client, err := b.getOAuthClient(c, r.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		client = &oauthClient{AssertionTTL: DefaultAssertionTTL}
+	}
+
+	if newClientID, ok := d.GetOk(keyClientID); ok {
+		client.ClientID = newClientID.(string)
+	}
+
+	if newTokenEndpoint, ok := d.GetOk(keyTokenEndpoint); ok {
+		client.TokenEndpoint = newTokenEndpoint.(string)
+	}
+
+	if newAudience, ok := d.GetOk(keyAudience); ok {
+		client.Audience = newAudience.(string)
+	}
+
+	if newAssertionTTL, ok := d.GetOk(keyAssertionTTL); ok {
+		duration, err := time.ParseDuration(newAssertionTTL.(string))
+		if err != nil {
+			return logical.ErrorResponse("invalid '%s': %v", keyAssertionTTL, err), logical.ErrInvalidRequest
+		}
+		if duration <= 0 {
+			return logical.ErrorResponse("'%s' must be positive", keyAssertionTTL), logical.ErrInvalidRequest
+		}
+		if duration > MaxAssertionTTL {
+			return logical.ErrorResponse("'%s' cannot be longer than %s", keyAssertionTTL, MaxAssertionTTL), logical.ErrInvalidRequest
+		}
+		client.AssertionTTL = duration
+	}
+
+	if newScope, ok := d.GetOk(keyScope); ok {
+		client.Scope = newScope.(string)
+	}
+
+	if client.ClientID == "" {
+		return logical.ErrorResponse("'%s' must be set", keyClientID), logical.ErrInvalidRequest
+	}
+
+	if client.TokenEndpoint == "" {
+		return logical.ErrorResponse("'%s' must be set", keyTokenEndpoint), logical.ErrInvalidRequest
+	}
+
+	b.configLock.RLock()
+	signingAlgorithm := b.config.SigningAlgorithm
+	b.configLock.RUnlock()
+
+	if err := checkAssertionAlgorithm(signingAlgorithm); err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	if endpoint, err := url.Parse(client.TokenEndpoint); err != nil || !endpoint.IsAbs() {
+		return logical.ErrorResponse("'%s' must be an absolute URL", keyTokenEndpoint), logical.ErrInvalidRequest
+	}
+
+	entry, err := logical.StorageEntryJSON(clientsPrefix+name, client)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = r.Storage.Put(c, entry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *backend) pathClientRead(c context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	client, err := b.getOAuthClient(c, r.Storage, d.Get(keyName).(string))
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			keyClientID:      client.ClientID,
+			keyTokenEndpoint: client.TokenEndpoint,
+			keyAudience:      client.Audience,
+			keyAssertionTTL:  client.AssertionTTL.String(),
+			keyScope:         client.Scope,
+		},
+	}, nil
+}
+
+func (b *backend) pathClientDelete(c context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	if err := r.Storage.Delete(c, clientsPrefix+d.Get(keyName).(string)); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *backend) pathClientAssertionWrite(c context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get(keyName).(string)
+
+	client, err := b.getOAuthClient(c, r.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return logical.ErrorResponse("unknown client %s", name), logical.ErrInvalidRequest
+	}
+
+	assertion, err := b.signClientAssertion(client)
+	if err != nil {
+		return logical.ErrorResponse("error signing client assertion: %v", err), err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			keyClientAssertion:     assertion,
+			keyClientAssertionType: clientAssertionTypeJWT,
+			"expires_in":           int64(client.AssertionTTL.Seconds()),
+		},
+	}, nil
+}
+
+func (b *backend) pathClientTokenWrite(c context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get(keyName).(string)
+
+	client, err := b.getOAuthClient(c, r.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return logical.ErrorResponse("unknown client %s", name), logical.ErrInvalidRequest
+	}
+
+	assertion, err := b.signClientAssertion(client)
+	if err != nil {
+		return logical.ErrorResponse("error signing client assertion: %v", err), err
+	}
+
+	scope := client.Scope
+	if newScope, ok := d.GetOk(keyScope); ok {
+		scope = newScope.(string)
+	}
+
+	token, err := requestClientCredentialsToken(client, assertion, scope)
+	if err != nil {
+		return logical.ErrorResponse("error requesting token from %s: %v", client.TokenEndpoint, err), err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"access_token": token.AccessToken,
+			"token_type":   token.TokenType,
+			"expires_in":   token.ExpiresIn,
+			keyScope:       token.Scope,
+		},
+	}, nil
+}
+
+// getOAuthClient returns the OAuth client with the given name, or nil if there is no such client.
+func (b *backend) getOAuthClient(c context.Context, s logical.Storage, name string) (*oauthClient, error) {
+	entry, err := s.Get(c, clientsPrefix+name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var client oauthClient
+	if err = entry.DecodeJSON(&client); err != nil {
+		return nil, err
+	}
+
+	return &client, nil
+}
+
+// checkAssertionAlgorithm returns an error unless the algorithm signs JWTs with a key published in the JSON Web Key
+// Set, which the authorization server needs to verify client assertions.
+func checkAssertionAlgorithm(algorithm jose.SignatureAlgorithm) error {
+	if _, ok := hmacSecretSizes[algorithm]; ok {
+		return fmt.Errorf("client assertions cannot be signed with %s, as the key cannot be published", algorithm)
+	}
+	if !isJWSAlgorithm(algorithm) {
+		return fmt.Errorf("client assertions cannot be signed with %s, as it does not sign JWTs", algorithm)
+	}
+	return nil
+}
+
+// signClientAssertion signs a JWT which authenticates the client to its authorization server, following RFC 7523.
+func (b *backend) signClientAssertion(client *oauthClient) (string, error) {
+	b.configLock.RLock()
+	config := *b.config
+	b.configLock.RUnlock()
+
+	if err := checkAssertionAlgorithm(config.SigningAlgorithm); err != nil {
+		return "", err
+	}
+
+	jti, err := b.uuidGen.uuid()
+	if err != nil {
+		return "", err
+	}
+
+	now := b.clock.now()
+	expiry := now.Add(client.AssertionTTL)
+
+	claims := map[string]interface{}{
+		"iss": client.ClientID,
+		"sub": client.ClientID,
+		"aud": client.audience(),
+		"exp": jwt.NumericDate(expiry.Unix()),
+		"iat": jwt.NumericDate(now.Unix()),
+		"jti": jti,
+	}
+
+	key, err := b.getKey(config.SigningAlgorithm, expiry)
+	if err != nil {
+		return "", err
+	}
+
+	return signJWT(key, &config, claims, nil)
+}
+
+// requestClientCredentialsToken requests an access token for a client with the client credentials grant,
+// authenticating with a client assertion.
+func requestClientCredentialsToken(client *oauthClient, assertion, scope string) (*tokenEndpointResponse, error) {
+	form := url.Values{
+		"grant_type":           {"client_credentials"},
+		keyClientAssertionType: {clientAssertionTypeJWT},
+		keyClientAssertion:     {assertion},
+	}
+	if scope != "" {
+		form.Set(keyScope, scope)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, client.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	httpClient := cleanhttp.DefaultClient()
+	httpClient.Timeout = tokenEndpointTimeout
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, tokenEndpointMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > tokenEndpointMaxSize {
+		return nil, fmt.Errorf("response is larger than %d bytes", tokenEndpointMaxSize)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var tokenErr tokenEndpointError
+		if json.Unmarshal(body, &tokenErr) == nil && tokenErr.Error != "" {
+			if tokenErr.Description != "" {
+				return nil, fmt.Errorf("%s: %s", tokenErr.Error, tokenErr.Description)
+			}
+			return nil, fmt.Errorf("%s", tokenErr.Error)
+		}
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var token tokenEndpointResponse
+	if err = json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("error decoding response: %v", err)
+	}
+
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("no access token in response")
+	}
+
+	return &token, nil
+}
+
+const pathClientsListHelpSyn = `
+List OAuth clients.
+`
+
+const pathClientsListHelpDesc = `
+List the OAuth clients which authenticate to third-party authorization servers with assertions signed by this backend.
+`
+
+const pathClientHelpSyn = `
+Manage an OAuth client.
+`
+
+const pathClientHelpDesc = `
+Configure an OAuth client registered with a third-party authorization server for 'private_key_jwt'
+authentication. The authorization server verifies the client's assertions with this backend's JSON Web Key Set,
+so the 'signing_algorithm' must be a JWS algorithm other than HMAC.
+
+client_id:      ID of the client at the authorization server, set as the 'iss' and 'sub' claims of its assertions.
+token_endpoint: URL of the authorization server's token endpoint.
+audience:       The 'aud' claim of the client's assertions. Defaults to the token endpoint.
+assertion_ttl:  Duration the client's assertions are valid for. Defaults to one minute, and cannot be longer
+                than five minutes.
+scope:          Scope requested from the token endpoint if none is given when requesting a token.
+`
+
+const pathClientAssertionHelpSyn = `
+Sign a client assertion.
+`
+
+const pathClientAssertionHelpDesc = `
+Sign a JWT which authenticates the client to its authorization server, following RFC 7523. Each assertion
+has a unique 'jti' claim, and is returned with the 'client_assertion_type' to send alongside it.
+`
+
+const pathClientTokenHelpSyn = `
+Request an access token for an OAuth client.
+`
+
+const pathClientTokenHelpDesc = `
+Request an access token from the client's token endpoint with the client credentials grant, authenticating
+with a newly signed client assertion. Requests to the token endpoint time out after ten seconds.
+
+scope: Scope to request. Defaults to the client's scope.
+`
@@ -0,0 +1,310 @@
+package jwtsecrets
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func writeClient(t *testing.T, b *backend, storage *logical.Storage, name string, data map[string]interface{}) {
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "clients/" + name,
+		Storage:   *storage,
+		Data:      data,
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func requestClientToken(b *backend, storage *logical.Storage, name string, data map[string]interface{}) (*logical.Response, error) {
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "clients/" + name + "/token",
+		Storage:   *storage,
+		Data:      data,
+	}
+
+	return b.HandleRequest(context.Background(), req)
+}
+
+func checkClientAssertion(t *testing.T, b *backend, assertion string, audience string) {
+	token, err := jwt.ParseSigned(assertion)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	var claims map[string]interface{}
+	if err := token.Claims(b.keys[0].Key.Public(), &claims); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	expectedClaims := map[string]interface{}{
+		"iss": "planet-express",
+		"sub": "planet-express",
+		"aud": audience,
+		"exp": float64(60),
+		"iat": float64(0),
+		"jti": "1",
+	}
+
+	if diff := deep.Equal(expectedClaims, claims); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestClients(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	writeClient(t, b, storage, "momcorp", map[string]interface{}{
+		"client_id":      "planet-express",
+		"token_endpoint": "https://auth.momcorp.example.com/token",
+	})
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "clients/momcorp",
+		Storage:   *storage,
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	expected := map[string]interface{}{
+		"client_id":      "planet-express",
+		"token_endpoint": "https://auth.momcorp.example.com/token",
+		"audience":       "",
+		"assertion_ttl":  "1m0s",
+		"scope":          "",
+	}
+
+	if diff := deep.Equal(expected, resp.Data); diff != nil {
+		t.Error(diff)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "clients/",
+		Storage:   *storage,
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	if diff := deep.Equal([]string{"momcorp"}, resp.Data["keys"]); diff != nil {
+		t.Error(diff)
+	}
+
+	req = &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "clients/momcorp",
+		Storage:   *storage,
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "clients/momcorp",
+		Storage:   *storage,
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || resp != nil {
+		t.Errorf("expected the client to be deleted, got err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestWriteInvalidClient(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	tests := []map[string]interface{}{
+		{"token_endpoint": "https://auth.momcorp.example.com/token"},
+		{"client_id": "planet-express"},
+		{"client_id": "planet-express", "token_endpoint": "/token"},
+		{"client_id": "planet-express", "token_endpoint": "https://auth.momcorp.example.com/token", "assertion_ttl": "-1m"},
+		{"client_id": "planet-express", "token_endpoint": "https://auth.momcorp.example.com/token", "assertion_ttl": "1h"},
+	}
+
+	for _, data := range tests {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "clients/momcorp",
+			Storage:   *storage,
+			Data:      data,
+		}
+
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err == nil || resp != nil && !resp.IsError() {
+			t.Errorf("expected an error writing %#v, got %#v", data, resp)
+		}
+	}
+}
+
+func TestClientSigningAlgorithm(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	writeClient(t, b, storage, "momcorp", map[string]interface{}{
+		"client_id":      "planet-express",
+		"token_endpoint": "https://auth.momcorp.example.com/token",
+	})
+
+	if resp, err := writeConfig(b, storage, map[string]interface{}{keySigningAlgorithm: "HS256"}); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "clients/momcorp/assertion",
+		Storage:   *storage,
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err == nil || resp != nil && !resp.IsError() {
+		t.Errorf("expected an error signing an assertion, got %#v", resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "clients/momcorp",
+		Storage:   *storage,
+		Data:      map[string]interface{}{"scope": "deliveries"},
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err == nil || resp != nil && !resp.IsError() {
+		t.Errorf("expected an error writing the client, got %#v", resp)
+	}
+}
+
+func TestClientAssertion(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	writeClient(t, b, storage, "momcorp", map[string]interface{}{
+		"client_id":      "planet-express",
+		"token_endpoint": "https://auth.momcorp.example.com/token",
+		"audience":       "https://auth.momcorp.example.com",
+	})
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "clients/momcorp/assertion",
+		Storage:   *storage,
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	if diff := deep.Equal("urn:ietf:params:oauth:client-assertion-type:jwt-bearer", resp.Data["client_assertion_type"]); diff != nil {
+		t.Error(diff)
+	}
+
+	checkClientAssertion(t, b, resp.Data["client_assertion"].(string), "https://auth.momcorp.example.com")
+}
+
+func TestClientToken(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("%v\n", err)
+			return
+		}
+
+		if diff := deep.Equal("client_credentials", r.PostForm.Get("grant_type")); diff != nil {
+			t.Error(diff)
+		}
+
+		if diff := deep.Equal("urn:ietf:params:oauth:client-assertion-type:jwt-bearer", r.PostForm.Get("client_assertion_type")); diff != nil {
+			t.Error(diff)
+		}
+
+		if diff := deep.Equal("delivery", r.PostForm.Get("scope")); diff != nil {
+			t.Error(diff)
+		}
+
+		checkClientAssertion(t, b, r.PostForm.Get("client_assertion"), "http://"+r.Host+"/token")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "Slurm",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"scope":        "delivery",
+		})
+	}))
+	defer server.Close()
+
+	writeClient(t, b, storage, "momcorp", map[string]interface{}{
+		"client_id":      "planet-express",
+		"token_endpoint": server.URL + "/token",
+		"scope":          "delivery",
+	})
+
+	resp, err := requestClientToken(b, storage, "momcorp", nil)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	expected := map[string]interface{}{
+		"access_token": "Slurm",
+		"token_type":   "Bearer",
+		"expires_in":   int64(3600),
+		"scope":        "delivery",
+	}
+
+	if diff := deep.Equal(expected, resp.Data); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestClientTokenError(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":             "invalid_client",
+			"error_description": "unknown key",
+		})
+	}))
+	defer server.Close()
+
+	writeClient(t, b, storage, "momcorp", map[string]interface{}{
+		"client_id":      "planet-express",
+		"token_endpoint": server.URL + "/token",
+	})
+
+	resp, err := requestClientToken(b, storage, "momcorp", nil)
+	if err == nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error, got err:%s resp:%#v\n", err, resp)
+	}
+
+	if diff := deep.Equal("error requesting token from "+server.URL+"/token: invalid_client: unknown key", resp.Data["error"]); diff != nil {
+		t.Error(diff)
+	}
+
+	resp, err = requestClientToken(b, storage, "unknown", nil)
+	if err == nil || resp != nil && !resp.IsError() {
+		t.Errorf("expected an error for an unknown client, got %#v", resp)
+	}
+}
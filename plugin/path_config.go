@@ -2,11 +2,17 @@ package jwtsecrets
 
 import (
 	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/certutil"
 	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2"
 )
 
 const (
@@ -20,6 +26,51 @@ const (
 	keySubjectPattern      = "subject_pattern"
 	keyMaxAllowedAudiences = "max_audiences"
 	keyAllowedClaims       = "allowed_claims"
+	keyExportable          = "exportable"
+	keyKeyProvider         = "key_provider"
+	keyTransitAddress      = "transit_address"
+	keyTransitToken        = "transit_token"
+	keyTransitMount        = "transit_mount"
+	keyTransitKey          = "transit_key"
+	keyPKCS11Module        = "pkcs11_module"
+	keyPKCS11Slot          = "pkcs11_slot"
+	keyPKCS11PIN           = "pkcs11_pin"
+	keyTransitTokenSet     = "transit_token_set"
+	keyPKCS11PINSet        = "pkcs11_pin_set"
+	keyIssueCertificates   = "issue_certificates"
+	keySetX5TS256          = "set_x5t_s256"
+	keySetX5T              = "set_x5t"
+	keyHeaders             = "headers"
+	keyAllowedHeaders      = "allowed_headers"
+	keyCAPEMBundle         = "ca_pem_bundle"
+	keyCACertificate       = "ca_certificate"
+	keySigningAlgorithm    = "signing_algorithm"
+	keyLeaseTokens         = "lease_tokens"
+	keyRefreshTokens       = "refresh_tokens"
+	keyRefreshTokenTTL     = "refresh_token_ttl"
+	keyRefreshMaxTTL       = "refresh_max_ttl"
+
+	keyBindClientCertificates      = "bind_client_certificates"
+	keyAllowCertificateThumbprints = "allow_certificate_thumbprints"
+	keyProfile                     = "profile"
+	keySPIFFETrustDomain           = "spiffe_trust_domain"
+	keyCWTSigningAlgorithm         = "cwt_signing_algorithm"
+	keyCredentialIssuer            = "credential_issuer"
+	keyCredentialSchema            = "credential_schema"
+
+	keyAccessTokenScopes               = "access_token_scopes"
+	keyKubernetesNamespacePattern      = "kubernetes_namespace_pattern"
+	keyKubernetesServiceAccountPattern = "kubernetes_service_account_pattern"
+
+	keyDeriveIssuer          = "derive_issuer"
+	keyAPIAddr               = "api_addr"
+	keyNamespace             = "namespace"
+	keyDefaultAudienceSource = "default_audience_source"
+	keyIdentityToken         = "identity_token"
+	keySignPolicies          = "sign_policies"
+
+	keyAllowedClaimPatterns = "allowed_claim_patterns"
+	keyDeniedClaimValues    = "denied_claim_values"
 )
 
 func pathConfig(b *backend) *framework.Path {
@@ -50,6 +101,22 @@ func pathConfig(b *backend) *framework.Path {
 				Type:        framework.TypeString,
 				Description: `Value to set as the 'iss' claim. Claim is omitted if empty.`,
 			},
+			keyDeriveIssuer: {
+				Type:        framework.TypeBool,
+				Description: `Whether or not the 'iss' claim should be derived from 'api_addr', 'namespace' and the mount path.`,
+			},
+			keyAPIAddr: {
+				Type:        framework.TypeString,
+				Description: `Address Vault's API is served at, used to derive the 'iss' claim.`,
+			},
+			keyNamespace: {
+				Type:        framework.TypeString,
+				Description: `Vault namespace the backend is mounted in, used to derive the 'iss' claim and look up groups.`,
+			},
+			keyDefaultAudienceSource: {
+				Type:        framework.TypeString,
+				Description: `Source of the 'aud' claim when it is not set by the caller, as 'metadata:<key>'.`,
+			},
 			keyAudiencePattern: {
 				Type:        framework.TypeString,
 				Description: `Regular expression which must match incoming 'aud' claims.`,
@@ -67,6 +134,147 @@ func pathConfig(b *backend) *framework.Path {
 				Description: `Claims which are able to be set in addition to ones generated by the backend.
 Note: 'aud' and 'sub' should be in this list if you would like to set them.`,
 			},
+			keyAllowedClaimPatterns: {
+				Type:        framework.TypeStringSlice,
+				Description: `Regular expressions matching further claims which are able to be set.`,
+			},
+			keyDeniedClaimValues: {
+				Type:        framework.TypeMap,
+				Description: `Values which claims can never have, as a map of claims to lists of values.`,
+			},
+			keyClaimMappings: {
+				Type:        framework.TypeKVPairs,
+				Description: `Claims mapped from the caller's identity, which cannot be set by the caller.`,
+			},
+			keySignPolicies: {
+				Type:        framework.TypeSlice,
+				Description: `CEL expressions which must be true for claims to be signed, each with an 'expression' and a 'message'.`,
+			},
+			keyIdentityToken: {
+				Type:        framework.TypeString,
+				Description: `Token used to read the caller's groups and token metadata. Never returned when reading the config.`,
+			},
+			keyExportable: {
+				Type:        framework.TypeBool,
+				Description: `Whether or not the keys and config can be exported with the backup endpoint. Cannot be unset once set.`,
+			},
+			keyKeyProvider: {
+				Type:        framework.TypeString,
+				Description: `Where signing keys are created and held, either 'local', 'transit' or 'pkcs11'.`,
+			},
+			keyTransitAddress: {
+				Type:        framework.TypeString,
+				Description: `Address of the Vault server hosting the Transit key. Defaults to VAULT_ADDR.`,
+			},
+			keyTransitToken: {
+				Type:        framework.TypeString,
+				Description: `Token used to authenticate to the Transit secrets engine. Never returned when reading the config.`,
+			},
+			keyTransitMount: {
+				Type:        framework.TypeString,
+				Description: `Path the Transit secrets engine is mounted at.`,
+			},
+			keyTransitKey: {
+				Type:        framework.TypeString,
+				Description: `Name of an RSA Transit key used to sign tokens. It is rotated for every new signing key.`,
+			},
+			keyPKCS11Module: {
+				Type:        framework.TypeString,
+				Description: `Path to the PKCS#11 module used to access the token.`,
+			},
+			keyPKCS11Slot: {
+				Type:        framework.TypeInt,
+				Description: `ID of the slot containing the token signing keys are created on.`,
+			},
+			keyPKCS11PIN: {
+				Type:        framework.TypeString,
+				Description: `User PIN used to log in to the token. Never returned when reading the config.`,
+			},
+			keyIssueCertificates: {
+				Type:        framework.TypeBool,
+				Description: `Whether or not each new signing key should be issued an X.509 certificate.`,
+			},
+			keySetX5TS256: {
+				Type:        framework.TypeBool,
+				Description: `Whether or not the backend should set the 'x5t#S256' header on tokens signed by a key with a certificate.`,
+			},
+			keySetX5T: {
+				Type:        framework.TypeBool,
+				Description: `Whether or not the backend should set the 'x5t' header on tokens signed by a key with a certificate.`,
+			},
+			keyHeaders: {
+				Type:        framework.TypeKVPairs,
+				Description: `Headers to set on every JWT, such as 'typ', 'cty' or 'jku'.`,
+			},
+			keyAllowedHeaders: {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `Headers which are able to be set by callers.`,
+			},
+			keySigningAlgorithm: {
+				Type:        framework.TypeString,
+				Description: `Algorithm used to sign tokens, one of 'RS256', 'HS256', 'HS384' or 'HS512'.`,
+			},
+			keyLeaseTokens: {
+				Type:        framework.TypeBool,
+				Description: `Whether or not tokens should be returned with a lease, which adds the token to the revocation list when revoked.`,
+			},
+			keyRefreshTokens: {
+				Type:        framework.TypeBool,
+				Description: `Whether or not a one-time use refresh token should be returned alongside each token.`,
+			},
+			keyRefreshTokenTTL: {
+				Type:        framework.TypeString,
+				Description: `Duration a refresh token is valid for.`,
+			},
+			keyRefreshMaxTTL: {
+				Type:        framework.TypeString,
+				Description: `Duration refresh tokens descended from the same signed token are valid for.`,
+			},
+			keyBindClientCertificates: {
+				Type:        framework.TypeBool,
+				Description: `Whether or not tokens signed for callers using a client certificate should be bound to the certificate.`,
+			},
+			keyAllowCertificateThumbprints: {
+				Type:        framework.TypeBool,
+				Description: `Whether or not callers can bind tokens to a client certificate by passing its thumbprint.`,
+			},
+			keyProfile: {
+				Type:        framework.TypeString,
+				Description: `Profile restricting the tokens which can be signed. Either blank, 'spiffe' to only sign JWT-SVIDs, 'kubernetes' to only sign service account tokens, or 'at+jwt' to only sign OAuth access tokens.`,
+			},
+			keySPIFFETrustDomain: {
+				Type:        framework.TypeString,
+				Description: `Trust domain of the SPIFFE IDs in JWT-SVIDs signed under the 'spiffe' profile.`,
+			},
+			keyAccessTokenScopes: {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `Scopes which can be granted in access tokens signed under the 'at+jwt' profile.`,
+			},
+			keyKubernetesNamespacePattern: {
+				Type:        framework.TypeString,
+				Description: `Regular expression which must match the namespace of service account tokens signed under the 'kubernetes' profile.`,
+			},
+			keyKubernetesServiceAccountPattern: {
+				Type:        framework.TypeString,
+				Description: `Regular expression which must match the name of service accounts in tokens signed under the 'kubernetes' profile.`,
+			},
+			keyCWTSigningAlgorithm: {
+				Type:        framework.TypeString,
+				Description: `Algorithm used to sign CBOR Web Tokens, either 'ES256' or 'EdDSA'.`,
+			},
+			keyCredentialIssuer: {
+				Type:        framework.TypeString,
+				Description: `did:web DID which issues Verifiable Credentials.`,
+			},
+			keyCredentialSchema: {
+				Type:        framework.TypeMap,
+				Description: `JSON Schema which the 'vc' claim of Verifiable Credentials must match.`,
+			},
+			keyCAPEMBundle: {
+				Type: framework.TypeString,
+				Description: `PEM bundle containing the CA certificate and private key used to issue signing key certificates.
+If not set, a self-signed CA is generated. Never returned when reading the config.`,
+			},
 		},
 
 		Operations: map[logical.Operation]framework.OperationHandler{
@@ -84,67 +292,392 @@ Note: 'aud' and 'sub' should be in this list if you would like to set them.`,
 }
 
 func (b *backend) pathConfigWrite(c context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	b.keysLock.Lock()
+	defer b.keysLock.Unlock()
+
 	b.configLock.Lock()
 	defer b.configLock.Unlock()
 
+	// Changes are made to a copy, so an invalid update leaves the config untouched.
+	config := *b.config
+	if err := updateConfig(&config, d); err != nil {
+		return nil, err
+	}
+	b.setConfig(&config)
+
+	if b.config.IssueCertificates && b.config.caBundle == nil {
+		bundle, err := generateSelfSignedCA(b.config.Issuer, b.clock.now())
+		if err != nil {
+			return nil, err
+		}
+		b.config.caBundle = bundle
+	}
+
+	return nonLockingRead(b)
+}
+
+// setConfig replaces the config. If the key provider was replaced, the old provider is closed and the keys it created
+// are retired as if they had been rotated: they are no longer used to sign, but are published until the tokens they
+// signed expire. Both keysLock and configLock must be held.
+func (b *backend) setConfig(config *Config) {
+	oldProvider := b.config.keyProvider
+	b.config = config
+
+	if config.keyProvider == oldProvider {
+		return
+	}
+
+	now := b.clock.now()
+	for _, k := range b.keys {
+		if k.Secret == nil && isJWSAlgorithm(k.Algorithm) && k.UseUntil.After(now) {
+			k.UseUntil = now
+		}
+	}
+
+	if err := oldProvider.close(); err != nil {
+		b.Logger().Warn("error closing replaced key provider", "error", err)
+	}
+}
+
+// updateConfig applies the fields set in d to config.
+func updateConfig(config *Config, d *framework.FieldData) error {
 	if newRotationPeriod, ok := d.GetOk(keyRotationDuration); ok {
 		duration, err := time.ParseDuration(newRotationPeriod.(string))
 		if err != nil {
-			return nil, err
+			return err
 		}
-		b.config.KeyRotationPeriod = duration
+		config.KeyRotationPeriod = duration
 	}
 
 	if newTTL, ok := d.GetOk(keyTokenTTL); ok {
 		duration, err := time.ParseDuration(newTTL.(string))
 		if err != nil {
-			return nil, err
+			return err
 		}
-		b.config.TokenTTL = duration
+		config.TokenTTL = duration
 	}
 
 	if newSetIat, ok := d.GetOk(keySetIAT); ok {
-		b.config.SetIAT = newSetIat.(bool)
+		config.SetIAT = newSetIat.(bool)
 	}
 
 	if newSetJTI, ok := d.GetOk(keySetJTI); ok {
-		b.config.SetJTI = newSetJTI.(bool)
+		config.SetJTI = newSetJTI.(bool)
 	}
 
 	if newSetNBF, ok := d.GetOk(keySetNBF); ok {
-		b.config.SetNBF = newSetNBF.(bool)
+		config.SetNBF = newSetNBF.(bool)
 	}
 
 	if newIssuer, ok := d.GetOk(keyIssuer); ok {
-		b.config.Issuer = newIssuer.(string)
+		config.Issuer = newIssuer.(string)
+	}
+
+	if newDeriveIssuer, ok := d.GetOk(keyDeriveIssuer); ok {
+		config.DeriveIssuer = newDeriveIssuer.(bool)
+	}
+
+	if newAPIAddr, ok := d.GetOk(keyAPIAddr); ok {
+		addr := newAPIAddr.(string)
+		if addr != "" {
+			var err error
+			if addr, err = validateAPIAddr(addr); err != nil {
+				return fmt.Errorf("invalid API address: %v", err)
+			}
+		}
+		config.APIAddr = addr
+	}
+
+	if newNamespace, ok := d.GetOk(keyNamespace); ok {
+		config.Namespace = strings.Trim(newNamespace.(string), "/")
+	}
+
+	if config.DeriveIssuer && config.APIAddr == "" {
+		return errors.New("the API address must be set to derive the issuer")
+	}
+
+	if newAudienceSource, ok := d.GetOk(keyDefaultAudienceSource); ok {
+		if source := newAudienceSource.(string); source != "" {
+			if err := validateAudienceSource(source); err != nil {
+				return err
+			}
+		}
+		config.DefaultAudienceSource = newAudienceSource.(string)
 	}
 
 	if newAudiencePattern, ok := d.GetOk(keyAudiencePattern); ok {
 		pattern, err := regexp.Compile(newAudiencePattern.(string))
 		if err != nil {
-			return nil, err
+			return err
 		}
-		b.config.AudiencePattern = pattern
+		config.AudiencePattern = pattern
 	}
 
 	if newSubjectPattern, ok := d.GetOk(keySubjectPattern); ok {
 		pattern, err := regexp.Compile(newSubjectPattern.(string))
 		if err != nil {
-			return nil, err
+			return err
 		}
-		b.config.SubjectPattern = pattern
+		config.SubjectPattern = pattern
 	}
 
 	if newMaxAudiences, ok := d.GetOk(keyMaxAllowedAudiences); ok {
-		b.config.MaxAudiences = newMaxAudiences.(int)
+		config.MaxAudiences = newMaxAudiences.(int)
 	}
 
 	if newAllowedClaims, ok := d.GetOk(keyAllowedClaims); ok {
-		b.config.AllowedClaims = newAllowedClaims.([]string)
-		b.config.allowedClaimsMap = makeAllowedClaimsMap(newAllowedClaims.([]string))
+		config.AllowedClaims = newAllowedClaims.([]string)
+		config.allowedClaimsMap = makeAllowedClaimsMap(newAllowedClaims.([]string))
+		config.allowedClaimGlobs = makeAllowedClaimGlobs(newAllowedClaims.([]string))
 	}
 
-	return nonLockingRead(b)
+	if newAllowedClaimPatterns, ok := d.GetOk(keyAllowedClaimPatterns); ok {
+		patterns := make([]*regexp.Regexp, 0)
+		for _, rawPattern := range newAllowedClaimPatterns.([]string) {
+			pattern, err := regexp.Compile(rawPattern)
+			if err != nil {
+				return err
+			}
+			patterns = append(patterns, pattern)
+		}
+		config.AllowedClaimPatterns = patterns
+	}
+
+	if newDeniedClaimValues, ok := d.GetOk(keyDeniedClaimValues); ok {
+		denied := make(map[string][]string)
+		for claim, rawValues := range newDeniedClaimValues.(map[string]interface{}) {
+			switch values := rawValues.(type) {
+			case string:
+				denied[claim] = []string{values}
+			case []interface{}:
+				for _, value := range values {
+					value, ok := value.(string)
+					if !ok {
+						return fmt.Errorf("denied values of claim %s must be strings", claim)
+					}
+					denied[claim] = append(denied[claim], value)
+				}
+			case []string:
+				denied[claim] = values
+			default:
+				return fmt.Errorf("denied values of claim %s must be a string or list of strings", claim)
+			}
+		}
+		config.DeniedClaimValues = denied
+	}
+
+	if newClaimMappings, ok := d.GetOk(keyClaimMappings); ok {
+		mappings := newClaimMappings.(map[string]string)
+		if err := validateClaimMappings(mappings); err != nil {
+			return err
+		}
+		config.ClaimMappings = mappings
+	}
+
+	if newSignPolicies, ok := d.GetOk(keySignPolicies); ok {
+		policies, err := parseSignPolicies(newSignPolicies.([]interface{}))
+		if err != nil {
+			return err
+		}
+		config.SignPolicies = policies
+	}
+
+	if newIdentityToken, ok := d.GetOk(keyIdentityToken); ok {
+		config.IdentityToken = newIdentityToken.(string)
+	}
+
+	if newExportable, ok := d.GetOk(keyExportable); ok {
+		if config.Exportable && !newExportable.(bool) {
+			return errors.New("exportable cannot be unset once set")
+		}
+		config.Exportable = newExportable.(bool)
+	}
+
+	providerChanged := false
+	for field, value := range map[string]*string{
+		keyKeyProvider:    &config.KeyProvider,
+		keyTransitAddress: &config.TransitAddress,
+		keyTransitToken:   &config.TransitToken,
+		keyTransitMount:   &config.TransitMount,
+		keyTransitKey:     &config.TransitKey,
+		keyPKCS11Module:   &config.PKCS11Module,
+		keyPKCS11PIN:      &config.PKCS11PIN,
+	} {
+		if newValue, ok := d.GetOk(field); ok && newValue.(string) != *value {
+			*value = newValue.(string)
+			providerChanged = true
+		}
+	}
+
+	if newIssueCertificates, ok := d.GetOk(keyIssueCertificates); ok {
+		config.IssueCertificates = newIssueCertificates.(bool)
+	}
+
+	if newSetX5TS256, ok := d.GetOk(keySetX5TS256); ok {
+		config.SetX5TS256 = newSetX5TS256.(bool)
+	}
+
+	if newSetX5T, ok := d.GetOk(keySetX5T); ok {
+		config.SetX5T = newSetX5T.(bool)
+	}
+
+	if newHeaders, ok := d.GetOk(keyHeaders); ok {
+		headers := newHeaders.(map[string]string)
+		for _, header := range generatedHeaders {
+			if _, ok := headers[header]; ok {
+				return fmt.Errorf("header %s cannot be configured", header)
+			}
+		}
+		config.Headers = headers
+	}
+
+	if newAllowedHeaders, ok := d.GetOk(keyAllowedHeaders); ok {
+		config.AllowedHeaders = newAllowedHeaders.([]string)
+		config.allowedHeadersMap = makeAllowedHeadersMap(newAllowedHeaders.([]string))
+	}
+
+	if newSigningAlgorithm, ok := d.GetOk(keySigningAlgorithm); ok {
+		algorithm := jose.SignatureAlgorithm(newSigningAlgorithm.(string))
+		if _, ok := hmacSecretSizes[algorithm]; !ok && algorithm != jose.RS256 {
+			return fmt.Errorf("unsupported signing algorithm %s", algorithm)
+		}
+		config.SigningAlgorithm = algorithm
+	}
+
+	if newLeaseTokens, ok := d.GetOk(keyLeaseTokens); ok {
+		config.LeaseTokens = newLeaseTokens.(bool)
+	}
+
+	if newRefreshTokens, ok := d.GetOk(keyRefreshTokens); ok {
+		config.RefreshTokens = newRefreshTokens.(bool)
+	}
+
+	if newRefreshTokenTTL, ok := d.GetOk(keyRefreshTokenTTL); ok {
+		duration, err := time.ParseDuration(newRefreshTokenTTL.(string))
+		if err != nil {
+			return err
+		}
+		config.RefreshTokenTTL = duration
+	}
+
+	if newRefreshMaxTTL, ok := d.GetOk(keyRefreshMaxTTL); ok {
+		duration, err := time.ParseDuration(newRefreshMaxTTL.(string))
+		if err != nil {
+			return err
+		}
+		config.RefreshMaxTTL = duration
+	}
+
+	if newBindClientCertificates, ok := d.GetOk(keyBindClientCertificates); ok {
+		config.BindClientCertificates = newBindClientCertificates.(bool)
+	}
+
+	if newAllowCertificateThumbprints, ok := d.GetOk(keyAllowCertificateThumbprints); ok {
+		config.AllowCertificateThumbprints = newAllowCertificateThumbprints.(bool)
+	}
+
+	if config.BindClientCertificates && config.AllowCertificateThumbprints {
+		return errors.New("callers cannot supply certificate thumbprints when client certificates are bound")
+	}
+
+	if newProfile, ok := d.GetOk(keyProfile); ok {
+		switch profile := newProfile.(string); profile {
+		case "", profileSPIFFE, profileKubernetes, profileAccessToken:
+			config.Profile = profile
+		default:
+			return fmt.Errorf("unknown profile %s", profile)
+		}
+	}
+
+	if newSPIFFETrustDomain, ok := d.GetOk(keySPIFFETrustDomain); ok {
+		config.SPIFFETrustDomain = newSPIFFETrustDomain.(string)
+	}
+
+	if newAccessTokenScopes, ok := d.GetOk(keyAccessTokenScopes); ok {
+		config.AccessTokenScopes = newAccessTokenScopes.([]string)
+	}
+
+	if newNamespacePattern, ok := d.GetOk(keyKubernetesNamespacePattern); ok {
+		pattern, err := regexp.Compile(newNamespacePattern.(string))
+		if err != nil {
+			return err
+		}
+		config.KubernetesNamespacePattern = pattern
+	}
+
+	if newServiceAccountPattern, ok := d.GetOk(keyKubernetesServiceAccountPattern); ok {
+		pattern, err := regexp.Compile(newServiceAccountPattern.(string))
+		if err != nil {
+			return err
+		}
+		config.KubernetesServiceAccountPattern = pattern
+	}
+
+	if newCWTSigningAlgorithm, ok := d.GetOk(keyCWTSigningAlgorithm); ok {
+		algorithm := jose.SignatureAlgorithm(newCWTSigningAlgorithm.(string))
+		if _, ok := cwtAlgorithms[algorithm]; !ok {
+			return fmt.Errorf("unsupported CWT signing algorithm %s", algorithm)
+		}
+		config.CWTSigningAlgorithm = algorithm
+	}
+
+	if newCredentialIssuer, ok := d.GetOk(keyCredentialIssuer); ok {
+		if did := newCredentialIssuer.(string); did != "" {
+			if err := validateDIDWeb(did); err != nil {
+				return err
+			}
+		}
+		config.CredentialIssuer = newCredentialIssuer.(string)
+	}
+
+	if newCredentialSchema, ok := d.GetOk(keyCredentialSchema); ok {
+		schema := newCredentialSchema.(map[string]interface{})
+		if err := checkSchema(schema); err != nil {
+			return fmt.Errorf("invalid credential schema: %v", err)
+		}
+		config.CredentialSchema = schema
+	}
+
+	if newCAPEMBundle, ok := d.GetOk(keyCAPEMBundle); ok {
+		bundle, err := certutil.ParsePEMBundle(newCAPEMBundle.(string))
+		if err != nil {
+			return err
+		}
+		if bundle.PrivateKey == nil || bundle.Certificate == nil {
+			return errors.New("the CA bundle must contain a certificate and its private key")
+		}
+		config.caBundle = bundle
+	}
+
+	if newSlot, ok := d.GetOk(keyPKCS11Slot); ok && newSlot.(int) != config.PKCS11Slot {
+		config.PKCS11Slot = newSlot.(int)
+		providerChanged = true
+	}
+
+	switch config.Profile {
+	case profileSPIFFE:
+		if err := validateSPIFFEConfig(config); err != nil {
+			return err
+		}
+	case profileKubernetes:
+		if err := validateKubernetesConfig(config); err != nil {
+			return err
+		}
+	case profileAccessToken:
+		if err := validateAccessTokenConfig(config); err != nil {
+			return err
+		}
+	}
+
+	if providerChanged {
+		provider, err := newKeyProvider(config)
+		if err != nil {
+			return err
+		}
+		config.keyProvider = provider
+	}
+
+	return nil
 }
 
 func (b *backend) pathConfigRead(_ context.Context, _ *logical.Request, d *framework.FieldData) (*logical.Response, error) {
@@ -155,6 +688,19 @@ func (b *backend) pathConfigRead(_ context.Context, _ *logical.Request, d *frame
 }
 
 func nonLockingRead(b *backend) (*logical.Response, error) {
+	var caCertificate string
+	if b.config.caBundle != nil {
+		caCertificate = strings.TrimSpace(string(pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: b.config.caBundle.CertificateBytes,
+		})))
+	}
+
+	allowedClaimPatterns := make([]string, 0, len(b.config.AllowedClaimPatterns))
+	for _, pattern := range b.config.AllowedClaimPatterns {
+		allowedClaimPatterns = append(allowedClaimPatterns, pattern.String())
+	}
+
 	return &logical.Response{
 		Data: map[string]interface{}{
 			keyRotationDuration:    b.config.KeyRotationPeriod.String(),
@@ -163,10 +709,51 @@ func nonLockingRead(b *backend) (*logical.Response, error) {
 			keySetJTI:              b.config.SetJTI,
 			keySetNBF:              b.config.SetNBF,
 			keyIssuer:              b.config.Issuer,
+			keyDeriveIssuer:        b.config.DeriveIssuer,
+			keyAPIAddr:             b.config.APIAddr,
+			keyNamespace:           b.config.Namespace,
 			keyAudiencePattern:     b.config.AudiencePattern.String(),
 			keySubjectPattern:      b.config.SubjectPattern.String(),
 			keyMaxAllowedAudiences: b.config.MaxAudiences,
 			keyAllowedClaims:       b.config.AllowedClaims,
+			keyClaimMappings:       b.config.ClaimMappings,
+			keyDeniedClaimValues:   b.config.DeniedClaimValues,
+			keySignPolicies:        signPoliciesData(b.config.SignPolicies),
+			keyExportable:          b.config.Exportable,
+			keyKeyProvider:         b.config.KeyProvider,
+			keyTransitAddress:      b.config.TransitAddress,
+			keyTransitMount:        b.config.TransitMount,
+			keyTransitKey:          b.config.TransitKey,
+			keyPKCS11Module:        b.config.PKCS11Module,
+			keyPKCS11Slot:          b.config.PKCS11Slot,
+			keyTransitTokenSet:     b.config.TransitToken != "",
+			keyPKCS11PINSet:        b.config.PKCS11PIN != "",
+			keyIssueCertificates:   b.config.IssueCertificates,
+			keySetX5TS256:          b.config.SetX5TS256,
+			keySetX5T:              b.config.SetX5T,
+			keyHeaders:             b.config.Headers,
+			keyAllowedHeaders:      b.config.AllowedHeaders,
+			keyCACertificate:       caCertificate,
+			keySigningAlgorithm:    string(b.config.SigningAlgorithm),
+			keyLeaseTokens:         b.config.LeaseTokens,
+			keyRefreshTokens:       b.config.RefreshTokens,
+			keyRefreshTokenTTL:     b.config.RefreshTokenTTL.String(),
+			keyRefreshMaxTTL:       b.config.RefreshMaxTTL.String(),
+
+			keyBindClientCertificates:      b.config.BindClientCertificates,
+			keyAllowCertificateThumbprints: b.config.AllowCertificateThumbprints,
+			keyProfile:                     b.config.Profile,
+			keySPIFFETrustDomain:           b.config.SPIFFETrustDomain,
+			keyCWTSigningAlgorithm:         string(b.config.CWTSigningAlgorithm),
+			keyCredentialIssuer:            b.config.CredentialIssuer,
+			keyCredentialSchema:            b.config.CredentialSchema,
+
+			keyAccessTokenScopes:               b.config.AccessTokenScopes,
+			keyKubernetesNamespacePattern:      b.config.KubernetesNamespacePattern.String(),
+			keyKubernetesServiceAccountPattern: b.config.KubernetesServiceAccountPattern.String(),
+
+			keyDefaultAudienceSource: b.config.DefaultAudienceSource,
+			keyAllowedClaimPatterns:  allowedClaimPatterns,
 		},
 	}, nil
 }
@@ -178,16 +765,123 @@ Configure the backend.
 const pathConfigHelpDesc = `
 Configure the backend.
 
-key_ttl:          Duration before a key stops signing new tokens and a new one is generated.
-		          After this period the public key will still be available to verify JWTs.
-jwt_ttl:          Duration before a token expires.
-set_iat:          Whether or not the backend should generate and set the 'iat' claim.
-set_jti:          Whether or not the backend should generate and set the 'jti' claim.
-set_nbf:          Whether or not the backend should generate and set the 'nbf' claim.
-issuer:           Value to set as the 'iss' claim. Claim omitted if empty.
-audience_pattern: Regular expression which must match incoming 'aud' claims.
-subject_pattern:  Regular expression which must match incoming 'sub' claims.
-max_audiences:    Maximum number of allowed audiences, or -1 for no limit.
-allowed_claims:   Claims which are able to be set in addition to ones generated by the backend.
-                  Note: 'aud' and 'sub' should be in this list if you would like to set them.
+key_ttl:            Duration before a key stops signing new tokens and a new one is generated.
+                    After this period the public key will still be available to verify JWTs.
+jwt_ttl:            Duration before a token expires.
+set_iat:            Whether or not the backend should generate and set the 'iat' claim.
+set_jti:            Whether or not the backend should generate and set the 'jti' claim.
+set_nbf:            Whether or not the backend should generate and set the 'nbf' claim.
+issuer:             Value to set as the 'iss' claim. Claim omitted if empty.
+derive_issuer:      Whether or not the 'iss' claim should be derived for each request in place of 'issuer', as
+                    <api_addr>/v1/<namespace>/<mount path>, the URL OIDC discovery documents are found under.
+api_addr:           Address Vault's API is served at, as in Vault's 'api_addr' setting. Required to derive the issuer.
+namespace:          Vault namespace the backend is mounted in, or blank for the root namespace. Used to derive
+                    the issuer and to look up the caller's groups. Plugins are not told which namespace they are
+                    mounted in, so it must be configured.
+default_audience_source:
+                    Source of the 'aud' claim when it is not set by the caller, as 'metadata:<key>' for the key in
+                    the calling token's metadata. Plugins served out of process are not passed the calling token,
+                    so its metadata is looked up by its accessor with 'identity_token' instead. Signing fails
+                    if the value is not set. Default audiences must still match 'audience_pattern'.
+audience_pattern:   Regular expression which must match incoming 'aud' claims.
+subject_pattern:    Regular expression which must match incoming 'sub' claims.
+max_audiences:      Maximum number of allowed audiences, or -1 for no limit.
+allowed_claims:     Claims which are able to be set in addition to ones generated by the backend.
+                    Note: 'aud' and 'sub' should be in this list if you would like to set them.
+                    Claims containing '*' are globs, such as 'https://example.com/claims/*'.
+allowed_claim_patterns:
+                    Regular expressions matching further claims which are able to be set.
+denied_claim_values:
+                    Values which claims can never have, as a map of claims to lists of values. Values are
+                    compared as strings, and a list claim is denied if any of its elements are. Every claim is
+                    checked, including those mapped from the caller's identity and generated by the backend,
+                    once the claims set by the caller are allowed.
+claim_mappings:     Claims mapped from the caller's identity, as 'claim=source' pairs. The source is one of
+                    'entity.name', 'entity.metadata.<key>', 'entity.aliases.<mount accessor>.metadata.<key>',
+                    'entity.groups.names' or 'token.metadata.<key>'. Claims whose source is not set for the
+                    caller are omitted. Mapped claims can never be set by the caller, even if they are in
+                    'allowed_claims', and reserved claims such as 'iss' and 'exp' cannot be mapped.
+sign_policies:      CEL expressions which must all be true for a set of claims to be signed, as a list of objects
+                    with an 'expression' and a 'message' returned when the expression is false. Expressions can
+                    refer to 'claims', the caller's 'entity' with its 'id', 'name', 'metadata' and 'aliases', the
+                    names of the entity's 'groups', and the 'request' with its 'path', 'mount_point', 'namespace',
+                    'display_name', 'entity_id' and 'remote_addr'. Groups are looked up as for claim_mappings.
+                    Policies are evaluated in order after every other check, and fail if they cannot be evaluated.
+identity_token:     Token used to read the caller's groups from the identity secrets engine at 'api_addr',
+                    for the 'entity.groups.names' source and sign policies, and to look up the calling token's
+                    metadata with auth/token/lookup-accessor when the plugin is served out of process.
+                    Defaults to VAULT_TOKEN.
+exportable:         Whether or not the keys and config can be exported with the backup endpoint.
+                    Cannot be unset once set.
+key_provider:       Where signing keys are created and held, either 'local', 'transit' or 'pkcs11'.
+                    The provider is only replaced when its settings change. Keys created by the replaced
+                    provider are no longer used to sign, but are published until the tokens they signed expire.
+transit_address:    Address of the Vault server hosting the Transit key. Defaults to VAULT_ADDR.
+transit_token:      Token used to authenticate to the Transit secrets engine. Never returned when reading
+                    the config, which returns 'transit_token_set' instead.
+transit_mount:      Path the Transit secrets engine is mounted at.
+transit_key:        Name of the Transit key used to sign tokens, which must be an RSA key. The key is
+                    rotated each time a new signing key is needed, so it should be dedicated to this mount.
+pkcs11_module:      Path to the PKCS#11 module used to access the token.
+pkcs11_slot:        ID of the slot containing the token signing keys are created on.
+pkcs11_pin:         User PIN used to log in to the token. Never returned when reading the config, which
+                    returns 'pkcs11_pin_set' instead.
+issue_certificates: Whether or not each new signing key should be issued an X.509 certificate.
+                    Certificates are published in the 'x5c' member of each key in the JSON Web Key Set.
+set_x5t_s256:       Whether or not the backend should set the 'x5t#S256' header on tokens signed by a key with a certificate.
+set_x5t:            Whether or not the backend should set the 'x5t' header on tokens signed by a key with a certificate.
+headers:            Headers to set on every JWT signed at the sign endpoint, such as 'typ=at+jwt', 'cty' or a 'jku'
+                    pointing at this backend's JSON Web Key Set. The 'typ' header defaults to 'JWT'.
+                    Headers generated by the backend, such as 'alg', 'kid' and 'x5t', cannot be configured.
+allowed_headers:    Headers which are able to be set by callers in addition to the configured headers.
+                    Headers which identify the verification key, such as 'jku' and 'x5t', can never be set by callers.
+signing_algorithm:  Algorithm used to sign tokens, one of 'RS256', 'HS256', 'HS384' or 'HS512'.
+                    HMAC secrets are never published, and can only be read through the keys/:kid/secret endpoint.
+lease_tokens:       Whether or not tokens should be returned with a lease lasting as long as the token.
+                    Revoking the lease adds the token's 'jti' claim to the revocation list.
+refresh_tokens:     Whether or not a one-time use refresh token should be returned alongside each token.
+                    Reusing a refresh token revokes every refresh token descended from the same token.
+refresh_token_ttl:  Duration before a refresh token expires.
+refresh_max_ttl:    Duration before every refresh token descended from the same signed token expires,
+                    however many times it is refreshed. Defaults to 30 days.
+bind_client_certificates:
+                    Whether or not tokens signed for callers using a client certificate should be bound to the
+                    certificate by its SHA-256 thumbprint in the 'cnf' claim, following RFC 8705.
+                    Vault does not pass the TLS connection state to plugins served out of process, so tokens
+                    are only bound when the backend runs inside Vault. Otherwise, use
+                    'allow_certificate_thumbprints' behind a trusted proxy.
+allow_certificate_thumbprints:
+                    Whether or not callers can bind tokens to a client certificate by passing its SHA-256
+                    thumbprint as 'certificate_thumbprint'. Any caller able to sign can then bind tokens to any
+                    certificate, so only set this if signing is limited to trusted callers. Cannot be set along
+                    with 'bind_client_certificates'.
+profile:            Profile restricting the tokens which can be signed. If 'spiffe', tokens must be JWT-SVIDs:
+                    'sub' must be a SPIFFE ID in 'spiffe_trust_domain', 'aud' must be set, and HMAC
+                    signing algorithms cannot be used.
+                    If 'kubernetes', tokens must be shaped like Kubernetes service account tokens: 'sub' must
+                    be 'system:serviceaccount:<namespace>:<name>', 'aud' must be set, HMAC signing algorithms
+                    cannot be used, and the 'kubernetes.io' claim is set from the namespace and name.
+                    If 'at+jwt', tokens must be OAuth access tokens following RFC 9068: 'sub', 'aud' and
+                    'client_id' must be set, 'scope' may only grant 'access_token_scopes', 'auth_time', 'acr'
+                    and 'amr' can be set, and the 'typ' header is 'at+jwt'. The 'issuer' must be set, as must
+                    'set_iat' and 'set_jti'.
+spiffe_trust_domain:
+                    Trust domain of the SPIFFE IDs in JWT-SVIDs signed under the 'spiffe' profile.
+access_token_scopes:
+                    Scopes which can be granted in access tokens signed under the 'at+jwt' profile.
+kubernetes_namespace_pattern:
+                    Regular expression which must match the namespace of service account tokens signed
+                    under the 'kubernetes' profile. Replaces 'subject_pattern' under the profile.
+kubernetes_service_account_pattern:
+                    Regular expression which must match the name of service accounts in tokens signed
+                    under the 'kubernetes' profile. Replaces 'subject_pattern' under the profile.
+cwt_signing_algorithm:
+                    Algorithm used to sign CBOR Web Tokens, either 'ES256' or 'EdDSA'.
+credential_issuer:  did:web DID which issues Verifiable Credentials, set as their 'iss' claim.
+credential_schema:  JSON Schema which the 'vc' claim of Verifiable Credentials must match. Only the 'type',
+                    'enum', 'const', 'properties', 'required', 'additionalProperties', 'items', 'contains'
+                    and 'pattern' keywords are supported, along with annotations such as 'title' and
+                    'description'. Schemas using any other keyword are rejected.
+ca_pem_bundle:      PEM bundle containing the CA certificate and private key used to issue signing key certificates.
+                    If not set, a self-signed CA is generated.
 `
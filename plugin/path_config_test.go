@@ -2,10 +2,12 @@ package jwtsecrets
 
 import (
 	"context"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/go-test/deep"
 	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2"
 )
 
 const (
@@ -170,4 +172,114 @@ func TestWriteInvalidConfig(t *testing.T) {
 	if err == nil {
 		t.Errorf("Should have errored but got response: %#v", resp)
 	}
+
+	for _, data := range []map[string]interface{}{
+		{keyAllowedClaimPatterns: []string{"("}},
+		{keyDeniedClaimValues: map[string]interface{}{"sub": 42}},
+		{keyDeniedClaimValues: map[string]interface{}{"sub": []interface{}{"admin", 42}}},
+	} {
+		req.Data = data
+
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err == nil {
+			t.Errorf("%v: should have errored but got response: %#v", data, resp)
+		}
+	}
+}
+
+func TestExportableCannotBeUnset(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			keyExportable: true,
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	req.Data[keyExportable] = false
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err == nil {
+		t.Errorf("Should have errored but got response: %#v", resp)
+	}
+}
+
+// closingKeyProvider is a local key provider which records whether it has been closed.
+type closingKeyProvider struct {
+	localKeyProvider
+	closed bool
+}
+
+func (p *closingKeyProvider) close() error {
+	p.closed = true
+	return nil
+}
+
+func TestReplaceKeyProvider(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	provider := new(closingKeyProvider)
+	b.config.keyProvider = provider
+
+	key, err := b.getNewKey(jose.RS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Writing the provider's current settings does not replace it.
+	if resp, err := writeConfig(b, storage, map[string]interface{}{
+		keyKeyProvider: keyProviderLocal,
+	}); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+
+	if provider.closed || b.config.keyProvider != provider {
+		t.Fatal("expected the key provider not to be replaced")
+	}
+
+	server := httptest.NewServer(&fakeTransit{})
+	defer server.Close()
+
+	resp, err := writeConfig(b, storage, map[string]interface{}{
+		keyKeyProvider:    keyProviderTransit,
+		keyTransitAddress: server.URL,
+		keyTransitKey:     testTransitKey,
+		keyTransitToken:   testTransitToken,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+
+	if !provider.closed {
+		t.Error("expected the replaced key provider to be closed")
+	}
+
+	// Keys created by the replaced provider are retired, but still published.
+	if diff := deep.Equal(b.clock.now(), key.UseUntil); diff != nil {
+		t.Error(diff)
+	}
+
+	if b.getKeyByID(key.ID) == nil {
+		t.Error("expected the retired key to be kept")
+	}
+
+	if diff := deep.Equal(true, resp.Data[keyTransitTokenSet]); diff != nil {
+		t.Error(diff)
+	}
+
+	if diff := deep.Equal(false, resp.Data[keyPKCS11PINSet]); diff != nil {
+		t.Error(diff)
+	}
+
+	if _, ok := resp.Data[keyTransitToken]; ok {
+		t.Error("expected the transit token not to be returned")
+	}
 }
@@ -0,0 +1,63 @@
+package jwtsecrets
+
+import (
+	"context"
+	"encoding/base64"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathCOSEKeys(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "cose_keys",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathCOSEKeysRead,
+			},
+		},
+
+		HelpSynopsis:    pathCOSEKeysHelpSyn,
+		HelpDescription: pathCOSEKeysHelpDesc,
+	}
+}
+
+func (b *backend) pathCOSEKeysRead(_ context.Context, _ *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	b.pruneOldKeys()
+
+	b.keysLock.RLock()
+	defer b.keysLock.RUnlock()
+
+	keys := make([]interface{}, 0)
+	for _, k := range b.keys {
+		if !isCOSEAlgorithm(k.Algorithm) {
+			continue
+		}
+
+		key, err := coseKey(k)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	keySet, err := encodeCBOR(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"keys": base64.RawURLEncoding.EncodeToString(keySet),
+		},
+	}, nil
+}
+
+const pathCOSEKeysHelpSyn = `
+Get a COSE key set.
+`
+
+const pathCOSEKeysHelpDesc = `
+Get the public keys which verify CBOR Web Tokens signed by this backend, as a base64url encoded
+COSE_KeySet. Each key's 'kid' matches the 'kid' in the protected header of the tokens it signs.
+`
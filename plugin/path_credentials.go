@@ -0,0 +1,146 @@
+package jwtsecrets
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	keyCredentialType    = "type"
+	keyCredentialSubject = "subject"
+)
+
+func pathCredentials(b *backend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "credentials/sign",
+			Fields: map[string]*framework.FieldSchema{
+				keyCredentialType: {
+					Type:        framework.TypeString,
+					Description: `Type of the credential, in addition to 'VerifiableCredential'.`,
+				},
+				keyCredentialSubject: {
+					Type:        framework.TypeMap,
+					Description: `Claims about the subject of the credential. If set, 'id' is also used as the 'sub' claim.`,
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathCredentialsSignWrite,
+				},
+			},
+
+			HelpSynopsis:    pathCredentialsSignHelpSyn,
+			HelpDescription: pathCredentialsSignHelpDesc,
+		},
+		{
+			Pattern: "credentials/did",
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathCredentialsDIDRead,
+				},
+			},
+
+			HelpSynopsis:    pathCredentialsDIDHelpSyn,
+			HelpDescription: pathCredentialsDIDHelpDesc,
+		},
+	}
+}
+
+func (b *backend) pathCredentialsSignWrite(c context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	b.configLock.RLock()
+	config := *b.config
+	b.configLock.RUnlock()
+
+	if config.CredentialIssuer == "" {
+		return logical.ErrorResponse("no credential issuer configured"), logical.ErrInvalidRequest
+	}
+
+	if _, ok := hmacSecretSizes[config.SigningAlgorithm]; ok {
+		return logical.ErrorResponse("credentials cannot be signed with %s, as the key cannot be published", config.SigningAlgorithm), logical.ErrInvalidRequest
+	}
+
+	subject, ok := d.GetOk(keyCredentialSubject)
+	if !ok {
+		return logical.ErrorResponse("no credential subject provided"), logical.ErrInvalidRequest
+	}
+
+	vc, err := credentialClaim(d.Get(keyCredentialType).(string), subject.(map[string]interface{}))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	if len(config.CredentialSchema) > 0 {
+		if err := validateSchema(config.CredentialSchema, vc, "vc"); err != nil {
+			return logical.ErrorResponse("credential does not match the schema: %v", err), logical.ErrInvalidRequest
+		}
+	}
+
+	claims := map[string]interface{}{
+		"vc": vc,
+	}
+
+	if rawID, ok := subject.(map[string]interface{})["id"]; ok {
+		id, ok := rawID.(string)
+		if !ok {
+			return logical.ErrorResponse("credential subject 'id' was %T, not string", rawID), logical.ErrInvalidRequest
+		}
+		claims["sub"] = id
+	}
+
+	return b.signClaims(c, r, claims, signOptions{credential: true, noRefreshToken: true})
+}
+
+func (b *backend) pathCredentialsDIDRead(_ context.Context, _ *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	b.configLock.RLock()
+	did := b.config.CredentialIssuer
+	b.configLock.RUnlock()
+
+	if did == "" {
+		return logical.ErrorResponse("no credential issuer configured"), logical.ErrInvalidRequest
+	}
+
+	document, err := didDocument(did, b.getPublicKeys())
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: document,
+	}, nil
+}
+
+const pathCredentialsSignHelpSyn = `
+Sign a Verifiable Credential.
+`
+
+const pathCredentialsSignHelpDesc = `
+Sign a W3C Verifiable Credential as a JWT. The credential is set as the 'vc' claim, and must match the
+'credential_schema' if one is configured. The 'iss' claim is the configured 'credential_issuer', and the
+nbf' and 'jti' claims are always set. Credentials are signed with the 'signing_algorithm', which must not
+be an HMAC algorithm, and are neither leased nor refreshable. The 'kid' header is the ID of the signing
+key's verification method in the DID document, which the verify and introspect endpoints also resolve.
+Otherwise credentials are signed like other tokens: claims are mapped from the caller's identity, and
+checked against the 'denied_claim_values', the profile and the 'sign_policies'.
+
+type:    Type of the credential, listed after 'VerifiableCredential' in the credential's 'type'.
+subject: Claims about the subject of the credential, set as the 'credentialSubject'. If it has an 'id',
+         it is also set as the 'sub' claim and must match the 'subject_pattern'.
+`
+
+const pathCredentialsDIDHelpSyn = `
+Get the DID document of the credential issuer.
+`
+
+const pathCredentialsDIDHelpDesc = `
+Get the DID document of the configured 'credential_issuer', with a JsonWebKey2020 verification method
+for each key in the JSON Web Key Set. The fragment of each method's ID is the key ID, and the method's ID
+is set as the 'kid' header of the credentials it signs.
+
+A did:web DID is resolved by fetching its document from the domain it names, so this document should be
+served at https://<domain>/.well-known/did.json, or https://<domain>/<path>/did.json if the DID has a path.
+`
@@ -0,0 +1,319 @@
+package jwtsecrets
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const testCredentialIssuer = "did:web:planetexpress.example.com"
+
+func configureCredentials(t *testing.T, b *backend, storage *logical.Storage, schema string) {
+	data := map[string]interface{}{
+		keyCredentialIssuer: testCredentialIssuer,
+	}
+
+	if schema != "" {
+		var decoded map[string]interface{}
+		if err := json.Unmarshal([]byte(schema), &decoded); err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		data[keyCredentialSchema] = decoded
+	}
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   *storage,
+		Data:      data,
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func signCredential(b *backend, storage *logical.Storage, typ string, subject map[string]interface{}) (*logical.Response, error) {
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "credentials/sign",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			keyCredentialType:    typ,
+			keyCredentialSubject: subject,
+		},
+	}
+
+	return b.HandleRequest(context.Background(), req)
+}
+
+func TestSignCredential(t *testing.T) {
+	b, storage := getTestBackend(t)
+	configureCredentials(t, b, storage, testCredentialSchema)
+
+	resp, err := signCredential(b, storage, "DeliveryCredential", map[string]interface{}{
+		"id":         "did:example:fry",
+		"deliveries": 3,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	token, err := jwt.ParseSigned(resp.Data["token"].(string))
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	var claims map[string]interface{}
+	if err := token.Claims(b.keys[0].Key.Public(), &claims); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	expectedClaims := map[string]interface{}{
+		"iss": testCredentialIssuer,
+		"sub": "did:example:fry",
+		"nbf": float64(0),
+		"iat": float64(0),
+		"exp": float64(5 * 60),
+		"jti": "urn:uuid:1",
+		"vc": map[string]interface{}{
+			"@context": []interface{}{"https://www.w3.org/2018/credentials/v1"},
+			"type":     []interface{}{"VerifiableCredential", "DeliveryCredential"},
+			"credentialSubject": map[string]interface{}{
+				"id":         "did:example:fry",
+				"deliveries": float64(3),
+			},
+		},
+	}
+
+	if diff := deep.Equal(expectedClaims, claims); diff != nil {
+		t.Error(diff)
+	}
+
+	if diff := deep.Equal(testCredentialIssuer+"#"+b.keys[0].ID, token.Headers[0].KeyID); diff != nil {
+		t.Error(diff)
+	}
+
+	// The backend resolves the verification method to its own key.
+	verified, err := verifyToken(b, storage, resp.Data["token"].(string))
+	if err != nil || (verified != nil && verified.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, verified)
+	}
+
+	if diff := deep.Equal(expectedClaims, verified.Data["claims"]); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestSignCredentialClaimMappings(t *testing.T) {
+	b, storage := getTestBackend(t)
+	configureCredentials(t, b, storage, "")
+
+	if resp, err := writeConfig(b, storage, map[string]interface{}{
+		keyClaimMappings: map[string]interface{}{"team": "entity.metadata.team"},
+	}); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	b.System().(*logical.StaticSystemView).EntityVal = testEntity
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "credentials/sign",
+		Storage:   *storage,
+		EntityID:  testEntity.ID,
+		Data: map[string]interface{}{
+			keyCredentialType:    "DeliveryCredential",
+			keyCredentialSubject: map[string]interface{}{"id": "did:example:bender"},
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	verified, err := verifyToken(b, storage, resp.Data["token"].(string))
+	if err != nil || (verified != nil && verified.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, verified)
+	}
+
+	if diff := deep.Equal("delivery", verified.Data["claims"].(map[string]interface{})["team"]); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestSignCredentialDenied(t *testing.T) {
+	b, storage := getTestBackend(t)
+	configureCredentials(t, b, storage, "")
+
+	if resp, err := writeConfig(b, storage, map[string]interface{}{
+		keyDeniedClaimValues: map[string]interface{}{"sub": []interface{}{"did:example:zoidberg"}},
+		keySignPolicies: []interface{}{
+			map[string]interface{}{"expression": `claims.vc.credentialSubject.deliveries < 100`, "message": "too many deliveries"},
+		},
+	}); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	resp, err := signCredential(b, storage, "DeliveryCredential", map[string]interface{}{
+		"id":         "did:example:zoidberg",
+		"deliveries": 1,
+	})
+	if err != logical.ErrInvalidRequest {
+		t.Errorf("expected a denied value to be rejected, got err:%v resp:%#v\n", err, resp)
+	}
+
+	resp, err = signCredential(b, storage, "DeliveryCredential", map[string]interface{}{
+		"id":         "did:example:fry",
+		"deliveries": 1000,
+	})
+	if err != logical.ErrPermissionDenied {
+		t.Fatalf("expected the policy to deny the credential, got err:%v resp:%#v\n", err, resp)
+	}
+
+	if diff := deep.Equal("claims denied by policy: too many deliveries", resp.Data["error"]); diff != nil {
+		t.Error(diff)
+	}
+
+	resp, err = signCredential(b, storage, "DeliveryCredential", map[string]interface{}{
+		"id":         "did:example:fry",
+		"deliveries": 3,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestSignCredentialNotMatchingSchema(t *testing.T) {
+	b, storage := getTestBackend(t)
+	configureCredentials(t, b, storage, testCredentialSchema)
+
+	resp, err := signCredential(b, storage, "DeliveryCredential", map[string]interface{}{
+		"id": "did:example:fry",
+	})
+	if err == nil || resp != nil && !resp.IsError() {
+		t.Fatalf("expected an error, got %#v", resp)
+	}
+
+	if diff := deep.Equal("credential does not match the schema: vc.credentialSubject: missing required property deliveries", resp.Data["error"]); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestSignCredentialWithoutIssuer(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	resp, err := signCredential(b, storage, "DeliveryCredential", map[string]interface{}{
+		"id": "did:example:fry",
+	})
+	if err == nil || resp != nil && !resp.IsError() {
+		t.Fatalf("expected an error, got %#v", resp)
+	}
+}
+
+func TestSignCredentialWithHMAC(t *testing.T) {
+	b, storage := getTestBackend(t)
+	configureCredentials(t, b, storage, "")
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			keySigningAlgorithm: "HS256",
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	resp, err = signCredential(b, storage, "DeliveryCredential", map[string]interface{}{
+		"id": "did:example:fry",
+	})
+	if err == nil || resp != nil && !resp.IsError() {
+		t.Fatalf("expected an error, got %#v", resp)
+	}
+}
+
+func TestInvalidCredentialConfig(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	tests := []map[string]interface{}{
+		{keyCredentialIssuer: "did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK"},
+		{keyCredentialIssuer: "did:web:"},
+		{keyCredentialIssuer: "did:web:planetexpress.example.com/credentials"},
+		{keyCredentialSchema: map[string]interface{}{"type": "planet"}},
+	}
+
+	for _, data := range tests {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Storage:   *storage,
+			Data:      data,
+		}
+
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err == nil || resp != nil && !resp.IsError() {
+			t.Errorf("expected an error setting %#v, got %#v", data, resp)
+		}
+	}
+}
+
+func TestReadDIDDocument(t *testing.T) {
+	b, storage := getTestBackend(t)
+	configureCredentials(t, b, storage, "")
+
+	if _, err := getRawToken(b, storage, map[string]interface{}{}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "credentials/did",
+		Storage:   *storage,
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	jwks := b.getPublicKeys()
+	encoded, err := json.Marshal(jwks.Keys[0])
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	var publicKeyJWK map[string]interface{}
+	if err := json.Unmarshal(encoded, &publicKeyJWK); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	methodID := testCredentialIssuer + "#" + b.keys[0].ID
+	expected := map[string]interface{}{
+		"@context": []interface{}{"https://www.w3.org/ns/did/v1", "https://w3id.org/security/suites/jws-2020/v1"},
+		"id":       testCredentialIssuer,
+		"verificationMethod": []interface{}{
+			map[string]interface{}{
+				"id":           methodID,
+				"type":         "JsonWebKey2020",
+				"controller":   testCredentialIssuer,
+				"publicKeyJwk": publicKeyJWK,
+			},
+		},
+		"assertionMethod": []interface{}{methodID},
+	}
+
+	if diff := deep.Equal(expected, resp.Data); diff != nil {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,180 @@
+package jwtsecrets
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	keySubjectToken     = "subject_token"
+	keySubjectTokenType = "subject_token_type"
+	keyAudience         = "audience"
+
+	// tokenTypeJWT is the RFC 8693 token type identifier for JWTs.
+	tokenTypeJWT = "urn:ietf:params:oauth:token-type:jwt"
+
+	// tokenTypeIDToken is the RFC 8693 token type identifier for OpenID Connect ID tokens, which are also JWTs.
+	tokenTypeIDToken = "urn:ietf:params:oauth:token-type:id_token"
+)
+
+func pathExchange(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "exchange",
+		Fields: map[string]*framework.FieldSchema{
+			keySubjectToken: {
+				Type:        framework.TypeString,
+				Description: `JWT from a trusted issuer to exchange.`,
+			},
+			keySubjectTokenType: {
+				Type:        framework.TypeString,
+				Description: `Type of the subject token.`,
+				Default:     tokenTypeJWT,
+			},
+			keyAudience: {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `Audiences of the exchanged token.`,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathExchangeWrite,
+			},
+		},
+
+		HelpSynopsis:    pathExchangeHelpSyn,
+		HelpDescription: pathExchangeHelpDesc,
+	}
+}
+
+func (b *backend) pathExchangeWrite(c context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	rawToken, ok := d.GetOk(keySubjectToken)
+	if !ok {
+		return logical.ErrorResponse("no subject token provided"), logical.ErrInvalidRequest
+	}
+
+	switch tokenType := d.Get(keySubjectTokenType).(string); tokenType {
+	case tokenTypeJWT, tokenTypeIDToken:
+	default:
+		return logical.ErrorResponse("unsupported subject token type %s", tokenType), logical.ErrInvalidRequest
+	}
+
+	token, err := jwt.ParseSigned(rawToken.(string))
+	if err != nil {
+		return logical.ErrorResponse("error parsing subject token: %v", err), logical.ErrInvalidRequest
+	}
+
+	var unverified jwt.Claims
+	if err = token.UnsafeClaimsWithoutVerification(&unverified); err != nil {
+		return logical.ErrorResponse("error parsing subject token: %v", err), logical.ErrInvalidRequest
+	}
+
+	name, issuer, err := b.findTrustedIssuer(c, r.Storage, unverified.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	if issuer == nil {
+		return logical.ErrorResponse("issuer %q is not trusted", unverified.Issuer), logical.ErrPermissionDenied
+	}
+
+	var kid string
+	for _, header := range token.Headers {
+		if header.KeyID != "" {
+			kid = header.KeyID
+			break
+		}
+	}
+
+	keys, err := b.getIssuerKeys(name, issuer, kid)
+	if err != nil {
+		return logical.ErrorResponse("error getting keys for issuer %s: %v", name, err), err
+	}
+
+	var standardClaims jwt.Claims
+	subjectClaims := make(map[string]interface{})
+
+	verified := false
+	for _, key := range keys {
+		if err = token.Claims(key.Key, &standardClaims, &subjectClaims); err == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return logical.ErrorResponse("error verifying subject token: no matching key"), logical.ErrPermissionDenied
+	}
+
+	if standardClaims.Expiry == nil {
+		return logical.ErrorResponse("subject token has no 'exp' claim"), logical.ErrInvalidRequest
+	}
+
+	// Subject tokens come from another issuer's clock, so allow the default leeway.
+	if err = standardClaims.Validate(jwt.Expected{Issuer: issuer.Issuer, Time: b.clock.now()}); err != nil {
+		return logical.ErrorResponse("error validating subject token: %v", err), logical.ErrPermissionDenied
+	}
+
+	// Issuers are required to have bound audiences, so an issuer without any can only be stored by an older version.
+	if !audienceBound(standardClaims.Audience, issuer.BoundAudiences) {
+		return logical.ErrorResponse("subject token audience is not bound to issuer %s", name), logical.ErrPermissionDenied
+	}
+
+	claims := make(map[string]interface{})
+	for from, to := range issuer.ClaimMappings {
+		if value, ok := subjectClaims[from]; ok {
+			claims[to] = value
+		}
+	}
+
+	if audience, ok := d.GetOk(keyAudience); ok {
+		claims["aud"] = audience.([]string)
+	}
+
+	// Exchanged tokens are not refreshable, so they cannot outlive the subject token by more than the token TTL.
+	resp, err := b.signClaims(c, r, claims, signOptions{noRefreshToken: true})
+	if err != nil || resp.IsError() {
+		return resp, err
+	}
+
+	b.configLock.RLock()
+	ttl := b.config.TokenTTL
+	b.configLock.RUnlock()
+
+	data := map[string]interface{}{
+		"access_token":      resp.Data["token"],
+		"issued_token_type": tokenTypeJWT,
+		"token_type":        "N_A",
+		"expires_in":        int64(ttl.Seconds()),
+	}
+	resp.Data = data
+
+	return resp, nil
+}
+
+// audienceBound returns whether any of the audiences of a token are in the bound audiences.
+func audienceBound(audiences jwt.Audience, bound []string) bool {
+	for _, aud := range bound {
+		if audiences.Contains(aud) {
+			return true
+		}
+	}
+	return false
+}
+
+const pathExchangeHelpSyn = `
+Exchange a token from a trusted issuer for a token signed by this backend.
+`
+
+const pathExchangeHelpDesc = `
+Exchange a JWT from a trusted issuer for a JWT signed by this backend, following RFC 8693.
+The subject token must be signed by a key from the issuer's key set, must be within its
+validity period and must satisfy the issuer's bound audiences. Its claims are mapped into
+the new token using the issuer's claim mappings. Exchanged tokens are never returned with
+a refresh token, so a new subject token must be exchanged once they expire.
+
+subject_token:      JWT from a trusted issuer to exchange.
+subject_token_type: Type of the subject token. Defaults to urn:ietf:params:oauth:token-type:jwt.
+audience:           Audiences of the exchanged token.
+`
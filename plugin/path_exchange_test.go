@@ -0,0 +1,230 @@
+package jwtsecrets
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	testUpstreamIssuer = "https://upstream.example.com"
+	testUpstreamKeyID  = "upstream-key"
+)
+
+// upstreamIssuer signs tokens as an external issuer.
+type upstreamIssuer struct {
+	key  *rsa.PrivateKey
+	jwks string
+}
+
+func newUpstreamIssuer(t *testing.T) *upstreamIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	jwks, err := json.Marshal(jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{{
+			Key:       key.Public(),
+			KeyID:     testUpstreamKeyID,
+			Algorithm: string(jose.RS256),
+			Use:       "sig",
+		}},
+	})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	return &upstreamIssuer{key: key, jwks: string(jwks)}
+}
+
+func (u *upstreamIssuer) sign(t *testing.T, claims map[string]interface{}) string {
+	options := (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", testUpstreamKeyID)
+	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: u.key}, options)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	token, err := jwt.Signed(sig).Claims(claims).CompactSerialize()
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	return token
+}
+
+func exchangeToken(b *backend, storage *logical.Storage, data map[string]interface{}) (*logical.Response, error) {
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "exchange",
+		Storage:   *storage,
+		Data:      data,
+	}
+
+	return b.HandleRequest(context.Background(), req)
+}
+
+func TestExchange(t *testing.T) {
+	b, storage := getTestBackend(t)
+	upstream := newUpstreamIssuer(t)
+
+	// Exchanged tokens are not refreshable even when refresh tokens are enabled.
+	enableRefreshTokens(t, b, storage)
+
+	writeIssuer(t, b, storage, "upstream", map[string]interface{}{
+		"issuer":          testUpstreamIssuer,
+		"jwks":            upstream.jwks,
+		"bound_audiences": "vault",
+		"claim_mappings":  map[string]interface{}{"email": "sub"},
+	})
+
+	subjectToken := upstream.sign(t, map[string]interface{}{
+		"iss":   testUpstreamIssuer,
+		"sub":   "12345",
+		"aud":   "vault",
+		"exp":   60,
+		"email": "zapp@example.com",
+		"other": "dropped",
+	})
+
+	resp, err := exchangeToken(b, storage, map[string]interface{}{
+		"subject_token": subjectToken,
+		"audience":      "Kif Kroker",
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	if diff := deep.Equal("urn:ietf:params:oauth:token-type:jwt", resp.Data["issued_token_type"]); diff != nil {
+		t.Error(diff)
+	}
+
+	if diff := deep.Equal(int64(300), resp.Data["expires_in"]); diff != nil {
+		t.Error(diff)
+	}
+
+	if refreshToken, ok := resp.Data[keyRefreshToken]; ok {
+		t.Errorf("expected no refresh token, got %v", refreshToken)
+	}
+
+	token, err := jwt.ParseSigned(resp.Data["access_token"].(string))
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	claims := make(map[string]interface{})
+	if err = token.Claims(b.keys[0].Key.Public(), &claims); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	expectedClaims := map[string]interface{}{
+		"sub": "zapp@example.com",
+		"aud": []interface{}{"Kif Kroker"},
+		"exp": 300.0,
+		"iat": 0.0,
+		"nbf": 0.0,
+		"iss": testIssuer,
+		"jti": "1",
+	}
+
+	if diff := deep.Equal(expectedClaims, claims); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestExchangeJWKSURL(t *testing.T) {
+	b, storage := getTestBackend(t)
+	upstream := newUpstreamIssuer(t)
+
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fetches++
+		w.Write([]byte(upstream.jwks))
+	}))
+	defer server.Close()
+
+	writeIssuer(t, b, storage, "upstream", map[string]interface{}{
+		"issuer":          testUpstreamIssuer,
+		"jwks_url":        server.URL,
+		"bound_audiences": "vault",
+		"claim_mappings":  map[string]interface{}{"sub": "sub"},
+	})
+
+	subjectToken := upstream.sign(t, map[string]interface{}{
+		"iss": testUpstreamIssuer,
+		"sub": "12345",
+		"aud": "vault",
+		"exp": 60,
+	})
+
+	for i := 0; i < 2; i++ {
+		resp, err := exchangeToken(b, storage, map[string]interface{}{"subject_token": subjectToken})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+	}
+
+	if fetches != 1 {
+		t.Errorf("expected key set to be fetched once, was fetched %d times", fetches)
+	}
+}
+
+func TestExchangeRejected(t *testing.T) {
+	b, storage := getTestBackend(t)
+	upstream := newUpstreamIssuer(t)
+	untrusted := newUpstreamIssuer(t)
+
+	writeIssuer(t, b, storage, "upstream", map[string]interface{}{
+		"issuer":          testUpstreamIssuer,
+		"jwks":            upstream.jwks,
+		"bound_audiences": "vault",
+		"claim_mappings":  map[string]interface{}{"sub": "sub"},
+	})
+
+	validClaims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss": testUpstreamIssuer,
+			"sub": "12345",
+			"aud": "vault",
+			"exp": 60,
+		}
+	}
+
+	expired := validClaims()
+	expired["exp"] = -3600
+
+	noExpiry := validClaims()
+	delete(noExpiry, "exp")
+
+	wrongAudience := validClaims()
+	wrongAudience["aud"] = "somewhere else"
+
+	unknownIssuer := validClaims()
+	unknownIssuer["iss"] = "https://unknown.example.com"
+
+	tests := map[string]map[string]interface{}{
+		"not a token":      {"subject_token": "not a token"},
+		"wrong token type": {"subject_token": upstream.sign(t, validClaims()), "subject_token_type": "urn:ietf:params:oauth:token-type:saml2"},
+		"wrong key":        {"subject_token": untrusted.sign(t, validClaims())},
+		"expired":          {"subject_token": upstream.sign(t, expired)},
+		"no expiry":        {"subject_token": upstream.sign(t, noExpiry)},
+		"wrong audience":   {"subject_token": upstream.sign(t, wrongAudience)},
+		"unknown issuer":   {"subject_token": upstream.sign(t, unknownIssuer)},
+	}
+
+	for name, data := range tests {
+		resp, err := exchangeToken(b, storage, data)
+		if err == nil || resp != nil && !resp.IsError() {
+			t.Errorf("%s: expected error, got resp:%#v\n", name, resp)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package jwtsecrets
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// introspectedClaims are the claims included in an introspection response when present in the token.
+// Timestamps are added separately, so they are returned as integers.
+var introspectedClaims = []string{"sub", "aud", "iss", "jti", "cnf"}
+
+func pathIntrospect(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "introspect",
+		Fields: map[string]*framework.FieldSchema{
+			"token": {
+				Type:        framework.TypeString,
+				Description: `JWT to introspect.`,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathIntrospectWrite,
+			},
+		},
+
+		HelpSynopsis:    pathIntrospectHelpSyn,
+		HelpDescription: pathIntrospectHelpDesc,
+	}
+}
+
+func (b *backend) pathIntrospectWrite(c context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	rawToken, ok := d.GetOk("token")
+	if !ok {
+		return logical.ErrorResponse("no token provided"), logical.ErrInvalidRequest
+	}
+
+	token, err := b.verifyToken(c, r.Storage, rawToken.(string))
+	if _, ok := err.(*invalidTokenError); ok {
+		// RFC 7662 section 2.2: no other information should be returned about inactive tokens.
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"active": false,
+			},
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"active": true,
+		"kid":    token.KeyID,
+	}
+
+	for _, claim := range introspectedClaims {
+		if value, ok := token.Claims[claim]; ok {
+			data[claim] = value
+		}
+	}
+
+	for claim, value := range map[string]*jwt.NumericDate{
+		"exp": token.StandardClaims.Expiry,
+		"iat": token.StandardClaims.IssuedAt,
+		"nbf": token.StandardClaims.NotBefore,
+	} {
+		if value != nil {
+			data[claim] = int64(*value)
+		}
+	}
+
+	return &logical.Response{
+		Data: data,
+	}, nil
+}
+
+const pathIntrospectHelpSyn = `
+Introspect a JWT signed by this backend.
+`
+
+const pathIntrospectHelpDesc = `
+Introspect a JWT as described in RFC 7662, for resource servers which cannot validate tokens locally.
+The token is active if it was signed by a current key, is within its validity period and has not been revoked.
+Only 'active' is returned for inactive tokens.
+`
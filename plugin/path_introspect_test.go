@@ -0,0 +1,79 @@
+package jwtsecrets
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func introspectToken(t *testing.T, b *backend, storage *logical.Storage, token string) map[string]interface{} {
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "introspect",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			"token": token,
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	return resp.Data
+}
+
+func TestIntrospect(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	token, err := getRawToken(b, storage, map[string]interface{}{
+		"sub": "Zapp Brannigan",
+		"aud": "Kif Kroker",
+	})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	expected := map[string]interface{}{
+		"active": true,
+		"kid":    b.keys[0].ID,
+		"exp":    int64(300),
+		"iat":    int64(0),
+		"nbf":    int64(0),
+		"sub":    "Zapp Brannigan",
+		"aud":    "Kif Kroker",
+		"iss":    testIssuer,
+		"jti":    "1",
+	}
+
+	if diff := deep.Equal(expected, introspectToken(t, b, storage, token)); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestIntrospectInactive(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	token, err := getRawToken(b, storage, map[string]interface{}{"sub": "Zapp Brannigan"})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	inactive := map[string]interface{}{
+		"active": false,
+	}
+
+	if diff := deep.Equal(inactive, introspectToken(t, b, storage, "not a token")); diff != nil {
+		t.Error(diff)
+	}
+
+	b.clock = &fakeClock{time.Unix(5*60+1, 0)}
+
+	if diff := deep.Equal(inactive, introspectToken(t, b, storage, token)); diff != nil {
+		t.Error(diff)
+	}
+}
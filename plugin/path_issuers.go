@@ -0,0 +1,447 @@
+package jwtsecrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2"
+)
+
+const (
+	keyName           = "name"
+	keyIssuerClaim    = "issuer"
+	keyJWKS           = "jwks"
+	keyJWKSURL        = "jwks_url"
+	keyBoundAudiences = "bound_audiences"
+	keyClaimMappings  = "claim_mappings"
+
+	issuersPrefix = "issuers/"
+
+	// jwksCacheTTL is how long keys fetched from a trusted issuer's JWKS URL are cached for.
+	jwksCacheTTL = time.Hour
+
+	// jwksMinRefreshInterval limits how often a JWKS URL is fetched when tokens signed by unknown keys are presented.
+	jwksMinRefreshInterval = time.Minute
+
+	// jwksFetchTimeout limits how long fetching a JWKS URL can take.
+	jwksFetchTimeout = 10 * time.Second
+
+	// jwksMaxSize is the largest key set, in bytes, which is read from a JWKS URL.
+	jwksMaxSize = 1 << 20
+)
+
+// trustedIssuer is an external issuer whose tokens can be exchanged for tokens signed by this backend.
+type trustedIssuer struct {
+	// Issuer is the 'iss' claim of tokens from this issuer.
+	Issuer string `json:"issuer"`
+
+	// JWKS is a static JSON Web Key Set used to verify tokens from this issuer.
+	JWKS string `json:"jwks"`
+
+	// JWKSURL is fetched to get the keys used to verify tokens from this issuer, if JWKS is not set.
+	JWKSURL string `json:"jwks_url"`
+
+	// BoundAudiences requires tokens from this issuer to have at least one of these audiences.
+	// It must be set, as otherwise any token from the issuer, whoever it was issued for, could be exchanged.
+	BoundAudiences []string `json:"bound_audiences"`
+
+	// ClaimMappings maps claims in tokens from this issuer to claims in the exchanged token.
+	// Claims which are not mapped are dropped.
+	ClaimMappings map[string]string `json:"claim_mappings"`
+}
+
+// cachedJWKS is a key set fetched from a trusted issuer.
+type cachedJWKS struct {
+	keys      *jose.JSONWebKeySet
+	fetchedAt time.Time
+}
+
+func pathIssuers(b *backend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "issuers/?$",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathIssuersList,
+				},
+			},
+
+			HelpSynopsis:    pathIssuersListHelpSyn,
+			HelpDescription: pathIssuersListHelpDesc,
+		},
+		{
+			Pattern: "issuers/" + framework.GenericNameRegex(keyName),
+			Fields: map[string]*framework.FieldSchema{
+				keyName: {
+					Type:        framework.TypeString,
+					Description: `Name of the trusted issuer.`,
+				},
+				keyIssuerClaim: {
+					Type:        framework.TypeString,
+					Description: `The 'iss' claim of tokens from this issuer.`,
+				},
+				keyJWKS: {
+					Type:        framework.TypeString,
+					Description: `JSON Web Key Set used to verify tokens from this issuer.`,
+				},
+				keyJWKSURL: {
+					Type:        framework.TypeString,
+					Description: `URL of the JSON Web Key Set used to verify tokens from this issuer. Ignored if 'jwks' is set.`,
+				},
+				keyBoundAudiences: {
+					Type:        framework.TypeCommaStringSlice,
+					Description: `Tokens from this issuer must have at least one of these audiences. Required.`,
+				},
+				keyClaimMappings: {
+					Type:        framework.TypeKVPairs,
+					Description: `Mappings from claims in tokens from this issuer to claims in the exchanged token.`,
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathIssuerWrite,
+				},
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathIssuerRead,
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.pathIssuerDelete,
+				},
+			},
+
+			HelpSynopsis:    pathIssuerHelpSyn,
+			HelpDescription: pathIssuerHelpDesc,
+		},
+	}
+}
+
+func (b *backend) pathIssuersList(c context.Context, r *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	names, err := r.Storage.List(c, issuersPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(names), nil
+}
+
+func (b *backend) pathIssuerWrite(c context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get(keyName).(string)
+
+	issuer, err := b.getTrustedIssuer(c, r.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if issuer == nil {
+		issuer = &trustedIssuer{}
+	}
+
+	if newIssuer, ok := d.GetOk(keyIssuerClaim); ok {
+		issuer.Issuer = newIssuer.(string)
+	}
+
+	if newJWKS, ok := d.GetOk(keyJWKS); ok {
+		issuer.JWKS = newJWKS.(string)
+	}
+
+	if newJWKSURL, ok := d.GetOk(keyJWKSURL); ok {
+		issuer.JWKSURL = newJWKSURL.(string)
+	}
+
+	if newBoundAudiences, ok := d.GetOk(keyBoundAudiences); ok {
+		issuer.BoundAudiences = newBoundAudiences.([]string)
+	}
+
+	if newClaimMappings, ok := d.GetOk(keyClaimMappings); ok {
+		issuer.ClaimMappings = newClaimMappings.(map[string]string)
+	}
+
+	if issuer.Issuer == "" {
+		return logical.ErrorResponse("'%s' must be set", keyIssuerClaim), logical.ErrInvalidRequest
+	}
+
+	if len(issuer.BoundAudiences) == 0 {
+		return logical.ErrorResponse("'%s' must be set", keyBoundAudiences), logical.ErrInvalidRequest
+	}
+
+	if issuer.JWKS == "" && issuer.JWKSURL == "" {
+		return logical.ErrorResponse("one of '%s' or '%s' must be set", keyJWKS, keyJWKSURL), logical.ErrInvalidRequest
+	}
+
+	if issuer.JWKS != "" {
+		var keys jose.JSONWebKeySet
+		if err = json.Unmarshal([]byte(issuer.JWKS), &keys); err != nil {
+			return logical.ErrorResponse("invalid '%s': %v", keyJWKS, err), logical.ErrInvalidRequest
+		}
+	}
+
+	entry, err := logical.StorageEntryJSON(issuersPrefix+name, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = r.Storage.Put(c, entry); err != nil {
+		return nil, err
+	}
+
+	b.clearIssuerNames()
+	b.clearCachedJWKS(name)
+
+	return nil, nil
+}
+
+func (b *backend) pathIssuerRead(c context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	issuer, err := b.getTrustedIssuer(c, r.Storage, d.Get(keyName).(string))
+	if err != nil {
+		return nil, err
+	}
+	if issuer == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			keyIssuerClaim:    issuer.Issuer,
+			keyJWKS:           issuer.JWKS,
+			keyJWKSURL:        issuer.JWKSURL,
+			keyBoundAudiences: issuer.BoundAudiences,
+			keyClaimMappings:  issuer.ClaimMappings,
+		},
+	}, nil
+}
+
+func (b *backend) pathIssuerDelete(c context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get(keyName).(string)
+
+	if err := r.Storage.Delete(c, issuersPrefix+name); err != nil {
+		return nil, err
+	}
+
+	b.clearIssuerNames()
+
+	b.jwksCacheLock.Lock()
+	delete(b.jwksCache, name)
+	delete(b.jwksFetchLocks, name)
+	b.jwksCacheLock.Unlock()
+
+	return nil, nil
+}
+
+// getTrustedIssuer returns the trusted issuer with the given name, or nil if there is no such issuer.
+func (b *backend) getTrustedIssuer(c context.Context, s logical.Storage, name string) (*trustedIssuer, error) {
+	entry, err := s.Get(c, issuersPrefix+name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var issuer trustedIssuer
+	if err = entry.DecodeJSON(&issuer); err != nil {
+		return nil, err
+	}
+
+	return &issuer, nil
+}
+
+// findTrustedIssuer returns the name and config of the trusted issuer with the given 'iss' claim, or nil if there is none.
+func (b *backend) findTrustedIssuer(c context.Context, s logical.Storage, iss string) (string, *trustedIssuer, error) {
+	name, ok, err := b.getIssuerName(c, s, iss)
+	if err != nil || !ok {
+		return "", nil, err
+	}
+
+	issuer, err := b.getTrustedIssuer(c, s, name)
+	if err != nil {
+		return "", nil, err
+	}
+	if issuer == nil || issuer.Issuer != iss {
+		return "", nil, nil
+	}
+
+	return name, issuer, nil
+}
+
+// getIssuerName returns the name of the trusted issuer with the given 'iss' claim, loading every trusted issuer from
+// storage the first time it is called after an issuer changes. If several issuers have the same 'iss' claim, the
+// first by name is used.
+func (b *backend) getIssuerName(c context.Context, s logical.Storage, iss string) (string, bool, error) {
+	b.issuersLock.Lock()
+	defer b.issuersLock.Unlock()
+
+	if b.issuerNames == nil {
+		names, err := s.List(c, issuersPrefix)
+		if err != nil {
+			return "", false, err
+		}
+
+		issuerNames := make(map[string]string, len(names))
+		for _, name := range names {
+			issuer, err := b.getTrustedIssuer(c, s, name)
+			if err != nil {
+				return "", false, err
+			}
+			if issuer == nil {
+				continue
+			}
+			if _, ok := issuerNames[issuer.Issuer]; !ok {
+				issuerNames[issuer.Issuer] = name
+			}
+		}
+		b.issuerNames = issuerNames
+	}
+
+	name, ok := b.issuerNames[iss]
+	return name, ok, nil
+}
+
+// clearIssuerNames clears the trusted issuers loaded by getIssuerName, so they are loaded again when next needed.
+func (b *backend) clearIssuerNames() {
+	b.issuersLock.Lock()
+	defer b.issuersLock.Unlock()
+
+	b.issuerNames = nil
+}
+
+// getIssuerKeys returns the keys with the given ID for a trusted issuer, fetching its JWKS URL if the cached
+// keys have expired or do not contain the key. Each issuer's JWKS URL is fetched by one request at a time, so a slow
+// issuer does not hold up requests for other issuers.
+func (b *backend) getIssuerKeys(name string, issuer *trustedIssuer, kid string) ([]jose.JSONWebKey, error) {
+	if issuer.JWKS != "" {
+		var keys jose.JSONWebKeySet
+		if err := json.Unmarshal([]byte(issuer.JWKS), &keys); err != nil {
+			return nil, err
+		}
+		return keys.Key(kid), nil
+	}
+
+	if keys, ok := b.getCachedJWKS(name, kid); ok {
+		return keys, nil
+	}
+
+	b.jwksCacheLock.Lock()
+	fetchLock, ok := b.jwksFetchLocks[name]
+	if !ok {
+		fetchLock = new(sync.Mutex)
+		b.jwksFetchLocks[name] = fetchLock
+	}
+	b.jwksCacheLock.Unlock()
+
+	fetchLock.Lock()
+	defer fetchLock.Unlock()
+
+	// The keys may have been fetched while waiting for the lock.
+	if keys, ok := b.getCachedJWKS(name, kid); ok {
+		return keys, nil
+	}
+
+	keys, err := fetchJWKS(issuer.JWKSURL)
+	if err != nil {
+		return nil, err
+	}
+
+	b.jwksCacheLock.Lock()
+	b.jwksCache[name] = &cachedJWKS{
+		keys:      keys,
+		fetchedAt: b.clock.now(),
+	}
+	b.jwksCacheLock.Unlock()
+
+	return keys.Key(kid), nil
+}
+
+// getCachedJWKS returns the cached keys with the given ID for a trusted issuer, and whether they can be used
+// without fetching the issuer's JWKS URL again.
+func (b *backend) getCachedJWKS(name, kid string) ([]jose.JSONWebKey, bool) {
+	b.jwksCacheLock.Lock()
+	defer b.jwksCacheLock.Unlock()
+
+	now := b.clock.now()
+
+	cached, ok := b.jwksCache[name]
+	if !ok || !cached.fetchedAt.Add(jwksCacheTTL).After(now) {
+		return nil, false
+	}
+
+	keys := cached.keys.Key(kid)
+	if len(keys) > 0 || cached.fetchedAt.Add(jwksMinRefreshInterval).After(now) {
+		return keys, true
+	}
+
+	return nil, false
+}
+
+func (b *backend) clearCachedJWKS(name string) {
+	b.jwksCacheLock.Lock()
+	defer b.jwksCacheLock.Unlock()
+
+	delete(b.jwksCache, name)
+}
+
+// fetchJWKS gets a JSON Web Key Set from a URL.
+func fetchJWKS(url string) (*jose.JSONWebKeySet, error) {
+	client := cleanhttp.DefaultClient()
+	client.Timeout = jwksFetchTimeout
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching %s: %s", url, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, jwksMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > jwksMaxSize {
+		return nil, fmt.Errorf("key set from %s is larger than %d bytes", url, jwksMaxSize)
+	}
+
+	var keys jose.JSONWebKeySet
+	if err = json.Unmarshal(body, &keys); err != nil {
+		return nil, fmt.Errorf("error decoding key set from %s: %v", url, err)
+	}
+
+	return &keys, nil
+}
+
+const pathIssuersListHelpSyn = `
+List trusted issuers.
+`
+
+const pathIssuersListHelpDesc = `
+List the external issuers whose tokens can be exchanged for tokens signed by this backend.
+`
+
+const pathIssuerHelpSyn = `
+Manage a trusted issuer.
+`
+
+const pathIssuerHelpDesc = `
+Configure an external issuer whose tokens can be exchanged for tokens signed by this backend.
+
+issuer:          The 'iss' claim of tokens from this issuer.
+jwks:            JSON Web Key Set used to verify tokens from this issuer.
+jwks_url:        URL of the JSON Web Key Set used to verify tokens from this issuer.
+                 Keys are cached for an hour, or until a token signed by an unknown key is presented,
+                 but are not fetched more than once a minute. Fetches time out after ten seconds, and key sets
+                 larger than 1 MiB are rejected.
+bound_audiences: Tokens from this issuer must have at least one of these audiences. Required, so that tokens
+                 the issuer issued for other services cannot be exchanged.
+claim_mappings:  Mappings from claims in tokens from this issuer to claims in the exchanged token.
+                 Claims which are not mapped are dropped, and mapped claims must be allowed by 'allowed_claims'.
+`
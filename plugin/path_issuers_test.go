@@ -0,0 +1,202 @@
+package jwtsecrets
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func writeIssuer(t *testing.T, b *backend, storage *logical.Storage, name string, data map[string]interface{}) {
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issuers/" + name,
+		Storage:   *storage,
+		Data:      data,
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestIssuers(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	writeIssuer(t, b, storage, "upstream", map[string]interface{}{
+		"issuer":          "https://upstream.example.com",
+		"jwks_url":        "https://upstream.example.com/jwks",
+		"bound_audiences": "vault,other",
+		"claim_mappings":  map[string]interface{}{"email": "sub"},
+	})
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "issuers/upstream",
+		Storage:   *storage,
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	expected := map[string]interface{}{
+		"issuer":          "https://upstream.example.com",
+		"jwks":            "",
+		"jwks_url":        "https://upstream.example.com/jwks",
+		"bound_audiences": []string{"vault", "other"},
+		"claim_mappings":  map[string]string{"email": "sub"},
+	}
+
+	if diff := deep.Equal(expected, resp.Data); diff != nil {
+		t.Error(diff)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "issuers/",
+		Storage:   *storage,
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	if diff := deep.Equal([]string{"upstream"}, resp.Data["keys"]); diff != nil {
+		t.Error(diff)
+	}
+
+	req = &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "issuers/upstream",
+		Storage:   *storage,
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	issuer, err := b.getTrustedIssuer(context.Background(), *storage, "upstream")
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if issuer != nil {
+		t.Errorf("issuer was not deleted: %#v", issuer)
+	}
+}
+
+func TestFindTrustedIssuer(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	findName := func(iss string) string {
+		name, _, err := b.findTrustedIssuer(context.Background(), *storage, iss)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		return name
+	}
+
+	writeIssuer(t, b, storage, "upstream", map[string]interface{}{
+		"issuer":          "https://upstream.example.com",
+		"jwks_url":        "https://upstream.example.com/jwks",
+		"bound_audiences": "vault",
+	})
+
+	if diff := deep.Equal("upstream", findName("https://upstream.example.com")); diff != nil {
+		t.Error(diff)
+	}
+
+	writeIssuer(t, b, storage, "upstream", map[string]interface{}{
+		"issuer": "https://momcorp.example.com",
+	})
+
+	if diff := deep.Equal("", findName("https://upstream.example.com")); diff != nil {
+		t.Error(diff)
+	}
+	if diff := deep.Equal("upstream", findName("https://momcorp.example.com")); diff != nil {
+		t.Error(diff)
+	}
+
+	// Another node changing the issuer invalidates it.
+	entry, err := (*storage).Get(context.Background(), issuersPrefix+"upstream")
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if err = (*storage).Delete(context.Background(), issuersPrefix+"upstream"); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	entry.Key = issuersPrefix + "momcorp"
+	if err = (*storage).Put(context.Background(), entry); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	b.InvalidateKey(context.Background(), issuersPrefix+"upstream")
+
+	if diff := deep.Equal("momcorp", findName("https://momcorp.example.com")); diff != nil {
+		t.Error(diff)
+	}
+
+	b.jwksFetchLocks["momcorp"] = new(sync.Mutex)
+
+	req := &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "issuers/momcorp",
+		Storage:   *storage,
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	if diff := deep.Equal("", findName("https://momcorp.example.com")); diff != nil {
+		t.Error(diff)
+	}
+	if _, ok := b.jwksFetchLocks["momcorp"]; ok {
+		t.Error("expected the deleted issuer's fetch lock to be removed")
+	}
+}
+
+func TestIssuerValidation(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	tests := map[string]map[string]interface{}{
+		"no issuer":    {"jwks_url": "https://upstream.example.com/jwks"},
+		"no keys":      {"issuer": "https://upstream.example.com"},
+		"invalid jwks": {"issuer": "https://upstream.example.com", "jwks": "not a key set", "bound_audiences": "vault"},
+		"no audiences": {"issuer": "https://upstream.example.com", "jwks_url": "https://upstream.example.com/jwks"},
+	}
+
+	for name, data := range tests {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issuers/upstream",
+			Storage:   *storage,
+			Data:      data,
+		}
+
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err == nil || resp != nil && !resp.IsError() {
+			t.Errorf("%s: expected error, got resp:%#v\n", name, resp)
+		}
+	}
+}
+
+func TestFetchJWKSTooLarge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"keys":[],"padding":"` + strings.Repeat("a", jwksMaxSize) + `"}`))
+	}))
+	defer server.Close()
+
+	if _, err := fetchJWKS(server.URL); err == nil {
+		t.Error("expected error fetching a key set larger than the limit")
+	}
+}
@@ -7,9 +7,24 @@ import (
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
+const (
+	keyFormat = "format"
+
+	jwksFormatJWKS   = "jwks"
+	jwksFormatSPIFFE = "spiffe"
+)
+
 func pathJwks(b *backend) *framework.Path {
 	return &framework.Path{
 		Pattern: "jwks",
+		Fields: map[string]*framework.FieldSchema{
+			keyFormat: {
+				Type:        framework.TypeString,
+				Description: `Format of the key set, either 'jwks' or 'spiffe'.`,
+				Default:     jwksFormatJWKS,
+			},
+		},
+
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.ReadOperation: &framework.PathOperation{
 				Callback: b.pathJwksRead,
@@ -21,12 +36,39 @@ func pathJwks(b *backend) *framework.Path {
 	}
 }
 
-func (b *backend) pathJwksRead(_ context.Context, _ *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
-	return &logical.Response{
-		Data: map[string]interface{}{
-			"keys": b.getPublicKeys().Keys,
-		},
-	}, nil
+func (b *backend) pathJwksRead(_ context.Context, _ *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	keys := b.getPublicKeys().Keys
+
+	switch format := d.Get(keyFormat).(string); format {
+	case jwksFormatJWKS:
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"keys": keys,
+			},
+		}, nil
+	case jwksFormatSPIFFE:
+		for i := range keys {
+			keys[i].Use = spiffeKeyUse
+		}
+
+		b.keysLock.RLock()
+		sequence := b.keysSequence
+		b.keysLock.RUnlock()
+
+		b.configLock.RLock()
+		refreshHint := b.config.KeyRotationPeriod / 2
+		b.configLock.RUnlock()
+
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"keys":                keys,
+				"spiffe_sequence":     sequence,
+				"spiffe_refresh_hint": int64(refreshHint.Seconds()),
+			},
+		}, nil
+	default:
+		return logical.ErrorResponse("unknown format %s", format), logical.ErrInvalidRequest
+	}
 }
 
 const pathJwksHelpSyn = `
@@ -35,4 +77,9 @@ Get a JSON Web Key Set.
 
 const pathJwksHelpDesc = `
 Get a JSON Web Key Set.
+
+format: Format of the key set. If 'spiffe', the key set is returned as a SPIFFE trust bundle,
+        with each key's 'use' set to 'jwt-svid'. The bundle's sequence number is advanced to the current
+        time in nanoseconds whenever the key set changes, so it increases across restarts, and its refresh
+        hint is half the key rotation period.
 `
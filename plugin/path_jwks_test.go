@@ -2,7 +2,12 @@ package jwtsecrets
 
 import (
 	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/go-test/deep"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -85,3 +90,226 @@ func TestJwks(t *testing.T) {
 		t.Error(diff)
 	}
 }
+
+func TestJwksCertificates(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			keyIssueCertificates: true,
+			keySetX5TS256:        true,
+			keySetX5T:            true,
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	if resp.Data[keyCACertificate] == "" {
+		t.Error("expected a CA certificate to be generated")
+	}
+
+	token, err := getRawToken(b, storage, map[string]interface{}{"sub": "Zapp Brannigan"})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	keys := b.getPublicKeys().Keys
+	if len(keys) != 1 {
+		t.Fatalf("expected 1 key, got %d", len(keys))
+	}
+
+	key := keys[0]
+	if len(key.Certificates) != 2 {
+		t.Fatalf("expected a certificate chain of length 2, got %d", len(key.Certificates))
+	}
+
+	if err = key.Certificates[0].CheckSignatureFrom(key.Certificates[1]); err != nil {
+		t.Errorf("key certificate not issued by CA: %v", err)
+	}
+
+	if diff := deep.Equal(key.Key, key.Certificates[0].PublicKey); diff != nil {
+		t.Error(diff)
+	}
+
+	expectedSHA1 := sha1.Sum(key.Certificates[0].Raw)
+	expectedSHA256 := sha256.Sum256(key.Certificates[0].Raw)
+
+	if diff := deep.Equal(expectedSHA1[:], key.CertificateThumbprintSHA1); diff != nil {
+		t.Error(diff)
+	}
+
+	if diff := deep.Equal(expectedSHA256[:], key.CertificateThumbprintSHA256); diff != nil {
+		t.Error(diff)
+	}
+
+	parsed, err := jose.ParseSigned(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := deep.Equal(base64.RawURLEncoding.EncodeToString(expectedSHA256[:]), parsed.Signatures[0].Header.ExtraHeaders["x5t#S256"]); diff != nil {
+		t.Error(diff)
+	}
+
+	if diff := deep.Equal(base64.RawURLEncoding.EncodeToString(expectedSHA1[:]), parsed.Signatures[0].Header.ExtraHeaders["x5t"]); diff != nil {
+		t.Error(diff)
+	}
+
+	// The key set should survive a round trip through JSON with its certificates intact.
+	encoded, err := json.Marshal(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded []jose.JSONWebKey
+	if err = json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := deep.Equal(key.CertificateThumbprintSHA256, decoded[0].CertificateThumbprintSHA256); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestJwksConfiguredCA(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	ca, err := generateSelfSignedCA("test CA", time.Unix(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certBundle, err := ca.ToCertBundle()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			keyIssueCertificates: true,
+			keyCAPEMBundle:       certBundle.ToPEMBundle(),
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	if _, ok := resp.Data[keyCAPEMBundle]; ok {
+		t.Error("CA bundle should not be returned")
+	}
+
+	if _, err = b.getNewKey(jose.RS256); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := b.getPublicKeys().Keys
+	if err = keys[0].Certificates[0].CheckSignatureFrom(ca.Certificate); err != nil {
+		t.Errorf("key certificate not issued by configured CA: %v", err)
+	}
+
+	// Tokens signed by this key should not get the header unless it is enabled.
+	token, err := getRawToken(b, storage, map[string]interface{}{"sub": "Zapp Brannigan"})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	parsed, err := jose.ParseSigned(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := parsed.Signatures[0].Header.ExtraHeaders["x5t#S256"]; ok {
+		t.Error("'x5t#S256' header should not be set")
+	}
+}
+
+func TestJwksSPIFFEBundle(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	b.clock = &fakeClock{time.Unix(100, 0)}
+
+	readBundle := func() map[string]interface{} {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "jwks",
+			Storage:   *storage,
+			Data: map[string]interface{}{
+				"format": "spiffe",
+			},
+		}
+
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("err:%s resp:%#v\n", err, resp)
+		}
+
+		return resp.Data
+	}
+
+	if diff := deep.Equal(int64(0), readBundle()["spiffe_sequence"]); diff != nil {
+		t.Error(diff)
+	}
+
+	if _, err := getRawToken(b, storage, map[string]interface{}{"sub": "Zapp Brannigan"}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	bundle := readBundle()
+
+	keys := bundle["keys"].([]jose.JSONWebKey)
+	if len(keys) != 1 || keys[0].Use != "jwt-svid" {
+		t.Errorf("expected one key with use 'jwt-svid', got %#v", keys)
+	}
+
+	if diff := deep.Equal(int64(100*time.Second), bundle["spiffe_sequence"]); diff != nil {
+		t.Error(diff)
+	}
+
+	// Half of the default 15 minute rotation period.
+	if diff := deep.Equal(int64(450), bundle["spiffe_refresh_hint"]); diff != nil {
+		t.Error(diff)
+	}
+
+	b.clock = &fakeClock{time.Unix(200, 0)}
+	b.removeKeys(func(_ *signingKey) bool { return true })
+
+	if diff := deep.Equal(int64(200*time.Second), readBundle()["spiffe_sequence"]); diff != nil {
+		t.Error(diff)
+	}
+
+	if keys := b.getPublicKeys().Keys; len(keys) != 0 {
+		t.Errorf("expected no keys, got %#v", keys)
+	}
+
+	// A change at the same time still advances the sequence number.
+	b.removeKeys(func(_ *signingKey) bool { return true })
+	if _, err := getRawToken(b, storage, map[string]interface{}{"sub": "Zapp Brannigan"}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if diff := deep.Equal(int64(200*time.Second)+1, readBundle()["spiffe_sequence"]); diff != nil {
+		t.Error(diff)
+	}
+
+	// A backend started later, as after a restart or failover, carries on from a higher sequence number.
+	restarted, restartedStorage := getTestBackend(t)
+	restarted.clock = &fakeClock{time.Unix(300, 0)}
+	if _, err := getRawToken(restarted, restartedStorage, map[string]interface{}{"sub": "Zapp Brannigan"}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if restarted.keysSequence <= b.keysSequence {
+		t.Errorf("expected sequence %d after restart to be above %d", restarted.keysSequence, b.keysSequence)
+	}
+}
@@ -0,0 +1,289 @@
+package jwtsecrets
+
+import (
+	"context"
+	"encoding/base64"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	keyKeyID  = "kid"
+	keyReason = "reason"
+
+	revokedKeysPrefix = "revoked/keys/"
+)
+
+// keyRevocation records why and when a key was revoked.
+type keyRevocation struct {
+	KeyID     string    `json:"kid"`
+	Reason    string    `json:"reason"`
+	RevokedAt time.Time `json:"revoked_at"`
+
+	// ExpiresAt is when the record is tidied. By then every token the key signed has expired, and the key would be
+	// pruned even if it were restored from a backup.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func pathKeys(b *backend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "keys/" + framework.GenericNameRegex(keyKeyID) + "/revoke",
+			Fields: map[string]*framework.FieldSchema{
+				keyKeyID: {
+					Type:        framework.TypeString,
+					Description: `ID of the key to revoke.`,
+				},
+				keyReason: {
+					Type:        framework.TypeString,
+					Description: `Reason the key is being revoked.`,
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathKeyRevokeWrite,
+				},
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathKeyRevokeRead,
+				},
+			},
+
+			HelpSynopsis:    pathKeyRevokeHelpSyn,
+			HelpDescription: pathKeyRevokeHelpDesc,
+		},
+		{
+			Pattern: "keys/" + framework.GenericNameRegex(keyKeyID) + "/secret",
+			Fields: map[string]*framework.FieldSchema{
+				keyKeyID: {
+					Type:        framework.TypeString,
+					Description: `ID of the HMAC key to read.`,
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathKeySecretRead,
+				},
+			},
+
+			HelpSynopsis:    pathKeySecretHelpSyn,
+			HelpDescription: pathKeySecretHelpDesc,
+		},
+		{
+			Pattern: "keys/revoke-all",
+			Fields: map[string]*framework.FieldSchema{
+				keyReason: {
+					Type:        framework.TypeString,
+					Description: `Reason the keys are being revoked.`,
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathKeyRevokeAllWrite,
+				},
+			},
+
+			HelpSynopsis:    pathKeyRevokeAllHelpSyn,
+			HelpDescription: pathKeyRevokeAllHelpDesc,
+		},
+	}
+}
+
+func (b *backend) pathKeyRevokeWrite(c context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	kid := d.Get(keyKeyID).(string)
+
+	removed := b.removeKeys(func(k *signingKey) bool {
+		return k.ID == kid
+	})
+	if len(removed) == 0 {
+		return logical.ErrorResponse("no key with ID %s", kid), logical.ErrInvalidRequest
+	}
+
+	return b.revokeKeys(c, r.Storage, removed, d.Get(keyReason).(string))
+}
+
+func (b *backend) pathKeyRevokeAllWrite(c context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	removed := b.removeKeys(func(_ *signingKey) bool {
+		return true
+	})
+	if len(removed) == 0 {
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"revoked": []string{},
+			},
+		}, nil
+	}
+
+	return b.revokeKeys(c, r.Storage, removed, d.Get(keyReason).(string))
+}
+
+func (b *backend) pathKeyRevokeRead(c context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	revocation, err := b.getKeyRevocation(c, r.Storage, d.Get(keyKeyID).(string))
+	if err != nil {
+		return nil, err
+	}
+	if revocation == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			keyKeyID:     revocation.KeyID,
+			keyReason:    revocation.Reason,
+			"revoked_at": revocation.RevokedAt.Format(time.RFC3339),
+		},
+	}, nil
+}
+
+func (b *backend) pathKeySecretRead(_ context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	kid := d.Get(keyKeyID).(string)
+
+	key := b.getKeyByID(kid)
+	if key == nil {
+		return logical.ErrorResponse("no key with ID %s", kid), logical.ErrInvalidRequest
+	}
+
+	if key.Secret == nil {
+		return logical.ErrorResponse("key %s is not an HMAC key", kid), logical.ErrInvalidRequest
+	}
+
+	b.Logger().Warn("HMAC secret read", "kid", kid, "display_name", r.DisplayName, "entity_id", r.EntityID)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			keyKeyID:      key.ID,
+			"algorithm":   string(key.Algorithm),
+			"secret":      base64.RawURLEncoding.EncodeToString(key.Secret),
+			"expiry_time": key.KeepUntil.Format(time.RFC3339),
+		},
+	}, nil
+}
+
+// revokeKeys records the revocation of keys which have already been removed from the key set, then rotates to a fresh key.
+func (b *backend) revokeKeys(c context.Context, s logical.Storage, keys []*signingKey, reason string) (*logical.Response, error) {
+	now := b.clock.now()
+
+	b.configLock.RLock()
+	algorithm := b.config.SigningAlgorithm
+	tokenTTL := b.config.TokenTTL
+	b.configLock.RUnlock()
+
+	kids := make([]string, len(keys))
+	for i, k := range keys {
+		kids[i] = k.ID
+
+		expiresAt := now.Add(tokenTTL)
+		if k.KeepUntil.After(expiresAt) {
+			expiresAt = k.KeepUntil
+		}
+
+		entry, err := logical.StorageEntryJSON(revokedKeysPrefix+k.ID, &keyRevocation{
+			KeyID:     k.ID,
+			Reason:    reason,
+			RevokedAt: now,
+			ExpiresAt: expiresAt,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if err = s.Put(c, entry); err != nil {
+			return nil, err
+		}
+
+		b.Logger().Warn("revoked signing key", "kid", k.ID, "reason", reason)
+	}
+
+	newKey, err := b.getNewKey(algorithm)
+	if err != nil {
+		return logical.ErrorResponse("error rotating key: %v", err), err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"revoked": kids,
+			"new_kid": newKey.ID,
+		},
+	}, nil
+}
+
+// tidyKeyRevocations removes revocation records of keys which could no longer verify a token even if they had not
+// been revoked.
+func (b *backend) tidyKeyRevocations(c context.Context, r *logical.Request) error {
+	kids, err := r.Storage.List(c, revokedKeysPrefix)
+	if err != nil {
+		return err
+	}
+
+	now := b.clock.now()
+
+	for _, kid := range kids {
+		revocation, err := b.getKeyRevocation(c, r.Storage, kid)
+		if err != nil {
+			return err
+		}
+
+		if revocation != nil && !revocation.ExpiresAt.After(now) {
+			if err = r.Storage.Delete(c, revokedKeysPrefix+kid); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// getKeyRevocation returns the revocation record for a key, or nil if the key has not been revoked.
+func (b *backend) getKeyRevocation(c context.Context, s logical.Storage, kid string) (*keyRevocation, error) {
+	entry, err := s.Get(c, revokedKeysPrefix+kid)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var revocation keyRevocation
+	if err = entry.DecodeJSON(&revocation); err != nil {
+		return nil, err
+	}
+
+	return &revocation, nil
+}
+
+const pathKeyRevokeHelpSyn = `
+Revoke a signing key.
+`
+
+const pathKeyRevokeHelpDesc = `
+Immediately remove a signing key from the JSON Web Key Set and rotate to a new key.
+Tokens signed by the revoked key will be rejected by the verify endpoint.
+
+reason: Reason the key is being revoked, recorded alongside the revocation.
+`
+
+const pathKeySecretHelpSyn = `
+Read the shared secret of an HMAC key.
+`
+
+const pathKeySecretHelpDesc = `
+Read the shared secret of an HMAC signing key, base64url encoded, so trusted verifiers
+can validate tokens without calling the verify endpoint. Access should be tightly
+restricted by policy; every read is logged.
+`
+
+const pathKeyRevokeAllHelpSyn = `
+Revoke all signing keys.
+`
+
+const pathKeyRevokeAllHelpDesc = `
+Immediately remove every signing key from the JSON Web Key Set and rotate to a new key.
+Tokens signed by the revoked keys will be rejected by the verify endpoint. If there are no
+keys, nothing is revoked and no new key is created.
+
+reason: Reason the keys are being revoked, recorded alongside each revocation.
+`
@@ -0,0 +1,316 @@
+package jwtsecrets
+
+import (
+	"context"
+	"crypto"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestRevokeKey(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	token, err := getRawToken(b, storage, map[string]interface{}{"sub": "Zapp Brannigan"})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	kid := b.keys[0].ID
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "keys/" + kid + "/revoke",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			keyReason: "leaked",
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	if diff := deep.Equal([]string{kid}, resp.Data["revoked"]); diff != nil {
+		t.Error(diff)
+	}
+
+	for _, k := range b.getPublicKeys().Keys {
+		if k.KeyID == kid {
+			t.Errorf("revoked key %s is still published", kid)
+		}
+	}
+
+	if len(b.keys) != 1 || b.keys[0].ID != resp.Data["new_kid"] {
+		t.Errorf("expected backend to rotate to %v, keys are %v", resp.Data["new_kid"], b.keys)
+	}
+
+	resp, err = verifyToken(b, storage, token)
+	if err == nil || resp != nil && !resp.IsError() {
+		t.Fatalf("expected to get an error from verify. got:%v\n", resp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "keys/" + kid + "/revoke",
+		Storage:   *storage,
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	if diff := deep.Equal("leaked", resp.Data[keyReason]); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestRevokeUnknownKey(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "keys/not-a-key/revoke",
+		Storage:   *storage,
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err == nil || resp != nil && !resp.IsError() {
+		t.Fatalf("expected to get an error from revoke. got:%v\n", resp)
+	}
+}
+
+func TestRevokeAllKeys(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if _, err := b.getNewKey(jose.RS256); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.getNewKey(jose.RS256); err != nil {
+		t.Fatal(err)
+	}
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "keys/revoke-all",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			keyReason: "compromised host",
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	if revoked := resp.Data["revoked"].([]string); len(revoked) != 2 {
+		t.Errorf("expected 2 revoked keys, got %v", revoked)
+	}
+
+	if keys := b.getPublicKeys().Keys; len(keys) != 1 || keys[0].KeyID != resp.Data["new_kid"] {
+		t.Errorf("expected only the new key to be published, got %v", keys)
+	}
+}
+
+func TestHMACKeys(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			keySigningAlgorithm: "HS384",
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	token, err := getRawToken(b, storage, map[string]interface{}{"sub": "Zapp Brannigan"})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if keys := b.getPublicKeys().Keys; len(keys) != 0 {
+		t.Errorf("HMAC keys should not be published, got %v", keys)
+	}
+
+	resp, err = verifyToken(b, storage, token)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	kid := b.keys[0].ID
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "keys/" + kid + "/secret",
+		Storage:   *storage,
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	secret, err := base64.RawURLEncoding.DecodeString(resp.Data["secret"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := deep.Equal(string(jose.HS384), parsed.Headers[0].Algorithm); diff != nil {
+		t.Error(diff)
+	}
+
+	var claims jwt.Claims
+	if err = parsed.Claims(secret, &claims); err != nil {
+		t.Errorf("could not verify token with secret: %v", err)
+	}
+}
+
+func TestReadSecretOfAsymmetricKey(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	key, err := b.getNewKey(jose.RS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "keys/" + key.ID + "/secret",
+		Storage:   *storage,
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err == nil || resp != nil && !resp.IsError() {
+		t.Fatalf("expected to get an error from reading secret. got:%v\n", resp)
+	}
+}
+
+// destroyRecordingSigner wraps a signer, recording whether it was destroyed.
+type destroyRecordingSigner struct {
+	crypto.Signer
+	destroyed bool
+}
+
+func (s *destroyRecordingSigner) destroy() error {
+	s.destroyed = true
+	return nil
+}
+
+func TestRevokedKeyDestroyed(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if _, err := getRawToken(b, storage, map[string]interface{}{"sub": "Kif Kroker"}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	signer := &destroyRecordingSigner{Signer: b.keys[0].Key}
+	b.keys[0].Key = signer
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "keys/" + b.keys[0].ID + "/revoke",
+		Storage:   *storage,
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	if !signer.destroyed {
+		t.Error("expected revoked key to be destroyed")
+	}
+
+	signer = &destroyRecordingSigner{Signer: b.keys[0].Key}
+	b.keys[0].Key = signer
+	b.clock = &fakeClock{b.keys[0].KeepUntil.Add(time.Second)}
+	b.pruneOldKeys()
+
+	if !signer.destroyed {
+		t.Error("expected pruned key to be destroyed")
+	}
+}
+
+func TestRevokeAllWithoutKeys(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "keys/revoke-all",
+		Storage:   *storage,
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	if diff := deep.Equal([]string{}, resp.Data["revoked"]); diff != nil {
+		t.Error(diff)
+	}
+
+	if len(b.keys) != 0 {
+		t.Errorf("expected no key to be created, got %v", b.keys)
+	}
+}
+
+func TestTidyKeyRevocations(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	key, err := b.getNewKey(jose.RS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "keys/" + key.ID + "/revoke",
+		Storage:   *storage,
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	tidyAt := func(now time.Time) *keyRevocation {
+		b.clock = &fakeClock{now}
+		if err := b.tidy(context.Background(), &logical.Request{Storage: *storage}); err != nil {
+			t.Fatalf("%v\n", err)
+		}
+
+		revocation, err := b.getKeyRevocation(context.Background(), *storage, key.ID)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		return revocation
+	}
+
+	// The key was kept until its tokens expired, which is later than the revocation plus the token TTL.
+	if revocation := tidyAt(time.Unix(0, 0).Add(10 * time.Minute)); revocation == nil {
+		t.Error("expected revocation to be kept while tokens signed by the key could be valid")
+	}
+
+	if revocation := tidyAt(key.KeepUntil); revocation != nil {
+		t.Errorf("expected revocation to be tidied, got %#v", revocation)
+	}
+}
@@ -0,0 +1,62 @@
+package jwtsecrets
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathPaserk(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "paserk",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathPaserkRead,
+			},
+		},
+
+		HelpSynopsis:    pathPaserkHelpSyn,
+		HelpDescription: pathPaserkHelpDesc,
+	}
+}
+
+func (b *backend) pathPaserkRead(_ context.Context, _ *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	b.pruneOldKeys()
+
+	b.keysLock.RLock()
+	defer b.keysLock.RUnlock()
+
+	keys := make([]map[string]interface{}, 0)
+	for _, k := range b.keys {
+		if !isPASETOAlgorithm(k.Algorithm) {
+			continue
+		}
+
+		paserk, err := paserkPublicKey(k)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, map[string]interface{}{
+			"kid":     k.ID,
+			"version": string(k.Algorithm),
+			"paserk":  paserk,
+		})
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"keys": keys,
+		},
+	}, nil
+}
+
+const pathPaserkHelpSyn = `
+Get the public keys which verify PASETO tokens.
+`
+
+const pathPaserkHelpDesc = `
+Get the public keys which verify PASETO tokens signed by this backend, serialized as PASERK
+public keys. Each key is listed with the key ID carried in the footer of the tokens it signs.
+`
@@ -0,0 +1,311 @@
+package jwtsecrets
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	keyRefreshToken = "refresh_token"
+
+	refreshTokensPrefix   = "refresh/tokens/"
+	refreshFamiliesPrefix = "refresh/families/"
+
+	// refreshTokenSize is the number of random bytes in a refresh token.
+	refreshTokenSize = 32
+)
+
+// refreshToken is the stored state of a refresh token. Tokens are stored under a digest of their value,
+// so the value itself is never persisted.
+type refreshToken struct {
+	// Claims are the claims originally supplied by the caller, which are signed again on refresh.
+	Claims map[string]interface{} `json:"claims"`
+
+	// Confirmation is the 'cnf' claim of the original token, so refreshed tokens stay bound to the same key.
+	Confirmation map[string]interface{} `json:"confirmation,omitempty"`
+
+	// Format is the format of the original token.
+	Format string `json:"format,omitempty"`
+
+	// Disclosable are the selectively disclosable claims of the original token.
+	Disclosable []string `json:"disclosable,omitempty"`
+
+	// Headers are the headers set by the caller on the original token.
+	Headers map[string]interface{} `json:"headers,omitempty"`
+
+	// FamilyID identifies every refresh token descended from the same signed token.
+	FamilyID string `json:"family_id"`
+
+	// FamilyIssuedAt is when the first token of the family was signed. No token in the family can be refreshed
+	// once RefreshMaxTTL has passed since then.
+	FamilyIssuedAt time.Time `json:"family_issued_at"`
+
+	// EntityID is the entity of the caller the refresh token was issued to. Claims mapped from the caller's identity
+	// and sign policies are evaluated again on refresh, so only the same caller can use the refresh token.
+	EntityID string `json:"entity_id,omitempty"`
+
+	// TokenAccessor is the accessor of the token the refresh token was issued to, if the caller had no entity.
+	TokenAccessor string `json:"token_accessor,omitempty"`
+
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// Used is set once the refresh token has been exchanged. Presenting a used token revokes its family.
+	Used bool `json:"used"`
+}
+
+// refreshFamilyRevocation records a refresh token family which was revoked because a token was reused.
+type refreshFamilyRevocation struct {
+	FamilyID  string    `json:"family_id"`
+	RevokedAt time.Time `json:"revoked_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func pathRefresh(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "refresh",
+		Fields: map[string]*framework.FieldSchema{
+			keyRefreshToken: {
+				Type:        framework.TypeString,
+				Description: `Refresh token returned alongside a signed token.`,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathRefreshWrite,
+			},
+		},
+
+		HelpSynopsis:    pathRefreshHelpSyn,
+		HelpDescription: pathRefreshHelpDesc,
+	}
+}
+
+func (b *backend) pathRefreshWrite(c context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	rawRefreshToken, ok := d.GetOk(keyRefreshToken)
+	if !ok {
+		return logical.ErrorResponse("no refresh token provided"), logical.ErrInvalidRequest
+	}
+
+	b.refreshLock.Lock()
+	defer b.refreshLock.Unlock()
+
+	path := refreshTokensPrefix + storageDigest(rawRefreshToken.(string))
+
+	token, err := getRefreshToken(c, r.Storage, path)
+	if err != nil {
+		return nil, err
+	}
+	if token == nil {
+		return logical.ErrorResponse("invalid refresh token"), logical.ErrPermissionDenied
+	}
+
+	b.configLock.RLock()
+	ttl := b.config.RefreshTokenTTL
+	maxTTL := b.config.RefreshMaxTTL
+	b.configLock.RUnlock()
+
+	now := b.clock.now()
+	if !token.ExpiresAt.After(now) || !token.FamilyIssuedAt.Add(maxTTL).After(now) {
+		return logical.ErrorResponse("refresh token has expired"), logical.ErrPermissionDenied
+	}
+
+	revoked, err := r.Storage.Get(c, refreshFamiliesPrefix+token.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked != nil {
+		return logical.ErrorResponse("refresh token has been revoked"), logical.ErrPermissionDenied
+	}
+
+	if token.Used {
+		// A used refresh token can only be presented again if it was stolen, so stop every token in its family from being used.
+		entry, err := logical.StorageEntryJSON(refreshFamiliesPrefix+token.FamilyID, &refreshFamilyRevocation{
+			FamilyID:  token.FamilyID,
+			RevokedAt: now,
+			ExpiresAt: now.Add(ttl),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if err = r.Storage.Put(c, entry); err != nil {
+			return nil, err
+		}
+
+		b.Logger().Warn("refresh token reused, revoked refresh token family", "family_id", token.FamilyID, "display_name", r.DisplayName, "entity_id", r.EntityID)
+
+		return logical.ErrorResponse("refresh token has already been used"), logical.ErrPermissionDenied
+	}
+
+	if !token.issuedTo(r) {
+		return logical.ErrorResponse("refresh token was issued to a different caller"), logical.ErrPermissionDenied
+	}
+
+	resp, err := b.signClaims(c, r, token.Claims, signOptions{
+		refreshFamilyID:       token.FamilyID,
+		refreshFamilyIssuedAt: token.FamilyIssuedAt,
+		confirmation:          token.Confirmation,
+		format:                token.Format,
+		disclosable:           token.Disclosable,
+		headers:               token.Headers,
+	})
+	if err != nil || resp.IsError() {
+		// The refresh token is only used up once a new token is signed, so it can be presented again after a failure.
+		return resp, err
+	}
+
+	token.Used = true
+	entry, err := logical.StorageEntryJSON(path, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = r.Storage.Put(c, entry); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// issuedTo returns whether the refresh token was issued to the caller making a request.
+func (t *refreshToken) issuedTo(r *logical.Request) bool {
+	if t.EntityID != "" || r.EntityID != "" {
+		return t.EntityID == r.EntityID
+	}
+	return t.TokenAccessor == r.ClientTokenAccessor
+}
+
+// issueRefreshToken generates a refresh token and stores its state.
+func (b *backend) issueRefreshToken(c context.Context, s logical.Storage, token *refreshToken) (string, error) {
+	raw := make([]byte, refreshTokenSize)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	value := base64.RawURLEncoding.EncodeToString(raw)
+
+	entry, err := logical.StorageEntryJSON(refreshTokensPrefix+storageDigest(value), token)
+	if err != nil {
+		return "", err
+	}
+
+	if err = s.Put(c, entry); err != nil {
+		return "", err
+	}
+
+	return value, nil
+}
+
+// getRefreshToken returns the refresh token stored at path, or nil if there is none.
+func getRefreshToken(c context.Context, s logical.Storage, path string) (*refreshToken, error) {
+	entry, err := s.Get(c, path)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var token refreshToken
+	if err = entry.DecodeJSON(&token); err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// storageDigest returns a storage key for a value which should not be stored itself.
+func storageDigest(value string) string {
+	digest := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(digest[:])
+}
+
+// copyClaims makes a deep copy of a claim set, so it can be stored before the backend adds its own claims.
+func copyClaims(claims map[string]interface{}) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	var copied map[string]interface{}
+	if err = json.Unmarshal(encoded, &copied); err != nil {
+		return nil, err
+	}
+
+	return copied, nil
+}
+
+// tidyRefreshTokens removes refresh tokens and family revocations which have expired.
+func (b *backend) tidyRefreshTokens(c context.Context, r *logical.Request) error {
+	now := b.clock.now()
+
+	digests, err := r.Storage.List(c, refreshTokensPrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, digest := range digests {
+		token, err := getRefreshToken(c, r.Storage, refreshTokensPrefix+digest)
+		if err != nil {
+			return err
+		}
+
+		if token != nil && !token.ExpiresAt.After(now) {
+			if err = r.Storage.Delete(c, refreshTokensPrefix+digest); err != nil {
+				return err
+			}
+		}
+	}
+
+	families, err := r.Storage.List(c, refreshFamiliesPrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, family := range families {
+		entry, err := r.Storage.Get(c, refreshFamiliesPrefix+family)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			continue
+		}
+
+		var revocation refreshFamilyRevocation
+		if err = entry.DecodeJSON(&revocation); err != nil {
+			return err
+		}
+
+		if !revocation.ExpiresAt.After(now) {
+			if err = r.Storage.Delete(c, refreshFamiliesPrefix+family); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+const pathRefreshHelpSyn = `
+Exchange a refresh token for a new token.
+`
+
+const pathRefreshHelpDesc = `
+Exchange a refresh token for a new token with the same claims, and a new refresh token.
+Each refresh token can only be used once, by the caller it was issued to: the same entity,
+or the same token if the caller has no entity. A refresh token is only used up once the new
+token is signed. If a used refresh token is presented again, every refresh token descended
+from the same signed token is revoked. Refresh tokens expire after 'refresh_token_ttl', and
+every refresh token descended from the same signed token expires once 'refresh_max_ttl' has
+passed since it was signed.
+
+refresh_token: Refresh token returned alongside a signed token.
+`
@@ -0,0 +1,295 @@
+package jwtsecrets
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func signWithRefresh(t *testing.T, b *backend, storage *logical.Storage, claims map[string]interface{}) *logical.Response {
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "sign",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			"claims": claims,
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	return resp
+}
+
+func refresh(b *backend, storage *logical.Storage, refreshToken string) (*logical.Response, error) {
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "refresh",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			"refresh_token": refreshToken,
+		},
+	}
+
+	return b.HandleRequest(context.Background(), req)
+}
+
+func enableRefreshTokens(t *testing.T, b *backend, storage *logical.Storage) {
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			keyRefreshTokens: true,
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	b, storage := getTestBackend(t)
+	enableRefreshTokens(t, b, storage)
+
+	signed := signWithRefresh(t, b, storage, map[string]interface{}{
+		"sub": "Zapp Brannigan",
+		"aud": []string{"Kif Kroker"},
+	})
+
+	refreshToken, ok := signed.Data["refresh_token"].(string)
+	if !ok || refreshToken == "" {
+		t.Fatalf("no refresh token returned: %#v", signed.Data)
+	}
+
+	b.clock = &fakeClock{time.Unix(10*60, 0)}
+
+	resp, err := refresh(b, storage, refreshToken)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	if resp.Data["refresh_token"] == refreshToken {
+		t.Error("refresh token was not rotated")
+	}
+
+	verified, err := verifyToken(b, storage, resp.Data["token"].(string))
+	if err != nil || (verified != nil && verified.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, verified)
+	}
+
+	expectedClaims := map[string]interface{}{
+		"sub": "Zapp Brannigan",
+		"aud": []interface{}{"Kif Kroker"},
+		"exp": 900.0,
+		"iat": 600.0,
+		"nbf": 600.0,
+		"iss": testIssuer,
+		"jti": "3",
+	}
+
+	if diff := deep.Equal(expectedClaims, verified.Data["claims"]); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestRefreshReuse(t *testing.T) {
+	b, storage := getTestBackend(t)
+	enableRefreshTokens(t, b, storage)
+
+	signed := signWithRefresh(t, b, storage, map[string]interface{}{"sub": "Zapp Brannigan"})
+	original := signed.Data["refresh_token"].(string)
+
+	resp, err := refresh(b, storage, original)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+	rotated := resp.Data["refresh_token"].(string)
+
+	resp, err = refresh(b, storage, original)
+	if err != logical.ErrPermissionDenied {
+		t.Errorf("expected reuse to be denied, got err:%v resp:%#v\n", err, resp)
+	}
+
+	// Reuse revokes the rest of the family, including the token which replaced the reused one.
+	resp, err = refresh(b, storage, rotated)
+	if err != logical.ErrPermissionDenied {
+		t.Errorf("expected rotated token to be revoked, got err:%v resp:%#v\n", err, resp)
+	}
+
+	// Other families are unaffected.
+	other := signWithRefresh(t, b, storage, map[string]interface{}{"sub": "Kif Kroker"})
+	resp, err = refresh(b, storage, other.Data["refresh_token"].(string))
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestRefreshMaxTTL(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	resp, err := writeConfig(b, storage, map[string]interface{}{
+		keyRefreshTokens:   true,
+		keyRefreshTokenTTL: "24h",
+		keyRefreshMaxTTL:   "36h",
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	refreshToken := signWithRefresh(t, b, storage, map[string]interface{}{"sub": "Zapp Brannigan"}).Data["refresh_token"].(string)
+
+	// Each refresh is within the refresh token's TTL, but the family cannot be refreshed past its maximum TTL.
+	for _, hours := range []int64{20, 35} {
+		b.clock = &fakeClock{time.Unix(hours*60*60, 0)}
+
+		resp, err := refresh(b, storage, refreshToken)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("%dh: err:%s resp:%#v\n", hours, err, resp)
+		}
+		refreshToken = resp.Data["refresh_token"].(string)
+	}
+
+	b.clock = &fakeClock{time.Unix(36*60*60, 0)}
+
+	if _, err := refresh(b, storage, refreshToken); err != logical.ErrPermissionDenied {
+		t.Errorf("expected refresh past the maximum TTL to be denied, got %v", err)
+	}
+}
+
+func TestRefreshInvalid(t *testing.T) {
+	b, storage := getTestBackend(t)
+	enableRefreshTokens(t, b, storage)
+
+	resp, err := refresh(b, storage, "not a refresh token")
+	if err == nil || resp != nil && !resp.IsError() {
+		t.Errorf("expected error, got resp:%#v\n", resp)
+	}
+
+	signed := signWithRefresh(t, b, storage, map[string]interface{}{"sub": "Zapp Brannigan"})
+
+	b.clock = &fakeClock{time.Unix(24*60*60, 0)}
+
+	resp, err = refresh(b, storage, signed.Data["refresh_token"].(string))
+	if err == nil || resp != nil && !resp.IsError() {
+		t.Errorf("expected expired refresh token to be rejected, got resp:%#v\n", resp)
+	}
+}
+
+func TestTidyRefreshTokens(t *testing.T) {
+	b, storage := getTestBackend(t)
+	enableRefreshTokens(t, b, storage)
+
+	signed := signWithRefresh(t, b, storage, map[string]interface{}{"sub": "Zapp Brannigan"})
+	original := signed.Data["refresh_token"].(string)
+
+	if _, err := refresh(b, storage, original); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if _, err := refresh(b, storage, original); err != logical.ErrPermissionDenied {
+		t.Fatalf("expected reuse to be denied, got %v\n", err)
+	}
+
+	b.clock = &fakeClock{time.Unix(24*60*60, 0)}
+
+	if err := b.tidy(context.Background(), &logical.Request{Storage: *storage}); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	for _, prefix := range []string{refreshTokensPrefix, refreshFamiliesPrefix} {
+		remaining, err := (*storage).List(context.Background(), prefix)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		if len(remaining) != 0 {
+			t.Errorf("expected %s to be empty, found %v", prefix, remaining)
+		}
+	}
+}
+
+func TestRefreshFailureKeepsToken(t *testing.T) {
+	b, storage := getTestBackend(t)
+	enableRefreshTokens(t, b, storage)
+
+	signed := signWithRefresh(t, b, storage, map[string]interface{}{"sub": "Zapp Brannigan"})
+	refreshToken := signed.Data["refresh_token"].(string)
+
+	if resp, err := writeConfig(b, storage, map[string]interface{}{
+		keySignPolicies: []interface{}{
+			map[string]interface{}{"expression": `claims.sub != "Zapp Brannigan"`},
+		},
+	}); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	resp, err := refresh(b, storage, refreshToken)
+	if err != logical.ErrPermissionDenied {
+		t.Fatalf("expected refresh to be denied by policy, got err:%v resp:%#v\n", err, resp)
+	}
+
+	if resp, err := writeConfig(b, storage, map[string]interface{}{
+		keySignPolicies: []interface{}{},
+	}); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// The failed refresh did not use up the token, so it is not treated as reuse.
+	resp, err = refresh(b, storage, refreshToken)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+}
+
+func TestRefreshOtherCaller(t *testing.T) {
+	b, storage := getTestBackend(t)
+	enableRefreshTokens(t, b, storage)
+
+	refreshAs := func(entityID, refreshToken string) (*logical.Response, error) {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "refresh",
+			Storage:   *storage,
+			EntityID:  entityID,
+			Data: map[string]interface{}{
+				"refresh_token": refreshToken,
+			},
+		}
+		return b.HandleRequest(context.Background(), req)
+	}
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "sign",
+		Storage:   *storage,
+		EntityID:  "bender",
+		Data: map[string]interface{}{
+			"claims": map[string]interface{}{"sub": "Bender"},
+		},
+	}
+
+	signed, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (signed != nil && signed.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, signed)
+	}
+	refreshToken := signed.Data["refresh_token"].(string)
+
+	for _, entityID := range []string{"zoidberg", ""} {
+		resp, err := refreshAs(entityID, refreshToken)
+		if err != logical.ErrPermissionDenied {
+			t.Errorf("%q: expected refresh to be denied, got err:%v resp:%#v\n", entityID, err, resp)
+		}
+	}
+
+	resp, err := refreshAs("bender", refreshToken)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+}
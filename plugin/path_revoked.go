@@ -0,0 +1,54 @@
+package jwtsecrets
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathRevokedTokens(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "revoked/tokens",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathRevokedTokensRead,
+			},
+		},
+
+		HelpSynopsis:    pathRevokedTokensHelpSyn,
+		HelpDescription: pathRevokedTokensHelpDesc,
+	}
+}
+
+func (b *backend) pathRevokedTokensRead(c context.Context, r *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	revocations, err := b.getRevokedTokens(c, r.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]map[string]interface{}, len(revocations))
+	for i, revocation := range revocations {
+		tokens[i] = map[string]interface{}{
+			"jti":        revocation.JTI,
+			"expires_at": revocation.ExpiresAt.Format(time.RFC3339),
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"tokens": tokens,
+		},
+	}, nil
+}
+
+const pathRevokedTokensHelpSyn = `
+List revoked tokens.
+`
+
+const pathRevokedTokensHelpDesc = `
+List the 'jti' claims of unexpired tokens whose leases have been revoked.
+Tokens are only issued with leases if the 'lease_tokens' config option is set.
+Consumers which verify tokens locally should reject any token in this list.
+`
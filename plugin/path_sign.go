@@ -2,6 +2,10 @@ package jwtsecrets
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -9,6 +13,14 @@ import (
 	"gopkg.in/square/go-jose.v2/jwt"
 )
 
+const (
+	// tokenFormatJWT is the default format of signed tokens.
+	tokenFormatJWT = "jwt"
+
+	// tokenFormatCWT is the format of CBOR Web Tokens.
+	tokenFormatCWT = "cwt"
+)
+
 func pathSign(b *backend) *framework.Path {
 	return &framework.Path{
 		Pattern: "sign",
@@ -17,6 +29,39 @@ func pathSign(b *backend) *framework.Path {
 				Type:        framework.TypeMap,
 				Description: `JSON claim set to sign.`,
 			},
+			keyClientJWK: {
+				Type:        framework.TypeString,
+				Description: `Public JSON Web Key of the client, which the token will be bound to.`,
+			},
+			keyDPoPProof: {
+				Type:        framework.TypeString,
+				Description: `DPoP proof signed by the client, whose key the token will be bound to.`,
+			},
+			keyDPoPMethod: {
+				Type:        framework.TypeString,
+				Description: `HTTP method of the request the token is issued for. If set, must match the 'htm' claim of the DPoP proof.`,
+			},
+			keyDPoPURL: {
+				Type:        framework.TypeString,
+				Description: `URL of the request the token is issued for. If set, must match the 'htu' claim of the DPoP proof.`,
+			},
+			keyFormat: {
+				Type:        framework.TypeString,
+				Description: `Format of the signed token, either 'jwt', 'cwt', 'v4.public' or 'v3.public'.`,
+				Default:     tokenFormatJWT,
+			},
+			keyCertificateThumbprint: {
+				Type:        framework.TypeString,
+				Description: `Base64url encoded SHA-256 thumbprint of the client certificate the token will be bound to.`,
+			},
+			keyHeaders: {
+				Type:        framework.TypeMap,
+				Description: `Headers to set on the signed JWT. Only headers in 'allowed_headers' can be set.`,
+			},
+			keyDisclosable: {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `Claims which are selectively disclosable, making the token an SD-JWT.`,
+			},
 		},
 
 		Operations: map[logical.Operation]framework.OperationHandler{
@@ -30,7 +75,7 @@ func pathSign(b *backend) *framework.Path {
 	}
 }
 
-func (b *backend) pathSignWrite(_ context.Context, _ *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+func (b *backend) pathSignWrite(c context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	rawClaims, ok := d.GetOk("claims")
 	if !ok {
 		return logical.ErrorResponse("no claims provided"), logical.ErrInvalidRequest
@@ -41,17 +86,208 @@ func (b *backend) pathSignWrite(_ context.Context, _ *logical.Request, d *framew
 		return logical.ErrorResponse("claims not a map"), logical.ErrInvalidRequest
 	}
 
+	opts := signOptions{
+		format:      d.Get(keyFormat).(string),
+		disclosable: d.Get(keyDisclosable).([]string),
+	}
+
+	if rawHeaders, ok := d.GetOk(keyHeaders); ok {
+		opts.headers = rawHeaders.(map[string]interface{})
+	}
+
+	rawJWK, hasJWK := d.GetOk(keyClientJWK)
+	rawProof, hasProof := d.GetOk(keyDPoPProof)
+
+	switch {
+	case hasJWK && hasProof:
+		return logical.ErrorResponse("only one of '%s' or '%s' can be set", keyClientJWK, keyDPoPProof), logical.ErrInvalidRequest
+	case hasJWK:
+		thumbprint, err := parseClientJWK(rawJWK.(string))
+		if err != nil {
+			return logical.ErrorResponse("invalid '%s': %v", keyClientJWK, err), logical.ErrInvalidRequest
+		}
+		opts.confirmation = map[string]interface{}{"jkt": thumbprint}
+
+		if len(opts.disclosable) > 0 || opts.format == tokenFormatCWT {
+			// Holders of an SD-JWT prove possession of the key with a key binding JWT, which is verified with the key itself.
+			// CWTs have no thumbprint confirmation method, so they carry the key itself.
+			var jwk map[string]interface{}
+			if err := json.Unmarshal([]byte(rawJWK.(string)), &jwk); err != nil {
+				return logical.ErrorResponse("invalid '%s': %v", keyClientJWK, err), logical.ErrInvalidRequest
+			}
+			opts.confirmation["jwk"] = jwk
+		}
+	case hasProof:
+		proof, err := parseDPoPProof(rawProof.(string), b.clock.now())
+		if err != nil {
+			return logical.ErrorResponse("invalid DPoP proof: %v", err), logical.ErrInvalidRequest
+		}
+		if err = checkDPoPRequest(proof, d.Get(keyDPoPMethod).(string), d.Get(keyDPoPURL).(string)); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+
+		fresh, err := b.useDPoPProof(c, r.Storage, proof)
+		if err != nil {
+			return nil, err
+		}
+		if !fresh {
+			return logical.ErrorResponse("DPoP proof has already been used"), logical.ErrInvalidRequest
+		}
+		opts.confirmation = map[string]interface{}{"jkt": proof.Thumbprint}
+		if opts.format == tokenFormatCWT {
+			opts.confirmation["jwk"] = proof.Key
+		}
+	}
+
+	b.configLock.RLock()
+	bindClientCertificates := b.config.BindClientCertificates
+	allowCertificateThumbprints := b.config.AllowCertificateThumbprints
+	b.configLock.RUnlock()
+
+	thumbprint := d.Get(keyCertificateThumbprint).(string)
+	if thumbprint != "" {
+		if !allowCertificateThumbprints {
+			return logical.ErrorResponse("'%s' can only be set if '%s' is set", keyCertificateThumbprint, keyAllowCertificateThumbprints), logical.ErrInvalidRequest
+		}
+		if err := parseCertificateThumbprint(thumbprint); err != nil {
+			return logical.ErrorResponse("invalid '%s': %v", keyCertificateThumbprint, err), logical.ErrInvalidRequest
+		}
+	} else if bindClientCertificates {
+		thumbprint = connectionCertificateThumbprint(r)
+	}
+
+	if thumbprint != "" {
+		if opts.confirmation == nil {
+			opts.confirmation = make(map[string]interface{})
+		}
+		opts.confirmation[confirmationX5TS256] = thumbprint
+	}
+
+	return b.signClaims(c, r, claims, opts)
+}
+
+// signOptions control how signClaims signs a set of claims.
+type signOptions struct {
+	// refreshFamilyID is the refresh token family the returned refresh token joins. If empty, a new family is started.
+	refreshFamilyID string
+
+	// refreshFamilyIssuedAt is when the first token of the refresh token family was signed.
+	refreshFamilyIssuedAt time.Time
+
+	// noRefreshToken stops a refresh token being returned, even if refresh tokens are enabled.
+	noRefreshToken bool
+
+	// confirmation is set as the 'cnf' claim, binding the token to a key held by the client.
+	confirmation map[string]interface{}
+
+	// format is the format of the signed token, a JWT if empty.
+	format string
+
+	// headers are set by the caller on the signed JWT, in addition to the configured headers.
+	headers map[string]interface{}
+
+	// disclosable are the claims which are selectively disclosable. If any are set, the token is signed as an SD-JWT.
+	disclosable []string
+
+	// credential signs the claims as a Verifiable Credential issued by the configured CredentialIssuer. The claims
+	// are built by the backend rather than the caller, so they are not checked against the allowed claims.
+	credential bool
+}
+
+// tokenFormatAlgorithm returns the algorithm of the key used to sign a token in the given format.
+func tokenFormatAlgorithm(format string, config *Config) (jose.SignatureAlgorithm, error) {
+	switch format {
+	case "", tokenFormatJWT:
+		return config.SigningAlgorithm, nil
+	case tokenFormatCWT:
+		return cwtAlgorithms[config.CWTSigningAlgorithm], nil
+	case string(pasetoV4Public), string(pasetoV3Public):
+		return jose.SignatureAlgorithm(format), nil
+	default:
+		return "", fmt.Errorf("unknown token format %s", format)
+	}
+}
+
+// signJWT signs a set of claims as a JWT with the given headers, which can override the default 'typ' header.
+// The key ID is always set in the header.
+func signJWT(key *signingKey, config *Config, claims map[string]interface{}, headers map[string]interface{}) (string, error) {
+	options := (&jose.SignerOptions{}).WithType("JWT")
+	for header, value := range headers {
+		options = options.WithHeader(jose.HeaderKey(header), value)
+	}
+	options = options.WithHeader("kid", key.ID)
+
+	if len(key.Certificates) > 0 {
+		sha1Thumbprint, sha256Thumbprint := certificateThumbprints(key.Certificates[0])
+		if config.SetX5T {
+			options = options.WithHeader("x5t", base64.RawURLEncoding.EncodeToString(sha1Thumbprint))
+		}
+		if config.SetX5TS256 {
+			options = options.WithHeader("x5t#S256", base64.RawURLEncoding.EncodeToString(sha256Thumbprint))
+		}
+	}
+
+	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: key.Algorithm, Key: key.joseKey()}, options)
+	if err != nil {
+		return "", err
+	}
+
+	return jwt.Signed(sig).Claims(claims).CompactSerialize()
+}
+
+// signClaims validates a set of claims supplied by a caller, adds the claims generated by the backend and signs them.
+func (b *backend) signClaims(c context.Context, r *logical.Request, claims map[string]interface{}, opts signOptions) (*logical.Response, error) {
 	// Get a local copy of config, to minimize time with the lock
 	b.configLock.RLock()
 	config := *b.config
 	b.configLock.RUnlock()
 
 	for claim := range claims {
-		if allowedClaim, ok := config.allowedClaimsMap[claim]; !ok || !allowedClaim {
+		if _, ok := config.ClaimMappings[claim]; ok {
+			return logical.ErrorResponse("claim %s is mapped from the caller's identity and cannot be set", claim), logical.ErrInvalidRequest
+		}
+		if opts.credential || config.Profile == profileAccessToken && accessTokenClaims[claim] {
+			continue
+		}
+		if !config.claimAllowed(claim) {
 			return logical.ErrorResponse("claim %s not permitted", claim), logical.ErrInvalidRequest
 		}
 	}
 
+	for header := range opts.headers {
+		if allowedHeader, ok := config.allowedHeadersMap[header]; !ok || !allowedHeader {
+			return logical.ErrorResponse("header %s not permitted", header), logical.ErrInvalidRequest
+		}
+	}
+
+	// Only claims set by the caller can be selectively disclosable, not those the backend adds.
+	callerClaims := make(map[string]bool, len(claims))
+	for claim := range claims {
+		callerClaims[claim] = true
+	}
+
+	var suppliedClaims map[string]interface{}
+	if config.RefreshTokens {
+		var err error
+		if suppliedClaims, err = copyClaims(claims); err != nil {
+			return logical.ErrorResponse("error copying claims: %v", err), logical.ErrInvalidRequest
+		}
+	}
+
+	caller := b.newCallerInfo(&config, r)
+
+	mapped, err := mappedClaims(&config, caller)
+	if err != nil {
+		return logical.ErrorResponse("error mapping claims: %v", err), err
+	}
+	for claim, value := range mapped {
+		claims[claim] = value
+	}
+
+	if opts.confirmation != nil {
+		claims["cnf"] = opts.confirmation
+	}
+
 	now := b.clock.now()
 
 	expiry := now.Add(config.TokenTTL)
@@ -61,23 +297,44 @@ func (b *backend) pathSignWrite(_ context.Context, _ *logical.Request, d *framew
 		claims["iat"] = jwt.NumericDate(now.Unix())
 	}
 
-	if config.SetNBF {
+	// The issuance date and ID of a credential are always set, as they are required by the data model.
+	if config.SetNBF || opts.credential {
 		claims["nbf"] = jwt.NumericDate(now.Unix())
 	}
 
-	if config.SetJTI {
+	if config.SetJTI || config.LeaseTokens || opts.credential {
 		jti, err := b.uuidGen.uuid()
 		if err != nil {
 			return logical.ErrorResponse("could not generate 'jti' claim: %v", err), err
 		}
+		if opts.credential {
+			jti = "urn:uuid:" + jti
+		}
 		claims["jti"] = jti
 	}
 
-	if config.Issuer != "" {
-		claims["iss"] = config.Issuer
+	if opts.credential {
+		claims["iss"] = config.CredentialIssuer
+	} else if issuer := requestIssuer(&config, r); issuer != "" {
+		claims["iss"] = issuer
+	}
+
+	if _, ok := claims["aud"]; !ok && config.DefaultAudienceSource != "" {
+		aud, err := callerAudience(&config, caller)
+		if err != nil {
+			return logical.ErrorResponse("no default audience: %v", err), logical.ErrInvalidRequest
+		}
+		claims["aud"] = aud
+	}
+
+	for claim, value := range claims {
+		if denied, ok := config.deniedClaimValue(claim, value); ok {
+			return logical.ErrorResponse("claim %s cannot be %q", claim, denied), logical.ErrInvalidRequest
+		}
 	}
 
-	if rawSub, ok := claims["sub"]; ok {
+	// Under the kubernetes profile the subject is validated by its namespace and name instead.
+	if rawSub, ok := claims["sub"]; ok && config.Profile != profileKubernetes {
 		if sub, ok := rawSub.(string); ok {
 			if !config.SubjectPattern.MatchString(sub) {
 				return logical.ErrorResponse("validation of 'sub' claim failed"), logical.ErrInvalidRequest
@@ -88,6 +345,18 @@ func (b *backend) pathSignWrite(_ context.Context, _ *logical.Request, d *framew
 	}
 
 	if rawAud, ok := claims["aud"]; ok {
+		// Audiences decoded from JSON are []interface{}, so convert them before validating.
+		if auds, ok := rawAud.([]interface{}); ok {
+			converted := make([]string, len(auds))
+			for i, aud := range auds {
+				if converted[i], ok = aud.(string); !ok {
+					return logical.ErrorResponse("'aud' claim contained %T, not string", aud), logical.ErrInvalidRequest
+				}
+			}
+			rawAud = converted
+			claims["aud"] = converted
+		}
+
 		switch aud := rawAud.(type) {
 		case string:
 			if !config.AudiencePattern.MatchString(aud) {
@@ -107,26 +376,152 @@ func (b *backend) pathSignWrite(_ context.Context, _ *logical.Request, d *framew
 		}
 	}
 
-	key, err := b.getKey(expiry)
-	if err != nil {
-		return logical.ErrorResponse("error getting key: %v", err), err
+	switch config.Profile {
+	case profileSPIFFE:
+		if err := validateSPIFFEClaims(&config, claims); err != nil {
+			return logical.ErrorResponse("invalid JWT-SVID: %v", err), logical.ErrInvalidRequest
+		}
+	case profileKubernetes:
+		if err := validateKubernetesClaims(&config, claims); err != nil {
+			return logical.ErrorResponse("invalid service account token: %v", err), logical.ErrInvalidRequest
+		}
+	case profileAccessToken:
+		if err := validateAccessTokenClaims(&config, claims, now); err != nil {
+			return logical.ErrorResponse("invalid access token: %v", err), logical.ErrInvalidRequest
+		}
 	}
 
-	sig, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key.Key}, (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", key.ID))
+	if err := checkSignPolicies(&config, r, caller, claims); err != nil {
+		return logical.ErrorResponse("claims denied by policy: %v", err), logical.ErrPermissionDenied
+	}
+
+	algorithm, err := tokenFormatAlgorithm(opts.format, &config)
 	if err != nil {
-		return logical.ErrorResponse("error signing claims: %v", err), err
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	if len(opts.headers) > 0 && !isJWSAlgorithm(algorithm) {
+		return logical.ErrorResponse("headers can only be set on JWTs"), logical.ErrInvalidRequest
+	}
+
+	if config.Profile != "" && !isJWSAlgorithm(algorithm) {
+		return logical.ErrorResponse("tokens cannot be signed in the %s format under the %s profile", opts.format, config.Profile), logical.ErrInvalidRequest
+	}
+
+	var disclosures []string
+	if len(opts.disclosable) > 0 {
+		if config.Profile != "" {
+			return logical.ErrorResponse("selectively disclosable claims cannot be signed under the %s profile", config.Profile), logical.ErrInvalidRequest
+		}
+
+		if !isJWSAlgorithm(algorithm) {
+			return logical.ErrorResponse("selectively disclosable claims can only be signed as SD-JWTs"), logical.ErrInvalidRequest
+		}
+
+		if disclosures, err = discloseClaims(claims, callerClaims, opts.disclosable); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
 	}
 
-	token, err := jwt.Signed(sig).Claims(claims).CompactSerialize()
+	key, err := b.getKey(algorithm, expiry)
 	if err != nil {
-		return logical.ErrorResponse("error serializing jwt: %v", err), err
+		return logical.ErrorResponse("error getting key: %v", err), err
 	}
 
-	return &logical.Response{
-		Data: map[string]interface{}{
-			"token": token,
-		},
-	}, nil
+	var token string
+	if isPASETOAlgorithm(key.Algorithm) {
+		if token, err = signPASETO(key, claims); err != nil {
+			return logical.ErrorResponse("error signing claims: %v", err), err
+		}
+	} else if isCOSEAlgorithm(key.Algorithm) {
+		if token, err = signCWT(key, claims); err != nil {
+			return logical.ErrorResponse("error signing claims: %v", err), err
+		}
+	} else {
+		headers := make(map[string]interface{}, len(config.Headers)+len(opts.headers))
+		for header, value := range config.Headers {
+			headers[header] = value
+		}
+		for header, value := range opts.headers {
+			headers[header] = value
+		}
+		if config.Profile == profileAccessToken {
+			headers["typ"] = accessTokenType
+		}
+
+		// The 'kid' header of a credential is the ID of the key's verification method in the DID document,
+		// so verifiers can resolve it.
+		if opts.credential {
+			didKey := *key
+			didKey.ID = config.CredentialIssuer + "#" + key.ID
+			key = &didKey
+		}
+
+		if token, err = signJWT(key, &config, claims, headers); err != nil {
+			return logical.ErrorResponse("error signing claims: %v", err), err
+		}
+	}
+
+	data := map[string]interface{}{
+		"token": token,
+	}
+
+	if disclosures != nil {
+		data["disclosures"] = disclosures
+		data["sd_jwt"] = combineSDJWT(token, disclosures)
+	}
+
+	if config.RefreshTokens && !opts.noRefreshToken {
+		familyID, familyIssuedAt := opts.refreshFamilyID, opts.refreshFamilyIssuedAt
+		if familyID == "" {
+			if familyID, err = b.uuidGen.uuid(); err != nil {
+				return logical.ErrorResponse("could not generate refresh token family: %v", err), err
+			}
+			familyIssuedAt = now
+		}
+
+		// Refreshing does not extend the lifetime of the family.
+		expiresAt := now.Add(config.RefreshTokenTTL)
+		if familyExpiresAt := familyIssuedAt.Add(config.RefreshMaxTTL); familyExpiresAt.Before(expiresAt) {
+			expiresAt = familyExpiresAt
+		}
+
+		issued := &refreshToken{
+			Claims:         suppliedClaims,
+			Confirmation:   opts.confirmation,
+			Format:         opts.format,
+			Disclosable:    opts.disclosable,
+			Headers:        opts.headers,
+			FamilyID:       familyID,
+			FamilyIssuedAt: familyIssuedAt,
+			EntityID:       r.EntityID,
+			ExpiresAt:      expiresAt,
+		}
+		if r.EntityID == "" {
+			issued.TokenAccessor = r.ClientTokenAccessor
+		}
+
+		refresh, err := b.issueRefreshToken(c, r.Storage, issued)
+		if err != nil {
+			return logical.ErrorResponse("error issuing refresh token: %v", err), err
+		}
+		data[keyRefreshToken] = refresh
+	}
+
+	// Credentials outlive any lease, so are never leased.
+	if !config.LeaseTokens || opts.credential {
+		return &logical.Response{
+			Data: data,
+		}, nil
+	}
+
+	resp := b.Secret(secretTypeToken).Response(data, map[string]interface{}{
+		"jti":        claims["jti"],
+		"expires_at": expiry.Format(time.RFC3339),
+	})
+	resp.Secret.TTL = config.TokenTTL
+
+	return resp, nil
 }
 
 const pathSignHelpSyn = `
@@ -135,4 +530,31 @@ Sign a set of claims.
 
 const pathSignHelpDesc = `
 Sign a set of claims.
+
+claims:     JSON claim set to sign.
+format:     Format of the signed token. Either 'jwt', or 'v4.public' or 'v3.public' for a PASETO
+            token signed with Ed25519 or P-384. PASETO tokens carry the key ID in their footer,
+            and are verified with the keys published by the paserk endpoint.
+            If 'cwt', a CBOR Web Token signed as a base64url encoded COSE_Sign1 structure with the
+            'cwt_signing_algorithm', verified with the keys published by the cose_keys endpoint.
+            A CWT bound to a client key carries the key as a COSE_Key in its 'cnf' claim, and CWTs
+            cannot be bound to client certificates.
+jwk:        Public JSON Web Key of the client. The token is bound to the key by its thumbprint
+            in the 'cnf' claim, and can only be verified alongside a DPoP proof signed by the key.
+dpop_proof: DPoP proof signed by the client, which binds the token to the key in its 'jwk' header.
+            Each proof can only be used once.
+dpop_method:
+            HTTP method of the request the token is issued for, checked against the proof.
+dpop_url:   URL of the request the token is issued for, checked against the proof.
+certificate_thumbprint:
+            Base64url encoded SHA-256 thumbprint of a client certificate to bind the token to.
+            Only permitted if 'allow_certificate_thumbprints' is set. If 'bind_client_certificates'
+            is set, the token is bound to the certificate the request was made with instead.
+headers:    Headers to set on the signed JWT, overriding the configured headers. Only headers in
+            'allowed_headers' can be set, and only on JWTs.
+disclosable:
+            Claims which are selectively disclosable. Each is replaced by the digest of a salted
+            disclosure in the '_sd' claim, and the disclosures are returned alongside the token, both
+            separately and combined with it as 'sd_jwt'. Only claims set in 'claims' can be disclosable.
+            If 'jwk' is also set, the key is included in the 'cnf' claim for key binding.
 `
@@ -7,10 +7,29 @@ import (
 
 	"github.com/go-test/deep"
 	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2"
 	"gopkg.in/square/go-jose.v2/jwt"
 )
 
 func getSignedToken(b *backend, storage *logical.Storage, claims map[string]interface{}, dest interface{}) error {
+	strToken, err := getRawToken(b, storage, claims)
+	if err != nil {
+		return err
+	}
+
+	token, err := jwt.ParseSigned(strToken)
+	if err != nil {
+		return fmt.Errorf("error parsing jwt: %s", err)
+	}
+
+	if err = token.Claims(b.keys[0].Key.Public(), dest); err != nil {
+		return fmt.Errorf("error decoding claims: %s", err)
+	}
+
+	return nil
+}
+
+func getRawToken(b *backend, storage *logical.Storage, claims map[string]interface{}) (string, error) {
 	data := map[string]interface{}{
 		"claims": claims,
 	}
@@ -24,29 +43,20 @@ func getSignedToken(b *backend, storage *logical.Storage, claims map[string]inte
 
 	resp, err := b.HandleRequest(context.Background(), req)
 	if err != nil || (resp != nil && resp.IsError()) {
-		return fmt.Errorf("err:%s resp:%#v", err, resp)
+		return "", fmt.Errorf("err:%s resp:%#v", err, resp)
 	}
 
 	rawToken, ok := resp.Data["token"]
 	if !ok {
-		return fmt.Errorf("no returned token")
+		return "", fmt.Errorf("no returned token")
 	}
 
 	strToken, ok := rawToken.(string)
 	if !ok {
-		return fmt.Errorf("Token was %T, not a string", rawToken)
-	}
-
-	token, err := jwt.ParseSigned(strToken)
-	if err != nil {
-		return fmt.Errorf("error parsing jwt: %s", err)
-	}
-
-	if err = token.Claims(b.keys[0].Key.Public(), dest); err != nil {
-		return fmt.Errorf("error decoding claims: %s", err)
+		return "", fmt.Errorf("Token was %T, not a string", rawToken)
 	}
 
-	return nil
+	return strToken, nil
 }
 
 func TestSign(t *testing.T) {
@@ -125,3 +135,213 @@ func TestRejectReservedClaims(t *testing.T) {
 		t.Fatalf("expected to get an error from sign. got:%v\n", resp)
 	}
 }
+
+func signWithHeaders(b *backend, storage *logical.Storage, data map[string]interface{}) (*jose.JSONWebSignature, error) {
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "sign",
+		Storage:   *storage,
+		Data:      data,
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		return nil, fmt.Errorf("err:%s resp:%#v", err, resp)
+	}
+
+	return jose.ParseSigned(resp.Data["token"].(string))
+}
+
+func TestSignWithHeaders(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			keyHeaders: map[string]interface{}{
+				"typ": "at+jwt",
+				"jku": "https://vault.example.com/v1/jwt/jwks",
+			},
+			keyAllowedHeaders: "cty,typ",
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	parsed, err := signWithHeaders(b, storage, map[string]interface{}{
+		"claims": map[string]interface{}{"sub": "Zapp Brannigan"},
+	})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	expected := map[jose.HeaderKey]interface{}{
+		"typ": "at+jwt",
+		"jku": "https://vault.example.com/v1/jwt/jwks",
+	}
+
+	if diff := deep.Equal(expected, parsed.Signatures[0].Header.ExtraHeaders); diff != nil {
+		t.Error(diff)
+	}
+
+	if diff := deep.Equal(b.keys[0].ID, parsed.Signatures[0].Header.KeyID); diff != nil {
+		t.Error(diff)
+	}
+
+	// Headers set by the caller override the configured headers.
+	parsed, err = signWithHeaders(b, storage, map[string]interface{}{
+		"claims":  map[string]interface{}{"sub": "Zapp Brannigan"},
+		"headers": map[string]interface{}{"typ": "dpop+jwt", "cty": "delivery"},
+	})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	expected = map[jose.HeaderKey]interface{}{
+		"typ": "dpop+jwt",
+		"cty": "delivery",
+		"jku": "https://vault.example.com/v1/jwt/jwks",
+	}
+
+	if diff := deep.Equal(expected, parsed.Signatures[0].Header.ExtraHeaders); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestRejectHeaders(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			keyAllowedHeaders: "cty,kid,jku",
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	rejected := map[string]map[string]interface{}{
+		"not allowed":     {"claims": map[string]interface{}{}, "headers": map[string]interface{}{"typ": "at+jwt"}},
+		"reserved kid":    {"claims": map[string]interface{}{}, "headers": map[string]interface{}{"kid": "Nibbler"}},
+		"reserved jku":    {"claims": map[string]interface{}{}, "headers": map[string]interface{}{"jku": "https://example.com"}},
+		"not a JWT":       {"claims": map[string]interface{}{}, "headers": map[string]interface{}{"cty": "delivery"}, "format": "cwt"},
+		"reserved in map": {"claims": map[string]interface{}{}, "headers": map[string]interface{}{"alg": "none"}},
+	}
+
+	for name, data := range rejected {
+		if _, err := signWithHeaders(b, storage, data); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			keyHeaders: map[string]interface{}{"alg": "none"},
+		},
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err == nil || resp != nil && !resp.IsError() {
+		t.Errorf("expected an error configuring the 'alg' header, got %#v", resp)
+	}
+}
+
+func TestAllowedClaimGlobsAndPatterns(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if resp, err := writeConfig(b, storage, map[string]interface{}{
+		keyAllowedClaims:        []string{"aud", "sub", "https://planetexpress.com/claims/*", "*"},
+		keyAllowedClaimPatterns: []string{`^crew_[a-z]+$`},
+	}); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+
+	allowed := []string{
+		"https://planetexpress.com/claims/rank",
+		"https://planetexpress.com/claims/ship/name",
+		"crew_captain",
+		"anything",
+	}
+
+	for _, claim := range allowed {
+		if _, err := getRawToken(b, storage, map[string]interface{}{claim: "Leela"}); err != nil {
+			t.Errorf("%s: %v", claim, err)
+		}
+	}
+
+	// Reserved claims can never be set, even if they match a glob.
+	for _, claim := range []string{"exp", "iss", "_sd"} {
+		if _, err := getRawToken(b, storage, map[string]interface{}{claim: "Leela"}); err == nil {
+			t.Errorf("%s: expected error", claim)
+		}
+	}
+
+	if resp, err := writeConfig(b, storage, map[string]interface{}{
+		keyAllowedClaims: []string{"https://planetexpress.com/claims/*"},
+	}); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+
+	for _, claim := range []string{"https://momcorp.com/claims/rank", "crew-captain", "anything"} {
+		if _, err := getRawToken(b, storage, map[string]interface{}{claim: "Leela"}); err == nil {
+			t.Errorf("%s: expected error", claim)
+		}
+	}
+}
+
+func TestDeniedClaimValues(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if resp, err := writeConfig(b, storage, map[string]interface{}{
+		keyDeniedClaimValues: map[string]interface{}{
+			"sub": []interface{}{"admin", "root"},
+			"aud": "Mom's Friendly Robot Company",
+		},
+		keySubjectPattern: "^[a-z]+$",
+	}); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+
+	if _, err := getRawToken(b, storage, map[string]interface{}{"sub": "bender", "aud": "Planet Express"}); err != nil {
+		t.Error(err)
+	}
+
+	denied := map[string]map[string]interface{}{
+		`claim sub cannot be "admin"`:                        {"sub": "admin"},
+		`claim aud cannot be "Mom's Friendly Robot Company"`: {"aud": []interface{}{"Planet Express", "Mom's Friendly Robot Company"}},
+	}
+
+	for message, claims := range denied {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "sign",
+			Storage:   *storage,
+			Data: map[string]interface{}{
+				"claims": claims,
+			},
+		}
+
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err == nil || resp == nil || !resp.IsError() {
+			t.Errorf("%v: expected error", claims)
+			continue
+		}
+
+		if diff := deep.Equal(message, resp.Data["error"]); diff != nil {
+			t.Error(diff)
+		}
+	}
+}
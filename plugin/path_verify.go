@@ -0,0 +1,192 @@
+package jwtsecrets
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func pathVerify(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "verify",
+		Fields: map[string]*framework.FieldSchema{
+			"token": {
+				Type:        framework.TypeString,
+				Description: `JWT to verify.`,
+			},
+			keyDPoPProof: {
+				Type:        framework.TypeString,
+				Description: `DPoP proof presented with the token. Required if the token is bound to a key.`,
+			},
+			keyDPoPMethod: {
+				Type:        framework.TypeString,
+				Description: `HTTP method of the request the token was presented with. If set, must match the 'htm' claim of the DPoP proof.`,
+			},
+			keyDPoPURL: {
+				Type:        framework.TypeString,
+				Description: `URL of the request the token was presented with. If set, must match the 'htu' claim of the DPoP proof.`,
+			},
+			keyClientCertificate: {
+				Type:        framework.TypeString,
+				Description: `PEM encoded client certificate the token was presented with. Defaults to the certificate this request was made with.`,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathVerifyWrite,
+			},
+		},
+
+		HelpSynopsis:    pathVerifyHelpSyn,
+		HelpDescription: pathVerifyHelpDesc,
+	}
+}
+
+func (b *backend) pathVerifyWrite(c context.Context, r *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	rawToken, ok := d.GetOk("token")
+	if !ok {
+		return logical.ErrorResponse("no token provided"), logical.ErrInvalidRequest
+	}
+
+	token, err := b.verifyToken(c, r.Storage, rawToken.(string))
+	if err == nil {
+		err = b.checkDPoPBinding(c, r.Storage, rawToken.(string), token, d.Get(keyDPoPProof).(string), d.Get(keyDPoPMethod).(string), d.Get(keyDPoPURL).(string))
+	}
+	if err == nil {
+		err = checkCertificateBinding(r, token, d.Get(keyClientCertificate).(string))
+	}
+	if invalid, ok := err.(*invalidTokenError); ok {
+		return logical.ErrorResponse(invalid.message), invalid.code
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"claims": token.Claims,
+		},
+	}, nil
+}
+
+// verifiedToken is a token whose signature and claims have been verified.
+type verifiedToken struct {
+	// KeyID is the ID of the key which signed the token.
+	KeyID string
+
+	// StandardClaims holds the registered claims of the token.
+	StandardClaims jwt.Claims
+
+	// Claims holds every claim of the token.
+	Claims map[string]interface{}
+}
+
+// invalidTokenError is returned when a token fails verification, as opposed to verification being unable to complete.
+type invalidTokenError struct {
+	message string
+	code    error
+}
+
+func (e *invalidTokenError) Error() string {
+	return e.message
+}
+
+func invalidToken(code error, format string, args ...interface{}) *invalidTokenError {
+	return &invalidTokenError{
+		message: fmt.Sprintf(format, args...),
+		code:    code,
+	}
+}
+
+// verifyToken checks that a token was signed by a current, unrevoked key, is within its validity period and
+// has not been revoked. If the token is invalid the error is an *invalidTokenError.
+func (b *backend) verifyToken(c context.Context, s logical.Storage, rawToken string) (*verifiedToken, error) {
+	token, err := jwt.ParseSigned(rawToken)
+	if err != nil {
+		return nil, invalidToken(logical.ErrInvalidRequest, "error parsing token: %v", err)
+	}
+
+	var kid string
+	for _, header := range token.Headers {
+		if header.KeyID != "" {
+			kid = header.KeyID
+			break
+		}
+	}
+
+	if kid == "" {
+		return nil, invalidToken(logical.ErrInvalidRequest, "no 'kid' header set")
+	}
+
+	// Credentials are signed with the ID of the key's verification method in the DID document.
+	b.configLock.RLock()
+	did := b.config.CredentialIssuer
+	b.configLock.RUnlock()
+	if did != "" {
+		kid = strings.TrimPrefix(kid, did+"#")
+	}
+
+	revocation, err := b.getKeyRevocation(c, s, kid)
+	if err != nil {
+		return nil, err
+	}
+	if revocation != nil {
+		return nil, invalidToken(logical.ErrPermissionDenied, "key %s has been revoked", kid)
+	}
+
+	key := b.getKeyByID(kid)
+	if key == nil {
+		return nil, invalidToken(logical.ErrInvalidRequest, "no key with ID %s", kid)
+	}
+
+	verified := &verifiedToken{
+		KeyID:  kid,
+		Claims: make(map[string]interface{}),
+	}
+
+	if err = token.Claims(key.verificationKey(), &verified.StandardClaims, &verified.Claims); err != nil {
+		return nil, invalidToken(logical.ErrInvalidRequest, "error verifying token: %v", err)
+	}
+
+	// Tokens are checked against the same clock which issued them, so no leeway is needed.
+	if err = verified.StandardClaims.ValidateWithLeeway(jwt.Expected{Time: b.clock.now()}, 0); err != nil {
+		return nil, invalidToken(logical.ErrInvalidRequest, "error validating claims: %v", err)
+	}
+
+	if verified.StandardClaims.ID != "" {
+		revoked, err := b.isTokenRevoked(c, s, verified.StandardClaims.ID)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, invalidToken(logical.ErrPermissionDenied, "token has been revoked")
+		}
+	}
+
+	return verified, nil
+}
+
+const pathVerifyHelpSyn = `
+Verify a JWT signed by this backend.
+`
+
+const pathVerifyHelpDesc = `
+Verify the signature and validity period of a JWT signed by this backend, returning its claims.
+Tokens signed by revoked keys, and tokens whose leases have been revoked, are rejected.
+
+token:       JWT to verify.
+dpop_proof:  DPoP proof presented with the token. Tokens bound to a key by their 'cnf' claim
+             are only accepted with a fresh, unused proof signed by that key for this token.
+dpop_method: HTTP method of the request the token was presented with, checked against the proof.
+dpop_url:    URL of the request the token was presented with, checked against the proof.
+client_certificate:
+             PEM encoded client certificate the token was presented with. Tokens bound to a certificate
+             are only accepted with that certificate. Defaults to the certificate this request was made with,
+             which is only available when the backend runs inside Vault, as the TLS connection state is
+             not passed to plugins served out of process.
+`
@@ -0,0 +1,72 @@
+package jwtsecrets
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func verifyToken(b *backend, storage *logical.Storage, token string) (*logical.Response, error) {
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "verify",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			"token": token,
+		},
+	}
+
+	return b.HandleRequest(context.Background(), req)
+}
+
+func TestVerify(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	token, err := getRawToken(b, storage, map[string]interface{}{"sub": "Zapp Brannigan"})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err := verifyToken(b, storage, token)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	claims := resp.Data["claims"].(map[string]interface{})
+	if diff := deep.Equal("Zapp Brannigan", claims["sub"]); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestVerifyExpired(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	token, err := getRawToken(b, storage, map[string]interface{}{"sub": "Zapp Brannigan"})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	b.clock = &fakeClock{time.Unix(0, 0).Add(time.Hour)}
+
+	resp, err := verifyToken(b, storage, token)
+	if err == nil || resp != nil && !resp.IsError() {
+		t.Fatalf("expected to get an error from verify. got:%v\n", resp)
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	token, err := getRawToken(b, storage, map[string]interface{}{"sub": "Zapp Brannigan"})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err := verifyToken(b, storage, token[:len(token)-4]+"AAAA")
+	if err == nil || resp != nil && !resp.IsError() {
+		t.Fatalf("expected to get an error from verify. got:%v\n", resp)
+	}
+}
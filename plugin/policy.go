@@ -0,0 +1,209 @@
+package jwtsecrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/ext"
+	"github.com/google/cel-go/interpreter"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	keyPolicyExpression = "expression"
+	keyPolicyMessage    = "message"
+)
+
+// signPolicy is a CEL expression which must evaluate to true for a set of claims to be signed.
+type signPolicy struct {
+	// Expression is evaluated over the claims, the caller's entity and groups, and the request.
+	Expression string
+
+	// Message is returned to the caller when the expression is false. If empty, the expression is returned.
+	Message string
+
+	program cel.Program
+}
+
+// newPolicyEnv returns the environment sign policies are compiled in. Policies can refer to:
+//
+//	claims:  the claims being signed, as they are encoded in the token.
+//	entity:  the caller's entity, with its 'id', 'name', 'metadata' and 'aliases', or an empty map.
+//	groups:  the names of the groups the caller's entity is a member of, only looked up if used.
+//	request: the 'path', 'mount_point', 'display_name', 'entity_id' and 'remote_addr' of the request, and the
+//	         configured 'namespace' of the mount.
+func newPolicyEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("claims", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("entity", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("groups", cel.ListType(cel.StringType)),
+		cel.Variable("request", cel.MapType(cel.StringType, cel.StringType)),
+		ext.Strings(),
+	)
+}
+
+// parseSignPolicies compiles a list of sign policies, each a map with an expression and an optional message.
+func parseSignPolicies(raw []interface{}) ([]*signPolicy, error) {
+	env, err := newPolicyEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	policies := make([]*signPolicy, 0, len(raw))
+	for i, rawPolicy := range raw {
+		fields, ok := rawPolicy.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("policy %d is not a map", i)
+		}
+
+		policy := new(signPolicy)
+		if policy.Expression, ok = fields[keyPolicyExpression].(string); !ok || policy.Expression == "" {
+			return nil, fmt.Errorf("policy %d has no expression", i)
+		}
+		if rawMessage, ok := fields[keyPolicyMessage]; ok {
+			if policy.Message, ok = rawMessage.(string); !ok {
+				return nil, fmt.Errorf("message of policy %d is not a string", i)
+			}
+		}
+
+		ast, issues := env.Compile(policy.Expression)
+		if issues != nil && issues.Err() != nil {
+			return nil, fmt.Errorf("invalid policy %q: %v", policy.Expression, issues.Err())
+		}
+		if t := ast.OutputType(); t != cel.BoolType && t != cel.DynType {
+			return nil, fmt.Errorf("policy %q is %v, not bool", policy.Expression, t)
+		}
+
+		if policy.program, err = env.Program(ast); err != nil {
+			return nil, fmt.Errorf("invalid policy %q: %v", policy.Expression, err)
+		}
+
+		policies = append(policies, policy)
+	}
+
+	return policies, nil
+}
+
+// signPoliciesData returns sign policies in the form they are configured in.
+func signPoliciesData(policies []*signPolicy) []map[string]interface{} {
+	data := make([]map[string]interface{}, 0, len(policies))
+	for _, policy := range policies {
+		data = append(data, map[string]interface{}{
+			keyPolicyExpression: policy.Expression,
+			keyPolicyMessage:    policy.Message,
+		})
+	}
+	return data
+}
+
+// checkSignPolicies evaluates every sign policy in order, returning the message of the first which is false
+// as an error. Policies which cannot be evaluated, such as those referring to claims which are not set, also fail.
+func checkSignPolicies(config *Config, r *logical.Request, caller *callerInfo, claims map[string]interface{}) error {
+	if len(config.SignPolicies) == 0 {
+		return nil
+	}
+
+	// Policies see claims as they are encoded in the token, so numeric dates are numbers.
+	encoded, err := json.Marshal(claims)
+	if err != nil {
+		return err
+	}
+	var claimsData map[string]interface{}
+	if err := json.Unmarshal(encoded, &claimsData); err != nil {
+		return err
+	}
+
+	entity, err := caller.getEntity()
+	if err != nil {
+		return err
+	}
+
+	entityData := make(map[string]interface{})
+	if entity != nil {
+		aliases := make([]interface{}, 0, len(entity.Aliases))
+		for _, alias := range entity.Aliases {
+			aliases = append(aliases, map[string]interface{}{
+				"mount_accessor": alias.MountAccessor,
+				"mount_type":     alias.MountType,
+				"name":           alias.Name,
+				"metadata":       stringMap(alias.Metadata),
+			})
+		}
+
+		entityData["id"] = entity.ID
+		entityData["name"] = entity.Name
+		entityData["metadata"] = stringMap(entity.Metadata)
+		entityData["aliases"] = aliases
+	}
+
+	var remoteAddr string
+	if r.Connection != nil {
+		remoteAddr = r.Connection.RemoteAddr
+	}
+
+	activation, err := interpreter.NewActivation(map[string]interface{}{
+		"claims": claimsData,
+		"entity": entityData,
+		// Groups are only looked up if a policy uses them, and at most once for the request.
+		"groups": func() ref.Val {
+			return policyGroups(caller)
+		},
+		"request": map[string]string{
+			"path":         r.Path,
+			"mount_point":  r.MountPoint,
+			"namespace":    config.Namespace,
+			"display_name": r.DisplayName,
+			"entity_id":    r.EntityID,
+			"remote_addr":  remoteAddr,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, policy := range config.SignPolicies {
+		result, _, err := policy.program.Eval(activation)
+		if err != nil {
+			return fmt.Errorf("error evaluating policy %q: %v", policy.Expression, err)
+		}
+
+		allowed, ok := result.Value().(bool)
+		if !ok {
+			return fmt.Errorf("policy %q evaluated to %v, not bool", policy.Expression, result.Type())
+		}
+		if !allowed {
+			if policy.Message != "" {
+				return errors.New(policy.Message)
+			}
+			return fmt.Errorf("policy %q is false", policy.Expression)
+		}
+	}
+
+	return nil
+}
+
+// policyGroups returns the names of the groups the caller's entity is a member of as a CEL value, or an error value
+// if they cannot be looked up.
+func policyGroups(caller *callerInfo) ref.Val {
+	names, err := caller.getGroups()
+	if err != nil {
+		return types.NewErr(err.Error())
+	}
+	if names == nil {
+		names = []string{}
+	}
+
+	return types.DefaultTypeAdapter.NativeToValue(names)
+}
+
+// stringMap converts a map of strings for use in a CEL activation, treating a nil map as empty.
+func stringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return map[string]string{}
+	}
+	return m
+}
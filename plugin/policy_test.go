@@ -0,0 +1,216 @@
+package jwtsecrets
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestParseSignPolicies(t *testing.T) {
+	valid := []interface{}{
+		map[string]interface{}{"expression": `claims.sub == entity.name || "admin" in groups`, "message": "not your subject"},
+		map[string]interface{}{"expression": `claims.aud.all(a, a in entity.metadata.audiences.split(","))`},
+		map[string]interface{}{"expression": `request.namespace == ""`},
+	}
+
+	policies, err := parseSignPolicies(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := deep.Equal(3, len(policies)); diff != nil {
+		t.Error(diff)
+	}
+
+	invalid := []interface{}{
+		`claims.sub == "Bender"`,
+		map[string]interface{}{"message": "no expression"},
+		map[string]interface{}{"expression": `claims.sub ==`},
+		map[string]interface{}{"expression": `1 + 1`},
+		map[string]interface{}{"expression": `robots.all(r, r.bender)`},
+		map[string]interface{}{"expression": `true`, "message": 42},
+	}
+
+	for _, policy := range invalid {
+		if _, err := parseSignPolicies([]interface{}{policy}); err == nil {
+			t.Errorf("%v: expected error", policy)
+		}
+	}
+}
+
+func TestSignPolicies(t *testing.T) {
+	server := fakeIdentity(t)
+	defer server.Close()
+
+	b, storage := getTestBackend(t)
+
+	if resp, err := writeConfig(b, storage, map[string]interface{}{
+		keyAPIAddr:       server.URL,
+		keyIdentityToken: testIdentityToken,
+		keySignPolicies: []interface{}{
+			map[string]interface{}{
+				"expression": `claims.sub == entity.name || "admin" in groups`,
+				"message":    "subject must be the caller's entity",
+			},
+			map[string]interface{}{
+				"expression": `claims.aud.all(a, a in entity.metadata.audiences.split(","))`,
+				"message":    "audiences must be in the entity's audiences",
+			},
+		},
+	}); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+
+	decoded, err := signMapped(b, storage, map[string]interface{}{
+		"sub": testEntity.Name,
+		"aud": []interface{}{"planet-express", "mom-corp"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := deep.Equal(testEntity.Name, decoded["sub"]); diff != nil {
+		t.Error(diff)
+	}
+
+	denied := map[string]map[string]interface{}{
+		"claims denied by policy: subject must be the caller's entity": {
+			"sub": "Zoidberg",
+			"aud": "planet-express",
+		},
+		"claims denied by policy: audiences must be in the entity's audiences": {
+			"sub": testEntity.Name,
+			"aud": []interface{}{"planet-express", "nimbus"},
+		},
+	}
+
+	for message, claims := range denied {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "sign",
+			Storage:   *storage,
+			EntityID:  testEntity.ID,
+			Data: map[string]interface{}{
+				"claims": claims,
+			},
+		}
+
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != logical.ErrPermissionDenied {
+			t.Errorf("%v: expected permission denied, got %v", claims, err)
+			continue
+		}
+
+		if diff := deep.Equal(message, resp.Data["error"]); diff != nil {
+			t.Error(diff)
+		}
+	}
+}
+
+func TestSignPoliciesLookUpGroupsOnce(t *testing.T) {
+	identity := fakeIdentity(t)
+	defer identity.Close()
+
+	lookups := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/identity/entity/id/"+testEntity.ID {
+			lookups++
+		}
+		identity.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	b, storage := getTestBackend(t)
+
+	// Groups are used by both a claim mapping and the sign policies.
+	if resp, err := writeConfig(b, storage, map[string]interface{}{
+		keyAPIAddr:       server.URL,
+		keyIdentityToken: testIdentityToken,
+		keyClaimMappings: map[string]interface{}{"groups": "entity.groups.names"},
+		keySignPolicies: []interface{}{
+			map[string]interface{}{"expression": `"planet-express" in groups`},
+			map[string]interface{}{"expression": `groups.size() == 2`},
+		},
+	}); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+
+	decoded, err := signMapped(b, storage, map[string]interface{}{"sub": testEntity.Name})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := deep.Equal([]interface{}{"delivery-crew", "planet-express"}, decoded["groups"]); diff != nil {
+		t.Error(diff)
+	}
+
+	if diff := deep.Equal(1, lookups); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestSignPolicyNamespace(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if resp, err := writeConfig(b, storage, map[string]interface{}{
+		keyNamespace: "planet-express",
+		keySignPolicies: []interface{}{
+			map[string]interface{}{"expression": `request.namespace == "planet-express"`},
+		},
+	}); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+
+	// The namespace is the configured namespace of the mount, not the one named by the caller.
+	if _, err := signAs(b, storage, map[string]interface{}{"sub": "Hermes"}, func(r *logical.Request) {
+		r.Headers = map[string][]string{"X-Vault-Namespace": {"mom-corp"}}
+	}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSignPoliciesFailClosed(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	if resp, err := writeConfig(b, storage, map[string]interface{}{
+		keySignPolicies: []interface{}{
+			map[string]interface{}{"expression": `claims.sub.startsWith("Planet Express")`},
+		},
+	}); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+
+	// The policy cannot be evaluated without a 'sub' claim.
+	if _, err := getRawToken(b, storage, map[string]interface{}{"aud": "Kif Kroker"}); err == nil {
+		t.Error("expected error signing claims without a subject")
+	}
+
+	if _, err := getRawToken(b, storage, map[string]interface{}{"sub": "Planet Express Ship"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReadSignPolicies(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	policies := []interface{}{
+		map[string]interface{}{"expression": `claims.sub != "admin"`, "message": "no admins"},
+	}
+
+	resp, err := writeConfig(b, storage, map[string]interface{}{keySignPolicies: policies})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+
+	expected := []map[string]interface{}{
+		{"expression": `claims.sub != "admin"`, "message": "no admins"},
+	}
+
+	if diff := deep.Equal(expected, resp.Data[keySignPolicies]); diff != nil {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,67 @@
+package jwtsecrets
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Names of the available key providers.
+const (
+	keyProviderLocal   = "local"
+	keyProviderTransit = "transit"
+	keyProviderPKCS11  = "pkcs11"
+)
+
+// keyProvider creates the keys used to sign tokens.
+type keyProvider interface {
+	// newKey creates a new signing key, returning its ID and a signer which uses it.
+	newKey() (string, crypto.Signer, error)
+
+	// close releases the provider's resources once it has been replaced. Keys it created can no longer sign.
+	close() error
+}
+
+// destroyableSigner is implemented by signers whose key is held by a provider which can destroy it once it has been
+// removed from the key set.
+type destroyableSigner interface {
+	destroy() error
+}
+
+// newKeyProvider creates the key provider selected by the config.
+func newKeyProvider(config *Config) (keyProvider, error) {
+	switch config.KeyProvider {
+	case keyProviderLocal:
+		return localKeyProvider{}, nil
+	case keyProviderTransit:
+		return newTransitKeyProvider(config)
+	case keyProviderPKCS11:
+		return newPKCS11KeyProvider(config)
+	default:
+		return nil, fmt.Errorf("unknown key provider %q", config.KeyProvider)
+	}
+}
+
+// localKeyProvider generates RSA keys which are held in memory by the backend.
+type localKeyProvider struct{}
+
+func (localKeyProvider) newKey() (string, crypto.Signer, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", nil, err
+	}
+
+	kid, err := uuid.NewRandom()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return kid.String(), privateKey, nil
+}
+
+func (localKeyProvider) close() error {
+	return nil
+}
@@ -0,0 +1,273 @@
+//go:build cgo
+// +build cgo
+
+package jwtsecrets
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/miekg/pkcs11"
+)
+
+// pkcs11KeyLabelPrefix is prepended to the ID of each key to form the label of its objects on the token.
+const pkcs11KeyLabelPrefix = "vault-plugin-secrets-jwt:"
+
+// digestInfoPrefixes are the DER encoded DigestInfo prefixes which must be prepended to a digest
+// before it is signed with the CKM_RSA_PKCS mechanism.
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// pkcs11Modules counts the providers using each loaded module, which is finalized once none are. Modules are
+// initialized once per process, so finalizing a module still in use would end every other provider's session.
+var pkcs11Modules = struct {
+	sync.Mutex
+	refs map[string]int
+}{refs: make(map[string]int)}
+
+// pkcs11KeyProvider generates RSA keys on a PKCS#11 token. Private keys never leave the token.
+type pkcs11KeyProvider struct {
+	// lock serializes use of the session, which PKCS#11 does not allow to be used concurrently.
+	lock    sync.Mutex
+	module  string
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+
+	// objects are the handles of the public and private key objects of each key on the token, by key ID.
+	objects map[string][]pkcs11.ObjectHandle
+
+	// closed is set once the provider is closed, after which its session and objects are gone.
+	closed bool
+}
+
+func newPKCS11KeyProvider(config *Config) (keyProvider, error) {
+	if config.PKCS11Module == "" {
+		return nil, errors.New("a module path must be set to use the pkcs11 key provider")
+	}
+
+	ctx, err := acquirePKCS11Module(config.PKCS11Module)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := ctx.OpenSession(uint(config.PKCS11Slot), pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		releasePKCS11Module(config.PKCS11Module, ctx)
+		return nil, fmt.Errorf("error opening pkcs11 session: %v", err)
+	}
+
+	if err = ctx.Login(session, pkcs11.CKU_USER, config.PKCS11PIN); err != nil && err != pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+		ctx.CloseSession(session)
+		releasePKCS11Module(config.PKCS11Module, ctx)
+		return nil, fmt.Errorf("error logging in to pkcs11 token: %v", err)
+	}
+
+	return &pkcs11KeyProvider{
+		module:  config.PKCS11Module,
+		ctx:     ctx,
+		session: session,
+		objects: make(map[string][]pkcs11.ObjectHandle),
+	}, nil
+}
+
+// acquirePKCS11Module loads and initializes a module, counting the provider using it.
+func acquirePKCS11Module(module string) (*pkcs11.Ctx, error) {
+	ctx := pkcs11.New(module)
+	if ctx == nil {
+		return nil, fmt.Errorf("could not load pkcs11 module %s", module)
+	}
+
+	pkcs11Modules.Lock()
+	defer pkcs11Modules.Unlock()
+
+	if err := ctx.Initialize(); err != nil && err != pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		ctx.Destroy()
+		return nil, fmt.Errorf("error initializing pkcs11 module: %v", err)
+	}
+
+	pkcs11Modules.refs[module]++
+	return ctx, nil
+}
+
+// releasePKCS11Module stops counting a provider using a module, finalizing the module if no provider still uses it.
+func releasePKCS11Module(module string, ctx *pkcs11.Ctx) error {
+	pkcs11Modules.Lock()
+	defer pkcs11Modules.Unlock()
+
+	pkcs11Modules.refs[module]--
+	if pkcs11Modules.refs[module] > 0 {
+		ctx.Destroy()
+		return nil
+	}
+
+	delete(pkcs11Modules.refs, module)
+	err := ctx.Finalize()
+	ctx.Destroy()
+	return err
+}
+
+// close destroys every key object the provider created which has not already been destroyed, as keys can no longer
+// sign once the provider is replaced, then closes the session and releases the module.
+func (p *pkcs11KeyProvider) close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+
+	var errs []error
+	for kid := range p.objects {
+		if err := p.destroyObjects(kid); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := p.ctx.CloseSession(p.session); err != nil {
+		errs = append(errs, fmt.Errorf("error closing pkcs11 session: %v", err))
+	}
+
+	if err := releasePKCS11Module(p.module, p.ctx); err != nil {
+		errs = append(errs, fmt.Errorf("error finalizing pkcs11 module: %v", err))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
+// destroyObjects destroys the objects of a key on the token. The provider's lock must be held.
+func (p *pkcs11KeyProvider) destroyObjects(kid string) error {
+	for _, handle := range p.objects[kid] {
+		if err := p.ctx.DestroyObject(p.session, handle); err != nil {
+			return fmt.Errorf("error destroying key %s on pkcs11 token: %v", kid, err)
+		}
+	}
+
+	delete(p.objects, kid)
+	return nil
+}
+
+func (p *pkcs11KeyProvider) newKey() (string, crypto.Signer, error) {
+	kid, err := uuid.NewRandom()
+	if err != nil {
+		return "", nil, err
+	}
+
+	label := pkcs11KeyLabelPrefix + kid.String()
+
+	publicTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, 2048),
+		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, []byte(kid.String())),
+	}
+
+	privateTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, []byte(kid.String())),
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	publicHandle, privateHandle, err := p.ctx.GenerateKeyPair(p.session,
+		[]*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, nil)},
+		publicTemplate, privateTemplate)
+	if err != nil {
+		return "", nil, fmt.Errorf("error generating key on pkcs11 token: %v", err)
+	}
+	p.objects[kid.String()] = []pkcs11.ObjectHandle{publicHandle, privateHandle}
+
+	attributes, err := p.ctx.GetAttributeValue(p.session, publicHandle, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("error reading public key from pkcs11 token: %v", err)
+	}
+
+	publicKey := &rsa.PublicKey{}
+	for _, attribute := range attributes {
+		switch attribute.Type {
+		case pkcs11.CKA_MODULUS:
+			publicKey.N = new(big.Int).SetBytes(attribute.Value)
+		case pkcs11.CKA_PUBLIC_EXPONENT:
+			publicKey.E = int(new(big.Int).SetBytes(attribute.Value).Int64())
+		}
+	}
+
+	if publicKey.N == nil || publicKey.E == 0 {
+		return "", nil, errors.New("pkcs11 token returned an incomplete public key")
+	}
+
+	return kid.String(), &pkcs11Signer{provider: p, kid: kid.String(), handle: privateHandle, public: publicKey}, nil
+}
+
+// pkcs11Signer signs digests using a private key object on a PKCS#11 token.
+type pkcs11Signer struct {
+	provider *pkcs11KeyProvider
+	kid      string
+	handle   pkcs11.ObjectHandle
+	public   *rsa.PublicKey
+}
+
+// destroy destroys the key's objects on the token, so it can never sign again. Keys of a closed provider have
+// already been destroyed.
+func (s *pkcs11Signer) destroy() error {
+	s.provider.lock.Lock()
+	defer s.provider.lock.Unlock()
+
+	if s.provider.closed {
+		return nil
+	}
+
+	return s.provider.destroyObjects(s.kid)
+}
+
+func (s *pkcs11Signer) Public() crypto.PublicKey {
+	return s.public
+}
+
+func (s *pkcs11Signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if _, ok := opts.(*rsa.PSSOptions); ok {
+		return nil, errors.New("the pkcs11 key provider does not support PSS signatures")
+	}
+
+	prefix, ok := digestInfoPrefixes[opts.HashFunc()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash function %v", opts.HashFunc())
+	}
+
+	s.provider.lock.Lock()
+	defer s.provider.lock.Unlock()
+
+	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)}
+	if err := s.provider.ctx.SignInit(s.provider.session, mechanism, s.handle); err != nil {
+		return nil, fmt.Errorf("error signing with pkcs11 token: %v", err)
+	}
+
+	return s.provider.ctx.Sign(s.provider.session, append(append([]byte{}, prefix...), digest...))
+}
@@ -0,0 +1,10 @@
+//go:build !cgo
+// +build !cgo
+
+package jwtsecrets
+
+import "errors"
+
+func newPKCS11KeyProvider(_ *Config) (keyProvider, error) {
+	return nil, errors.New("the pkcs11 key provider requires the plugin to be built with cgo")
+}
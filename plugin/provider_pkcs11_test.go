@@ -0,0 +1,93 @@
+//go:build cgo
+// +build cgo
+
+package jwtsecrets
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// TestPKCS11KeyProvider runs against a SoftHSM token. It is skipped unless SOFTHSM2_MODULE and
+// SOFTHSM2_PIN are set, and uses the slot in SOFTHSM2_SLOT if present.
+func TestPKCS11KeyProvider(t *testing.T) {
+	module := os.Getenv("SOFTHSM2_MODULE")
+	pin := os.Getenv("SOFTHSM2_PIN")
+	if module == "" || pin == "" {
+		t.Skip("SOFTHSM2_MODULE and SOFTHSM2_PIN must be set to test the pkcs11 key provider")
+	}
+
+	slot := 0
+	if rawSlot := os.Getenv("SOFTHSM2_SLOT"); rawSlot != "" {
+		var err error
+		if slot, err = strconv.Atoi(rawSlot); err != nil {
+			t.Fatalf("invalid SOFTHSM2_SLOT: %v", err)
+		}
+	}
+
+	b, storage := getTestBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			keyKeyProvider:  keyProviderPKCS11,
+			keyPKCS11Module: module,
+			keyPKCS11Slot:   slot,
+			keyPKCS11PIN:    pin,
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	if _, ok := resp.Data[keyPKCS11PIN]; ok {
+		t.Error("pkcs11 pin should not be returned")
+	}
+
+	token, err := getRawToken(b, storage, map[string]interface{}{"sub": "Zapp Brannigan"})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	resp, err = verifyToken(b, storage, token)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	// Rotation should create a new key object on the token.
+	newKey, err := b.getNewKey(jose.RS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if newKey.ID == b.keys[0].ID {
+		t.Error("expected rotation to create a new key")
+	}
+}
+
+func TestPKCS11KeyProviderRequiresModule(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			keyKeyProvider: keyProviderPKCS11,
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err == nil {
+		t.Errorf("Should have errored but got response: %#v", resp)
+	}
+}
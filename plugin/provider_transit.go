@@ -0,0 +1,202 @@
+package jwtsecrets
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// transitKeyProvider delegates key creation and signing to a key in the Transit secrets engine.
+// Each new signing key is a new version of the Transit key, so the key should not be shared with anything else.
+type transitKeyProvider struct {
+	client *api.Client
+	mount  string
+	name   string
+}
+
+func newTransitKeyProvider(config *Config) (*transitKeyProvider, error) {
+	if config.TransitKey == "" {
+		return nil, errors.New("a transit key name must be set to use the transit key provider")
+	}
+
+	clientConfig := api.DefaultConfig()
+	if config.TransitAddress != "" {
+		clientConfig.Address = config.TransitAddress
+	}
+
+	client, err := api.NewClient(clientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.TransitToken != "" {
+		client.SetToken(config.TransitToken)
+	}
+
+	provider := &transitKeyProvider{
+		client: client,
+		mount:  strings.Trim(config.TransitMount, "/"),
+		name:   config.TransitKey,
+	}
+
+	if err = provider.checkKeyType(); err != nil {
+		return nil, err
+	}
+
+	return provider, nil
+}
+
+// checkKeyType checks that the Transit key exists and is an RSA key, as tokens are signed with RS256.
+func (p *transitKeyProvider) checkKeyType() error {
+	secret, err := p.client.Logical().Read(p.mount + "/keys/" + p.name)
+	if err != nil {
+		return fmt.Errorf("error reading transit key: %v", err)
+	}
+	if secret == nil {
+		return fmt.Errorf("transit key %s not found", p.name)
+	}
+
+	keyType, _ := secret.Data["type"].(string)
+	if !strings.HasPrefix(keyType, "rsa-") {
+		return fmt.Errorf("transit key %s is of type %q, not an RSA key", p.name, keyType)
+	}
+
+	return nil
+}
+
+func (p *transitKeyProvider) newKey() (string, crypto.Signer, error) {
+	if _, err := p.client.Logical().Write(p.mount+"/keys/"+p.name+"/rotate", nil); err != nil {
+		return "", nil, fmt.Errorf("error rotating transit key: %v", err)
+	}
+
+	secret, err := p.client.Logical().Read(p.mount + "/keys/" + p.name)
+	if err != nil {
+		return "", nil, fmt.Errorf("error reading transit key: %v", err)
+	}
+	if secret == nil {
+		return "", nil, fmt.Errorf("transit key %s not found", p.name)
+	}
+
+	version, err := toInt(secret.Data["latest_version"])
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid transit key version: %v", err)
+	}
+
+	versions, ok := secret.Data["keys"].(map[string]interface{})
+	if !ok {
+		return "", nil, errors.New("transit key has no versions")
+	}
+
+	keyVersion, ok := versions[strconv.Itoa(version)].(map[string]interface{})
+	if !ok {
+		return "", nil, fmt.Errorf("transit key version %d has no public key", version)
+	}
+
+	rawPublicKey, ok := keyVersion["public_key"].(string)
+	if !ok {
+		return "", nil, fmt.Errorf("transit key version %d has no public key", version)
+	}
+
+	block, _ := pem.Decode([]byte(rawPublicKey))
+	if block == nil {
+		return "", nil, fmt.Errorf("transit key version %d has an invalid public key", version)
+	}
+
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return transitKeyID(p.name, version), &transitSigner{provider: p, version: version, public: publicKey}, nil
+}
+
+func (p *transitKeyProvider) close() error {
+	return nil
+}
+
+// transitKeyID maps a Transit key version to the ID of a signing key.
+func transitKeyID(name string, version int) string {
+	return fmt.Sprintf("%s-v%d", name, version)
+}
+
+// transitSigner signs digests using a single version of a Transit key.
+type transitSigner struct {
+	provider *transitKeyProvider
+	version  int
+	public   crypto.PublicKey
+}
+
+func (s *transitSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+func (s *transitSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var hashAlgorithm string
+	switch opts.HashFunc() {
+	case crypto.SHA256:
+		hashAlgorithm = "sha2-256"
+	case crypto.SHA384:
+		hashAlgorithm = "sha2-384"
+	case crypto.SHA512:
+		hashAlgorithm = "sha2-512"
+	default:
+		return nil, fmt.Errorf("unsupported hash function %v", opts.HashFunc())
+	}
+
+	signatureAlgorithm := "pkcs1v15"
+	if _, ok := opts.(*rsa.PSSOptions); ok {
+		signatureAlgorithm = "pss"
+	}
+
+	secret, err := s.provider.client.Logical().Write(s.provider.mount+"/sign/"+s.provider.name, map[string]interface{}{
+		"input":               base64.StdEncoding.EncodeToString(digest),
+		"prehashed":           true,
+		"hash_algorithm":      hashAlgorithm,
+		"signature_algorithm": signatureAlgorithm,
+		"key_version":         s.version,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error signing with transit key: %v", err)
+	}
+	if secret == nil {
+		return nil, errors.New("no signature returned by transit")
+	}
+
+	signature, ok := secret.Data["signature"].(string)
+	if !ok {
+		return nil, errors.New("no signature returned by transit")
+	}
+
+	// Signatures are formatted as vault:v{version}:{base64 signature}
+	parts := strings.SplitN(signature, ":", 3)
+	if len(parts) != 3 {
+		return nil, errors.New("invalid signature returned by transit")
+	}
+
+	return base64.StdEncoding.DecodeString(parts[2])
+}
+
+// toInt converts a number decoded from a Vault API response to an int.
+func toInt(raw interface{}) (int, error) {
+	switch n := raw.(type) {
+	case json.Number:
+		i, err := n.Int64()
+		return int(i), err
+	case float64:
+		return int(n), nil
+	case int:
+		return n, nil
+	default:
+		return 0, fmt.Errorf("%v was %T, not a number", raw, raw)
+	}
+}
@@ -0,0 +1,238 @@
+package jwtsecrets
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	testTransitToken = "transit-token"
+	testTransitKey   = "jwt"
+)
+
+// fakeTransit is a stand-in for the parts of the Transit secrets engine used by the transit key provider.
+type fakeTransit struct {
+	sync.Mutex
+	versions []*rsa.PrivateKey
+
+	// keyType is the type of the key, which is an RSA key if it is not set.
+	keyType string
+}
+
+func (f *fakeTransit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	if r.Header.Get("X-Vault-Token") != testTransitToken {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"errors":["permission denied"]}`)
+		return
+	}
+
+	var data map[string]interface{}
+	switch r.URL.Path {
+	case "/v1/transit/keys/" + testTransitKey + "/rotate":
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		f.versions = append(f.versions, key)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case "/v1/transit/keys/" + testTransitKey:
+		keys := make(map[string]interface{})
+		for i, key := range f.versions {
+			der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			keys[strconv.Itoa(i+1)] = map[string]interface{}{
+				"public_key": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+			}
+		}
+		keyType := f.keyType
+		if keyType == "" {
+			keyType = "rsa-2048"
+		}
+		data = map[string]interface{}{
+			"type":           keyType,
+			"latest_version": len(f.versions),
+			"keys":           keys,
+		}
+	case "/v1/transit/sign/" + testTransitKey:
+		var req struct {
+			Input      string `json:"input"`
+			Prehashed  bool   `json:"prehashed"`
+			KeyVersion int    `json:"key_version"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !req.Prehashed || req.KeyVersion < 1 || req.KeyVersion > len(f.versions) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		digest, err := base64.StdEncoding.DecodeString(req.Input)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		sig, err := rsa.SignPKCS1v15(rand.Reader, f.versions[req.KeyVersion-1], crypto.SHA256, digest)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		data = map[string]interface{}{
+			"signature": fmt.Sprintf("vault:v%d:%s", req.KeyVersion, base64.StdEncoding.EncodeToString(sig)),
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func TestTransitKeyProvider(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	transit := &fakeTransit{}
+	server := httptest.NewServer(transit)
+	defer server.Close()
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			keyKeyProvider:    keyProviderTransit,
+			keyTransitAddress: server.URL,
+			keyTransitToken:   testTransitToken,
+			keyTransitKey:     testTransitKey,
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	if _, ok := resp.Data[keyTransitToken]; ok {
+		t.Error("transit token should not be returned")
+	}
+
+	token, err := getRawToken(b, storage, map[string]interface{}{"sub": "Zapp Brannigan"})
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		t.Fatalf("error parsing jwt: %s", err)
+	}
+
+	if diff := deep.Equal(transitKeyID(testTransitKey, 1), parsed.Headers[0].KeyID); diff != nil {
+		t.Error(diff)
+	}
+
+	var claims jwt.Claims
+	if err = parsed.Claims(&transit.versions[0].PublicKey, &claims); err != nil {
+		t.Fatalf("error verifying jwt against transit key: %s", err)
+	}
+
+	keys := b.getPublicKeys().Keys
+	if len(keys) != 1 {
+		t.Fatalf("expected 1 published key, got %d", len(keys))
+	}
+
+	if diff := deep.Equal(&transit.versions[0].PublicKey, keys[0].Key); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestTransitKeyProviderRequiresKey(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			keyKeyProvider: keyProviderTransit,
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err == nil {
+		t.Errorf("Should have errored but got response: %#v", resp)
+	}
+}
+
+func TestTransitKeyProviderRequiresRSAKey(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	server := httptest.NewServer(&fakeTransit{keyType: "ecdsa-p256"})
+	defer server.Close()
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			keyKeyProvider:    keyProviderTransit,
+			keyTransitAddress: server.URL,
+			keyTransitToken:   testTransitToken,
+			keyTransitKey:     testTransitKey,
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err == nil {
+		t.Errorf("Should have errored but got response: %#v", resp)
+	}
+}
+
+func TestTransitKeyProviderNotExportable(t *testing.T) {
+	b, storage := getTestBackend(t)
+	b.config.Exportable = true
+
+	server := httptest.NewServer(&fakeTransit{})
+	defer server.Close()
+
+	b.config.TransitAddress = server.URL
+	b.config.TransitToken = testTransitToken
+	b.config.TransitKey = testTransitKey
+
+	provider, err := newTransitKeyProvider(b.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.config.keyProvider = provider
+
+	if _, err = b.getNewKey(jose.RS256); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := getBackup(b, storage)
+	if err == nil || resp != nil && !resp.IsError() || !strings.Contains(resp.Error().Error(), "cannot be exported") {
+		t.Fatalf("expected to get an error from backup. got:%v\n", resp)
+	}
+}
@@ -0,0 +1,328 @@
+package jwtsecrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+)
+
+// schemaTypes are the JSON Schema types which can be named by the 'type' keyword.
+var schemaTypes = map[string]bool{
+	"object":  true,
+	"array":   true,
+	"string":  true,
+	"number":  true,
+	"integer": true,
+	"boolean": true,
+	"null":    true,
+}
+
+// schemaKeywords are the JSON Schema keywords which are supported. Annotations which never constrain a value,
+// such as 'title', are also allowed.
+var schemaKeywords = map[string]bool{
+	"type":                 true,
+	"enum":                 true,
+	"const":                true,
+	"properties":           true,
+	"required":             true,
+	"additionalProperties": true,
+	"items":                true,
+	"contains":             true,
+	"pattern":              true,
+
+	"$schema":     true,
+	"$id":         true,
+	"$comment":    true,
+	"title":       true,
+	"description": true,
+	"default":     true,
+	"examples":    true,
+}
+
+// checkSchema checks that a JSON Schema only uses the supported keywords, and uses them correctly: 'type', 'enum',
+// 'const', 'properties', 'required', 'additionalProperties', 'items', 'contains' and 'pattern'. Other keywords are
+// rejected rather than ignored as in JSON Schema itself, so that constraints which would not be enforced cannot be
+// configured.
+func checkSchema(schema map[string]interface{}) error {
+	for keyword := range schema {
+		if !schemaKeywords[keyword] {
+			return fmt.Errorf("unsupported keyword %s", keyword)
+		}
+	}
+
+	if rawType, ok := schema["type"]; ok {
+		types, ok := schemaStrings(rawType)
+		if !ok {
+			return fmt.Errorf("'type' was %T, not a string or list of strings", rawType)
+		}
+		for _, t := range types {
+			if !schemaTypes[t] {
+				return fmt.Errorf("unknown type %s", t)
+			}
+		}
+	}
+
+	if rawEnum, ok := schema["enum"]; ok {
+		if _, ok := schemaArray(rawEnum); !ok {
+			return fmt.Errorf("'enum' was %T, not a list", rawEnum)
+		}
+	}
+
+	if rawProperties, ok := schema["properties"]; ok {
+		properties, ok := rawProperties.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("'properties' was %T, not an object", rawProperties)
+		}
+		for name, property := range properties {
+			if err := checkSubschema(property); err != nil {
+				return fmt.Errorf("property %s: %v", name, err)
+			}
+		}
+	}
+
+	if rawRequired, ok := schema["required"]; ok {
+		_, isArray := schemaArray(rawRequired)
+		if _, ok := schemaStrings(rawRequired); !isArray || !ok {
+			return fmt.Errorf("'required' was %T, not a list of strings", rawRequired)
+		}
+	}
+
+	if rawAdditional, ok := schema["additionalProperties"]; ok {
+		if _, ok := rawAdditional.(bool); !ok {
+			if err := checkSubschema(rawAdditional); err != nil {
+				return fmt.Errorf("additionalProperties: %v", err)
+			}
+		}
+	}
+
+	for _, keyword := range []string{"items", "contains"} {
+		if subschema, ok := schema[keyword]; ok {
+			if err := checkSubschema(subschema); err != nil {
+				return fmt.Errorf("%s: %v", keyword, err)
+			}
+		}
+	}
+
+	if rawPattern, ok := schema["pattern"]; ok {
+		pattern, ok := rawPattern.(string)
+		if !ok {
+			return fmt.Errorf("'pattern' was %T, not a string", rawPattern)
+		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func checkSubschema(rawSchema interface{}) error {
+	schema, ok := rawSchema.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("schema was %T, not an object", rawSchema)
+	}
+	return checkSchema(schema)
+}
+
+// validateSchema validates a value against a JSON Schema which has been checked with checkSchema.
+// The path locates the value in the document, and prefixes any error.
+func validateSchema(schema map[string]interface{}, value interface{}, path string) error {
+	if rawType, ok := schema["type"]; ok {
+		types, _ := schemaStrings(rawType)
+		matched := false
+		for _, t := range types {
+			if schemaTypeMatches(t, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: expected %v, got %T", path, rawType, value)
+		}
+	}
+
+	if rawEnum, ok := schema["enum"]; ok {
+		enum, _ := schemaArray(rawEnum)
+		matched := false
+		for _, allowed := range enum {
+			if schemaEqual(allowed, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: value not in %v", path, rawEnum)
+		}
+	}
+
+	if allowed, ok := schema["const"]; ok && !schemaEqual(allowed, value) {
+		return fmt.Errorf("%s: expected %v", path, allowed)
+	}
+
+	if object, ok := value.(map[string]interface{}); ok {
+		if err := validateSchemaObject(schema, object, path); err != nil {
+			return err
+		}
+	}
+
+	if array, ok := schemaArray(value); ok {
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range array {
+				if err := validateSchema(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+
+		if contains, ok := schema["contains"].(map[string]interface{}); ok {
+			matched := false
+			for i, item := range array {
+				if validateSchema(contains, item, fmt.Sprintf("%s[%d]", path, i)) == nil {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return fmt.Errorf("%s: no item matches 'contains'", path)
+			}
+		}
+	}
+
+	if pattern, ok := schema["pattern"].(string); ok {
+		if s, ok := value.(string); ok && !regexp.MustCompile(pattern).MatchString(s) {
+			return fmt.Errorf("%s: does not match %s", path, pattern)
+		}
+	}
+
+	return nil
+}
+
+func validateSchemaObject(schema map[string]interface{}, object map[string]interface{}, path string) error {
+	required, _ := schemaStrings(schema["required"])
+	for _, name := range required {
+		if _, ok := object[name]; !ok {
+			return fmt.Errorf("%s: missing required property %s", path, name)
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+
+	// Properties are validated in order, so the same invalid document always gives the same error.
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		propertyPath := path + "." + name
+		if property, ok := properties[name].(map[string]interface{}); ok {
+			if err := validateSchema(property, object[name], propertyPath); err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				return fmt.Errorf("%s: property not allowed", propertyPath)
+			}
+		case map[string]interface{}:
+			if err := validateSchema(additional, object[name], propertyPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func schemaTypeMatches(t string, value interface{}) bool {
+	switch t {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := schemaArray(value)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	case "number":
+		_, ok := schemaNumber(value)
+		return ok
+	case "integer":
+		n, ok := schemaNumber(value)
+		return ok && n == math.Trunc(n)
+	default:
+		return false
+	}
+}
+
+// schemaNumber returns the value of a number decoded from JSON or set directly.
+func schemaNumber(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+func schemaArray(value interface{}) ([]interface{}, bool) {
+	switch a := value.(type) {
+	case []interface{}:
+		return a, true
+	case []string:
+		converted := make([]interface{}, len(a))
+		for i, s := range a {
+			converted[i] = s
+		}
+		return converted, true
+	default:
+		return nil, false
+	}
+}
+
+// schemaStrings returns a string or list of strings as a list.
+func schemaStrings(value interface{}) ([]string, bool) {
+	if s, ok := value.(string); ok {
+		return []string{s}, true
+	}
+
+	array, ok := schemaArray(value)
+	if !ok {
+		return nil, false
+	}
+
+	strs := make([]string, len(array))
+	for i, item := range array {
+		if strs[i], ok = item.(string); !ok {
+			return nil, false
+		}
+	}
+	return strs, true
+}
+
+// schemaEqual compares two JSON values by their encoding, so numbers compare equal whatever type they were decoded as.
+func schemaEqual(a, b interface{}) bool {
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(encodedA, encodedB)
+}
@@ -0,0 +1,123 @@
+package jwtsecrets
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const testCredentialSchema = `{
+	"type": "object",
+	"required": ["type", "credentialSubject"],
+	"properties": {
+		"type": {
+			"type": "array",
+			"items": {"type": "string"},
+			"contains": {"const": "DeliveryCredential"}
+		},
+		"credentialSubject": {
+			"type": "object",
+			"required": ["id", "deliveries"],
+			"additionalProperties": false,
+			"properties": {
+				"id": {"type": "string", "pattern": "^did:"},
+				"deliveries": {"type": "integer"},
+				"rank": {"enum": ["Captain", "Delivery Boy"]}
+			}
+		}
+	}
+}`
+
+func decodeTestSchema(t *testing.T) map[string]interface{} {
+	var schema map[string]interface{}
+	if err := json.Unmarshal([]byte(testCredentialSchema), &schema); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if err := checkSchema(schema); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	return schema
+}
+
+func TestValidateSchema(t *testing.T) {
+	schema := decodeTestSchema(t)
+
+	valid := []map[string]interface{}{
+		{
+			"type":              []interface{}{"VerifiableCredential", "DeliveryCredential"},
+			"credentialSubject": map[string]interface{}{"id": "did:example:fry", "deliveries": 3},
+		},
+		{
+			"type":              []string{"DeliveryCredential"},
+			"credentialSubject": map[string]interface{}{"id": "did:example:leela", "deliveries": json.Number("12"), "rank": "Captain"},
+		},
+	}
+
+	for _, value := range valid {
+		if err := validateSchema(schema, value, "vc"); err != nil {
+			t.Errorf("expected %#v to be valid, got %v", value, err)
+		}
+	}
+
+	invalid := []map[string]interface{}{
+		// Missing the required type.
+		{
+			"type":              []interface{}{"VerifiableCredential"},
+			"credentialSubject": map[string]interface{}{"id": "did:example:fry", "deliveries": 3},
+		},
+		// Not an integer.
+		{
+			"type":              []interface{}{"DeliveryCredential"},
+			"credentialSubject": map[string]interface{}{"id": "did:example:fry", "deliveries": 3.5},
+		},
+		// Not matching the pattern.
+		{
+			"type":              []interface{}{"DeliveryCredential"},
+			"credentialSubject": map[string]interface{}{"id": "Fry", "deliveries": 3},
+		},
+		// Not in the enum.
+		{
+			"type":              []interface{}{"DeliveryCredential"},
+			"credentialSubject": map[string]interface{}{"id": "did:example:fry", "deliveries": 3, "rank": "Intern"},
+		},
+		// Additional property.
+		{
+			"type":              []interface{}{"DeliveryCredential"},
+			"credentialSubject": map[string]interface{}{"id": "did:example:fry", "deliveries": 3, "species": "Human"},
+		},
+		// Missing a required property.
+		{
+			"type":              []interface{}{"DeliveryCredential"},
+			"credentialSubject": map[string]interface{}{"id": "did:example:fry"},
+		},
+	}
+
+	for _, value := range invalid {
+		if err := validateSchema(schema, value, "vc"); err == nil {
+			t.Errorf("expected %#v to be invalid", value)
+		}
+	}
+}
+
+func TestCheckInvalidSchema(t *testing.T) {
+	schemas := []map[string]interface{}{
+		{"type": "planet"},
+		{"type": 7},
+		{"properties": []interface{}{"id"}},
+		{"properties": map[string]interface{}{"id": "string"}},
+		{"required": "id"},
+		{"items": map[string]interface{}{"pattern": "("}},
+		{"enum": "Captain"},
+		{"type": "string", "minLength": 1},
+		{"properties": map[string]interface{}{"deliveries": map[string]interface{}{"maximum": 100}}},
+		{"oneOf": []interface{}{map[string]interface{}{"type": "string"}}},
+		{"$ref": "#/definitions/subject"},
+	}
+
+	for _, schema := range schemas {
+		if err := checkSchema(schema); err == nil {
+			t.Errorf("expected %#v to be invalid", schema)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package jwtsecrets
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// keyDisclosable is the field naming the claims of an SD-JWT which are selectively disclosable.
+	keyDisclosable = "disclosable"
+
+	// sdClaim and sdAlgorithmClaim hold the digests of the disclosures of an SD-JWT and the hash algorithm used.
+	sdClaim          = "_sd"
+	sdAlgorithmClaim = "_sd_alg"
+	sdHashAlgorithm  = "sha-256"
+
+	// sdSaltSize is the size in bytes of the salt of each disclosure, as recommended by the SD-JWT specification.
+	sdSaltSize = 16
+
+	// sdSeparator separates the issuer-signed JWT and the disclosures of an SD-JWT.
+	sdSeparator = "~"
+)
+
+// discloseClaims replaces the named claims with the digests of salted disclosures in the '_sd' claim, returning
+// the disclosures. Only claims in callerClaims, those set by the caller, can be disclosable, so reserved claims,
+// claims mapped from the caller's identity and default audiences never are.
+func discloseClaims(claims map[string]interface{}, callerClaims map[string]bool, names []string) ([]string, error) {
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] {
+			return nil, fmt.Errorf("disclosable claim %s listed more than once", name)
+		}
+		seen[name] = true
+
+		for _, reserved := range ReservedClaims {
+			if name == reserved {
+				return nil, fmt.Errorf("claim %s cannot be selectively disclosable", name)
+			}
+		}
+
+		if !callerClaims[name] {
+			return nil, fmt.Errorf("disclosable claim %s not set by the caller", name)
+		}
+	}
+
+	disclosures := make([]string, 0, len(names))
+	digests := make([]string, 0, len(names))
+	for _, name := range names {
+		salt := make([]byte, sdSaltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+
+		disclosure, err := json.Marshal([]interface{}{base64.RawURLEncoding.EncodeToString(salt), name, claims[name]})
+		if err != nil {
+			return nil, err
+		}
+
+		encoded := base64.RawURLEncoding.EncodeToString(disclosure)
+		disclosures = append(disclosures, encoded)
+		digests = append(digests, disclosureDigest(encoded))
+
+		delete(claims, name)
+	}
+
+	// The digests are sorted so their order does not reveal which claims they disclose.
+	sort.Strings(digests)
+
+	claims[sdClaim] = digests
+	claims[sdAlgorithmClaim] = sdHashAlgorithm
+
+	return disclosures, nil
+}
+
+// disclosureDigest returns the base64url encoded SHA-256 digest of an encoded disclosure.
+func disclosureDigest(disclosure string) string {
+	digest := sha256.Sum256([]byte(disclosure))
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+// combineSDJWT returns the serialization of an SD-JWT with all its disclosures and no key binding JWT.
+func combineSDJWT(token string, disclosures []string) string {
+	var combined strings.Builder
+	combined.WriteString(token + sdSeparator)
+	for _, disclosure := range disclosures {
+		combined.WriteString(disclosure + sdSeparator)
+	}
+	return combined.String()
+}
@@ -0,0 +1,260 @@
+package jwtsecrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func signSDJWT(t *testing.T, b *backend, storage *logical.Storage, data map[string]interface{}) *logical.Response {
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "sign",
+		Storage:   *storage,
+		Data:      data,
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	return resp
+}
+
+// decodeDisclosure checks that a disclosure's digest is listed in the '_sd' claim and returns its claim name and value.
+func decodeDisclosure(t *testing.T, disclosure string, digests []interface{}) (string, interface{}) {
+	found := false
+	for _, digest := range digests {
+		if digest == disclosureDigest(disclosure) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("digest of disclosure %s not in %#v", disclosure, digests)
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(disclosure)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	var parts []interface{}
+	if err := json.Unmarshal(decoded, &parts); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	if len(parts) != 3 {
+		t.Fatalf("expected a salt, claim name and value, got %#v", parts)
+	}
+
+	salt, err := base64.RawURLEncoding.DecodeString(parts[0].(string))
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+	if len(salt) != sdSaltSize {
+		t.Errorf("expected a %d byte salt, got %d bytes", sdSaltSize, len(salt))
+	}
+
+	return parts[1].(string), parts[2]
+}
+
+func TestSignSDJWT(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	resp := signSDJWT(t, b, storage, map[string]interface{}{
+		"claims": map[string]interface{}{
+			"sub": "Hermes Conrad",
+			"aud": []interface{}{"Central Bureaucracy"},
+		},
+		keyDisclosable: "sub,aud",
+	})
+
+	token := resp.Data["token"].(string)
+	disclosures := resp.Data["disclosures"].([]string)
+
+	if diff := deep.Equal(token+"~"+disclosures[0]+"~"+disclosures[1]+"~", resp.Data["sd_jwt"]); diff != nil {
+		t.Error(diff)
+	}
+
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	var claims map[string]interface{}
+	if err := parsed.Claims(b.keys[0].Key.Public(), &claims); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	digests := claims["_sd"].([]interface{})
+	if len(digests) != 2 {
+		t.Fatalf("expected 2 digests, got %#v", digests)
+	}
+
+	delete(claims, "_sd")
+	expectedClaims := map[string]interface{}{
+		"_sd_alg": "sha-256",
+		"exp":     float64(5 * 60),
+		"iat":     float64(0),
+		"nbf":     float64(0),
+		"iss":     testIssuer,
+		"jti":     "1",
+	}
+
+	if diff := deep.Equal(expectedClaims, claims); diff != nil {
+		t.Error(diff)
+	}
+
+	disclosed := make(map[string]interface{})
+	for _, disclosure := range disclosures {
+		name, value := decodeDisclosure(t, disclosure, digests)
+		disclosed[name] = value
+	}
+
+	expectedDisclosed := map[string]interface{}{
+		"sub": "Hermes Conrad",
+		"aud": []interface{}{"Central Bureaucracy"},
+	}
+
+	if diff := deep.Equal(expectedDisclosed, disclosed); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestSignSDJWTKeyBinding(t *testing.T) {
+	b, storage := getTestBackend(t)
+	client := newDPoPClient(t)
+
+	resp := signSDJWT(t, b, storage, map[string]interface{}{
+		"claims": map[string]interface{}{
+			"sub": "Hermes Conrad",
+		},
+		keyDisclosable: "sub",
+		keyClientJWK:   client.jwk(t),
+	})
+
+	parsed, err := jwt.ParseSigned(resp.Data["token"].(string))
+	if err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	var claims map[string]interface{}
+	if err := parsed.Claims(b.keys[0].Key.Public(), &claims); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	var expectedJWK map[string]interface{}
+	if err := json.Unmarshal([]byte(client.jwk(t)), &expectedJWK); err != nil {
+		t.Fatalf("%v\n", err)
+	}
+
+	expectedConfirmation := map[string]interface{}{
+		"jkt": client.thumbprint(t),
+		"jwk": expectedJWK,
+	}
+
+	if diff := deep.Equal(expectedConfirmation, claims["cnf"]); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestSDJWTRefresh(t *testing.T) {
+	b, storage := getTestBackend(t)
+	enableRefreshTokens(t, b, storage)
+
+	resp := signSDJWT(t, b, storage, map[string]interface{}{
+		"claims": map[string]interface{}{
+			"sub": "Hermes Conrad",
+		},
+		keyDisclosable: "sub",
+	})
+
+	resp, err := refresh(b, storage, resp.Data[keyRefreshToken].(string))
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	disclosures := resp.Data["disclosures"].([]string)
+	if len(disclosures) != 1 {
+		t.Fatalf("expected a disclosure, got %#v", disclosures)
+	}
+
+	if !strings.HasPrefix(resp.Data["sd_jwt"].(string), resp.Data["token"].(string)+"~") {
+		t.Errorf("expected the refreshed token to be an SD-JWT, got %#v", resp.Data)
+	}
+}
+
+func TestSDJWTBackendClaimsNotDisclosable(t *testing.T) {
+	b, storage := getTestBackend(t)
+	b.System().(*logical.StaticSystemView).EntityVal = testEntity
+
+	if resp, err := writeConfig(b, storage, map[string]interface{}{
+		keyClaimMappings:         map[string]interface{}{"name": "entity.name"},
+		keyDefaultAudienceSource: "metadata:role",
+	}); err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v", err, resp)
+	}
+
+	sign := func(disclosable string) (*logical.Response, error) {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "sign",
+			Storage:   *storage,
+			EntityID:  testEntity.ID,
+			Data: map[string]interface{}{
+				"claims":       map[string]interface{}{"sub": "Hermes Conrad"},
+				keyDisclosable: disclosable,
+			},
+		}
+		req.SetTokenEntry(&logical.TokenEntry{Meta: map[string]string{"role": "cook"}})
+		return b.HandleRequest(context.Background(), req)
+	}
+
+	// The mapped 'name' claim and the default 'aud' claim are added by the backend.
+	for _, disclosable := range []string{"name", "aud"} {
+		if resp, err := sign(disclosable); err == nil || resp != nil && !resp.IsError() {
+			t.Errorf("expected an error disclosing %s, got %#v", disclosable, resp)
+		}
+	}
+
+	if resp, err := sign("sub"); err != nil || (resp != nil && resp.IsError()) {
+		t.Errorf("err:%s resp:%#v", err, resp)
+	}
+}
+
+func TestSDJWTInvalidDisclosable(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	tests := []map[string]interface{}{
+		// Reserved claims are never disclosable.
+		{"claims": map[string]interface{}{"sub": "Hermes Conrad"}, keyDisclosable: "exp"},
+		// Disclosable claims must be set by the caller.
+		{"claims": map[string]interface{}{"sub": "Hermes Conrad"}, keyDisclosable: "aud"},
+		{"claims": map[string]interface{}{"sub": "Hermes Conrad"}, keyDisclosable: "sub,sub"},
+		// Only JWTs can be SD-JWTs.
+		{"claims": map[string]interface{}{"sub": "Hermes Conrad"}, keyDisclosable: "sub", "format": "cwt"},
+		// The '_sd' claim cannot be set by the caller.
+		{"claims": map[string]interface{}{"_sd": []interface{}{"forged"}}},
+	}
+
+	for _, data := range tests {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "sign",
+			Storage:   *storage,
+			Data:      data,
+		}
+
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err == nil || resp != nil && !resp.IsError() {
+			t.Errorf("expected an error signing %#v, got %#v", data, resp)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package jwtsecrets
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	secretTypeToken = "jwt"
+
+	revokedTokensPrefix = "revoked/tokens/"
+)
+
+// tokenRevocation records a token which was revoked before it expired.
+type tokenRevocation struct {
+	JTI       string    `json:"jti"`
+	ExpiresAt time.Time `json:"expires_at"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+func secretToken(b *backend) *framework.Secret {
+	return &framework.Secret{
+		Type: secretTypeToken,
+		Fields: map[string]*framework.FieldSchema{
+			"token": {
+				Type:        framework.TypeString,
+				Description: `Signed JWT.`,
+			},
+		},
+		Revoke: b.secretTokenRevoke,
+	}
+}
+
+func (b *backend) secretTokenRevoke(c context.Context, r *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	jti, ok := r.Secret.InternalData["jti"].(string)
+	if !ok || jti == "" {
+		return nil, errors.New("secret is missing its 'jti'")
+	}
+
+	rawExpiry, ok := r.Secret.InternalData["expires_at"].(string)
+	if !ok {
+		return nil, errors.New("secret is missing its expiry time")
+	}
+
+	expiry, err := time.Parse(time.RFC3339, rawExpiry)
+	if err != nil {
+		return nil, err
+	}
+
+	now := b.clock.now()
+	if !expiry.After(now) {
+		// The token can no longer be used, so there is nothing to revoke.
+		return nil, nil
+	}
+
+	entry, err := logical.StorageEntryJSON(revokedTokensPrefix+jti, &tokenRevocation{
+		JTI:       jti,
+		ExpiresAt: expiry,
+		RevokedAt: now,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, r.Storage.Put(c, entry)
+}
+
+// isTokenRevoked checks if the token with the given 'jti' claim has been revoked.
+func (b *backend) isTokenRevoked(c context.Context, s logical.Storage, jti string) (bool, error) {
+	entry, err := s.Get(c, revokedTokensPrefix+jti)
+	if err != nil {
+		return false, err
+	}
+
+	return entry != nil, nil
+}
+
+// getRevokedTokens returns the revocations of every token which has not yet expired.
+func (b *backend) getRevokedTokens(c context.Context, s logical.Storage) ([]*tokenRevocation, error) {
+	jtis, err := s.List(c, revokedTokensPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	now := b.clock.now()
+
+	revocations := make([]*tokenRevocation, 0, len(jtis))
+	for _, jti := range jtis {
+		entry, err := s.Get(c, revokedTokensPrefix+jti)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			continue
+		}
+
+		var revocation tokenRevocation
+		if err = entry.DecodeJSON(&revocation); err != nil {
+			return nil, err
+		}
+
+		if revocation.ExpiresAt.After(now) {
+			revocations = append(revocations, &revocation)
+		}
+	}
+
+	return revocations, nil
+}
+
+// tidyRevokedTokens removes revocations of tokens which have expired, as they can no longer be used.
+func (b *backend) tidyRevokedTokens(c context.Context, r *logical.Request) error {
+	jtis, err := r.Storage.List(c, revokedTokensPrefix)
+	if err != nil {
+		return err
+	}
+
+	now := b.clock.now()
+
+	for _, jti := range jtis {
+		entry, err := r.Storage.Get(c, revokedTokensPrefix+jti)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			continue
+		}
+
+		var revocation tokenRevocation
+		if err = entry.DecodeJSON(&revocation); err != nil {
+			return err
+		}
+
+		if !revocation.ExpiresAt.After(now) {
+			if err = r.Storage.Delete(c, revokedTokensPrefix+jti); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package jwtsecrets
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestLeasedToken(t *testing.T) {
+	b, storage := getTestBackend(t)
+	b.config.LeaseTokens = true
+	b.config.SetJTI = false
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "sign",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			"claims": map[string]interface{}{"sub": "Zapp Brannigan"},
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	if resp.Secret == nil {
+		t.Fatal("expected token to be returned with a lease")
+	}
+
+	if diff := deep.Equal(b.config.TokenTTL, resp.Secret.TTL); diff != nil {
+		t.Error(diff)
+	}
+
+	token := resp.Data["token"].(string)
+	secret := resp.Secret
+
+	verifyResp, err := verifyToken(b, storage, token)
+	if err != nil || (verifyResp != nil && verifyResp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, verifyResp)
+	}
+
+	// The 'jti' claim must be set even though set_jti is false.
+	claims := verifyResp.Data["claims"].(map[string]interface{})
+	if diff := deep.Equal("1", claims["jti"]); diff != nil {
+		t.Error(diff)
+	}
+
+	req = &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   *storage,
+		Secret:    secret,
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	verifyResp, err = verifyToken(b, storage, token)
+	if err == nil || verifyResp != nil && !verifyResp.IsError() {
+		t.Fatalf("expected to get an error from verify. got:%v\n", verifyResp)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "revoked/tokens",
+		Storage:   *storage,
+	}
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	expectedTokens := []map[string]interface{}{
+		{
+			"jti":        "1",
+			"expires_at": time.Unix(5*60, 0).Format(time.RFC3339),
+		},
+	}
+
+	if diff := deep.Equal(expectedTokens, resp.Data["tokens"]); diff != nil {
+		t.Error(diff)
+	}
+
+	// Once the token has expired its revocation should be tidied away.
+	b.clock = &fakeClock{time.Unix(0, 0).Add(time.Hour)}
+
+	if err = b.tidyRevokedTokens(context.Background(), &logical.Request{Storage: *storage}); err != nil {
+		t.Fatal(err)
+	}
+
+	jtis, err := (*storage).List(context.Background(), revokedTokensPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(jtis) != 0 {
+		t.Errorf("expected expired revocations to be removed, got %v", jtis)
+	}
+}
+
+func TestUnleasedToken(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "sign",
+		Storage:   *storage,
+		Data: map[string]interface{}{
+			"claims": map[string]interface{}{"sub": "Zapp Brannigan"},
+		},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	if resp.Secret != nil {
+		t.Errorf("expected token to be returned without a lease, got %#v", resp.Secret)
+	}
+}
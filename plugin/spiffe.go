@@ -0,0 +1,140 @@
+package jwtsecrets
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+const (
+	// profileSPIFFE restricts signed tokens to valid JWT-SVIDs.
+	profileSPIFFE = "spiffe"
+
+	// spiffeScheme is the URI scheme of SPIFFE IDs.
+	spiffeScheme = "spiffe://"
+
+	// spiffeKeyUse is the 'use' of keys in a SPIFFE bundle which verify JWT-SVIDs.
+	spiffeKeyUse = "jwt-svid"
+)
+
+// spiffeAlgorithms are the algorithms a JWT-SVID may be signed with.
+var spiffeAlgorithms = map[jose.SignatureAlgorithm]bool{
+	jose.RS256: true,
+	jose.RS384: true,
+	jose.RS512: true,
+	jose.ES256: true,
+	jose.ES384: true,
+	jose.ES512: true,
+	jose.PS256: true,
+	jose.PS384: true,
+	jose.PS512: true,
+}
+
+// validateSPIFFEConfig checks that the config can be used to sign JWT-SVIDs.
+func validateSPIFFEConfig(config *Config) error {
+	if err := validateSPIFFETrustDomain(config.SPIFFETrustDomain); err != nil {
+		return fmt.Errorf("invalid SPIFFE trust domain: %v", err)
+	}
+
+	if !spiffeAlgorithms[config.SigningAlgorithm] {
+		return fmt.Errorf("JWT-SVIDs cannot be signed with %s", config.SigningAlgorithm)
+	}
+
+	return nil
+}
+
+// validateSPIFFEClaims checks that a set of claims supplied by a caller forms a valid JWT-SVID:
+// the subject must be a SPIFFE ID in the configured trust domain, and at least one audience must be set.
+func validateSPIFFEClaims(config *Config, claims map[string]interface{}) error {
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return errors.New("'sub' claim is required")
+	}
+
+	if err := validateSPIFFEID(sub, config.SPIFFETrustDomain); err != nil {
+		return fmt.Errorf("'sub' claim is not a valid SPIFFE ID: %v", err)
+	}
+
+	if !hasAudience(claims) {
+		return errors.New("'aud' claim is required")
+	}
+
+	return nil
+}
+
+// hasAudience returns whether at least one audience is set in a validated set of claims.
+func hasAudience(claims map[string]interface{}) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud != ""
+	case []string:
+		return len(aud) > 0
+	default:
+		return false
+	}
+}
+
+// validateSPIFFEID checks that id is a SPIFFE ID of a workload in the given trust domain.
+func validateSPIFFEID(id, trustDomain string) error {
+	if !strings.HasPrefix(id, spiffeScheme) {
+		return fmt.Errorf("scheme must be %s", spiffeScheme)
+	}
+
+	rest := strings.TrimPrefix(id, spiffeScheme)
+
+	slash := strings.IndexByte(rest, '/')
+	if slash < 0 {
+		return errors.New("path is required")
+	}
+
+	if err := validateSPIFFETrustDomain(rest[:slash]); err != nil {
+		return err
+	}
+
+	if rest[:slash] != trustDomain {
+		return fmt.Errorf("trust domain must be %s", trustDomain)
+	}
+
+	for _, segment := range strings.Split(rest[slash+1:], "/") {
+		if segment == "" {
+			return errors.New("path segments cannot be empty")
+		}
+
+		if segment == "." || segment == ".." {
+			return errors.New("path segments cannot be relative")
+		}
+
+		for _, c := range segment {
+			if !isSPIFFEPathChar(c) {
+				return fmt.Errorf("path cannot contain %q", c)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateSPIFFETrustDomain checks that a trust domain name only contains the characters permitted by the SPIFFE ID specification.
+func validateSPIFFETrustDomain(trustDomain string) error {
+	if trustDomain == "" {
+		return errors.New("trust domain is required")
+	}
+
+	for _, c := range trustDomain {
+		if !isSPIFFETrustDomainChar(c) {
+			return fmt.Errorf("trust domain cannot contain %q", c)
+		}
+	}
+
+	return nil
+}
+
+func isSPIFFETrustDomainChar(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_'
+}
+
+func isSPIFFEPathChar(c rune) bool {
+	return isSPIFFETrustDomainChar(c) || c >= 'A' && c <= 'Z'
+}
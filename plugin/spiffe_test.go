@@ -0,0 +1,114 @@
+package jwtsecrets
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const testTrustDomain = "example.org"
+
+func writeConfig(b *backend, storage *logical.Storage, data map[string]interface{}) (*logical.Response, error) {
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   *storage,
+		Data:      data,
+	}
+
+	return b.HandleRequest(context.Background(), req)
+}
+
+func TestValidateSPIFFEID(t *testing.T) {
+	valid := []string{
+		"spiffe://example.org/workload",
+		"spiffe://example.org/ns/default/sa/Service_Account-1.2",
+	}
+
+	for _, id := range valid {
+		if err := validateSPIFFEID(id, testTrustDomain); err != nil {
+			t.Errorf("%s: %v", id, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"https://example.org/workload",
+		"SPIFFE://example.org/workload",
+		"spiffe://example.org",
+		"spiffe://example.org/",
+		"spiffe://other.org/workload",
+		"spiffe://Example.org/workload",
+		"spiffe://example.org:8080/workload",
+		"spiffe://user@example.org/workload",
+		"spiffe://example.org/workload/",
+		"spiffe://example.org//workload",
+		"spiffe://example.org/../workload",
+		"spiffe://example.org/./workload",
+		"spiffe://example.org/workload?query",
+		"spiffe://example.org/workload#fragment",
+		"spiffe://example.org/work%20load",
+	}
+
+	for _, id := range invalid {
+		if err := validateSPIFFEID(id, testTrustDomain); err == nil {
+			t.Errorf("%s: expected error", id)
+		}
+	}
+}
+
+func TestSPIFFEConfig(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	rejected := map[string]map[string]interface{}{
+		"no trust domain":      {keyProfile: profileSPIFFE},
+		"invalid trust domain": {keyProfile: profileSPIFFE, keySPIFFETrustDomain: "Example.org"},
+		"hmac":                 {keyProfile: profileSPIFFE, keySPIFFETrustDomain: testTrustDomain, keySigningAlgorithm: "HS256"},
+		"unknown profile":      {keyProfile: "unknown"},
+	}
+
+	for name, data := range rejected {
+		resp, err := writeConfig(b, storage, data)
+		if err == nil || resp != nil && !resp.IsError() {
+			t.Errorf("%s: expected error, got resp:%#v\n", name, resp)
+		}
+	}
+
+	if b.config.Profile != "" {
+		t.Errorf("expected rejected updates to leave the profile unset, got %s", b.config.Profile)
+	}
+}
+
+func TestSignJWTSVID(t *testing.T) {
+	b, storage := getTestBackend(t)
+
+	resp, err := writeConfig(b, storage, map[string]interface{}{
+		keyProfile:           profileSPIFFE,
+		keySPIFFETrustDomain: testTrustDomain,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("err:%s resp:%#v\n", err, resp)
+	}
+
+	if _, err = getRawToken(b, storage, map[string]interface{}{
+		"sub": "spiffe://example.org/workload",
+		"aud": []string{"spiffe://example.org/database"},
+	}); err != nil {
+		t.Errorf("%v\n", err)
+	}
+
+	rejected := map[string]map[string]interface{}{
+		"no subject":         {"aud": "database"},
+		"other trust domain": {"sub": "spiffe://other.org/workload", "aud": "database"},
+		"not a SPIFFE ID":    {"sub": "Zapp Brannigan", "aud": "database"},
+		"no audience":        {"sub": "spiffe://example.org/workload"},
+		"empty audience":     {"sub": "spiffe://example.org/workload", "aud": []string{}},
+	}
+
+	for name, claims := range rejected {
+		if _, err = getRawToken(b, storage, claims); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}